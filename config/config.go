@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"path/filepath"
+	"strings"
 
 	"gopkg.in/ini.v1"
 )
@@ -16,13 +17,73 @@ type ServerConfig struct {
 
 // JWT配置结构体
 type JWTConfig struct {
-	Secret string `ini:"JWT密钥"` // JWT签名密钥
+	Secret            string `ini:"JWT密钥"`   // JWT签名密钥
+	ExpireTime        int    `ini:"JWT有效期"`  // 访问令牌有效期（秒）
+	RefreshExpireTime int    `ini:"刷新令牌有效期"` // 刷新令牌有效期（秒）
+}
+
+// 示例/默认的JWT密钥，使用这些密钥时不允许签发JWT
+var placeholderJWTSecrets = []string{
+	"default-secret-key-please-change-in-production",
+	"your-very-secret-key-here-change-in-production",
+}
+
+// 会话配置结构体
+type SessionConfig struct {
+	Secret string `ini:"会话密钥"`  // 会话Cookie签名/加密密钥
+	MaxAge int    `ini:"会话有效期"` // 会话有效期（秒）
+}
+
+// 登录保护配置结构体
+type LoginGuardConfig struct {
+	AccountMaxFailures int `ini:"账号失败次数上限"` // 同一账号连续失败达到该次数后锁定
+	IPMaxFailures      int `ini:"IP失败次数上限"` // 同一IP连续失败达到该次数后锁定
+	LockoutSeconds     int `ini:"锁定时长"`     // 锁定时长（秒）
+	BackoffSeconds     int `ini:"退避基数"`     // 失败后的等待基数（秒），每次失败翻倍
+	SuspiciousFailures int `ini:"可疑登录失败次数"` // 登录成功前连续失败达到该次数时标记为可疑登录
+	HistoryDays        int `ini:"登录记录保留天数"` // 登录记录保留天数
+}
+
+// 密码策略配置结构体
+type PasswordPolicyConfig struct {
+	MinLength    int `ini:"最小长度"`        // 密码最小长度
+	MinClasses   int `ini:"最少字符类别"`      // 至少包含的字符类别数（小写、大写、数字、符号）
+	HistoryCount int `ini:"禁止重复使用最近密码数"` // 不能与最近使用过的几个密码相同
+}
+
+// 图形验证码配置结构体
+type CaptchaConfig struct {
+	Always        bool `ini:"始终启用"`   // 每次登录都需要图形验证码
+	AfterFailures int  `ini:"失败次数触发"` // 连续登录失败达到该次数后需要图形验证码
+	ExpireSeconds int  `ini:"有效期"`    // 验证码有效期（秒）
+}
+
+// 跨域配置结构体
+type CORSConfig struct {
+	AllowOrigins     string `ini:"允许来源"`   // 允许跨域访问的前端地址，多个用逗号分隔，留空表示只允许同源访问，*表示允许所有来源（不携带Cookie）
+	AllowMethods     string `ini:"允许方法"`   // 允许的HTTP方法，多个用逗号分隔
+	AllowHeaders     string `ini:"允许请求头"`  // 允许的请求头，多个用逗号分隔
+	ExposeHeaders    string `ini:"暴露响应头"`  // 允许前端读取的响应头，多个用逗号分隔
+	AllowCredentials bool   `ini:"允许携带凭证"` // 是否允许跨域请求携带Cookie
+	MaxAge           int    `ini:"预检缓存时间"` // 预检请求缓存时间（秒）
+}
+
+// 代理到期检查配置结构体
+type ExpiryConfig struct {
+	SweepInterval  int  `ini:"检查间隔"`   // 后台检查已到期代理的间隔（秒）
+	DisableExpired bool `ini:"到期自动禁用"` // 是否自动禁用已到期的代理，续期后自动恢复
 }
 
 // 应用程序配置结构体
 type Config struct {
-	Server ServerConfig `ini:"服务器设置"`
-	JWT    JWTConfig    `ini:"认证设置"`
+	Server     ServerConfig         `ini:"服务器设置"`
+	JWT        JWTConfig            `ini:"认证设置"`
+	Session    SessionConfig        `ini:"会话设置"`
+	LoginGuard LoginGuardConfig     `ini:"登录保护"`
+	Password   PasswordPolicyConfig `ini:"密码策略"`
+	Captcha    CaptchaConfig        `ini:"验证码"`
+	CORS       CORSConfig           `ini:"跨域设置"`
+	Expiry     ExpiryConfig         `ini:"到期检查"`
 }
 
 // 全局配置实例
@@ -35,7 +96,10 @@ func LoadConfig(configPath string) (*Config, error) {
 		return nil, fmt.Errorf("加载配置文件失败: %v", err)
 	}
 
+	// 布尔值无法区分未配置和false，默认值在解析前设置
 	config := &Config{}
+	config.CORS.AllowCredentials = true
+	config.Expiry.DisableExpired = true
 	err = cfg.MapTo(config)
 	if err != nil {
 		return nil, fmt.Errorf("解析配置文件失败: %v", err)
@@ -78,6 +142,75 @@ func setDefaults(config *Config) {
 	if config.JWT.Secret == "" {
 		config.JWT.Secret = "default-secret-key-please-change-in-production"
 	}
+	if config.JWT.ExpireTime <= 0 {
+		config.JWT.ExpireTime = 28800 // 8小时
+	}
+	if config.JWT.RefreshExpireTime <= 0 {
+		config.JWT.RefreshExpireTime = 7 * 24 * 3600
+	}
+
+	// 会话默认配置（会话密钥留空时每次启动随机生成）
+	if config.Session.MaxAge <= 0 {
+		config.Session.MaxAge = 3 * 3600
+	}
+
+	// 登录保护默认配置
+	if config.LoginGuard.AccountMaxFailures <= 0 {
+		config.LoginGuard.AccountMaxFailures = 5
+	}
+	if config.LoginGuard.IPMaxFailures <= 0 {
+		config.LoginGuard.IPMaxFailures = 20
+	}
+	if config.LoginGuard.LockoutSeconds <= 0 {
+		config.LoginGuard.LockoutSeconds = 900
+	}
+	if config.LoginGuard.BackoffSeconds <= 0 {
+		config.LoginGuard.BackoffSeconds = 1
+	}
+	if config.LoginGuard.SuspiciousFailures <= 0 {
+		config.LoginGuard.SuspiciousFailures = 3
+	}
+	if config.LoginGuard.HistoryDays <= 0 {
+		config.LoginGuard.HistoryDays = 180
+	}
+
+	// 密码策略默认配置
+	if config.Password.MinLength <= 0 {
+		config.Password.MinLength = 8
+	}
+	if config.Password.MinClasses <= 0 {
+		config.Password.MinClasses = 2
+	}
+	if config.Password.HistoryCount <= 0 {
+		config.Password.HistoryCount = 5
+	}
+
+	// 跨域默认配置
+	if config.CORS.AllowMethods == "" {
+		config.CORS.AllowMethods = "GET, POST, OPTIONS"
+	}
+	if config.CORS.AllowHeaders == "" {
+		config.CORS.AllowHeaders = "Origin, Content-Type, Accept, Authorization, X-Requested-With, X-CSRF-Token"
+	}
+	if config.CORS.ExposeHeaders == "" {
+		config.CORS.ExposeHeaders = "Content-Length, Content-Type, X-Impersonation"
+	}
+	if config.CORS.MaxAge <= 0 {
+		config.CORS.MaxAge = 86400
+	}
+
+	// 图形验证码默认配置
+	if config.Captcha.AfterFailures <= 0 {
+		config.Captcha.AfterFailures = 2
+	}
+	if config.Captcha.ExpireSeconds <= 0 {
+		config.Captcha.ExpireSeconds = 120
+	}
+
+	// 到期检查默认配置
+	if config.Expiry.SweepInterval <= 0 {
+		config.Expiry.SweepInterval = 300
+	}
 }
 
 // validateConfig 验证配置的有效性
@@ -92,6 +225,11 @@ func validateConfig(config *Config) error {
 		return fmt.Errorf("JWT密钥长度至少需要16个字符")
 	}
 
+	// 验证会话密钥长度（留空表示使用随机密钥）
+	if config.Session.Secret != "" && len(config.Session.Secret) < 16 {
+		return fmt.Errorf("会话密钥长度至少需要16个字符")
+	}
+
 	// 验证服务器地址
 	if config.Server.Host == "" {
 		return fmt.Errorf("服务器地址不能为空")
@@ -128,7 +266,89 @@ func GetJWTSecret() string {
 
 // GetJWTExpireTime 获取JWT过期时间(秒)
 func GetJWTExpireTime() int {
-	return 28800 // 8小时
+	return AppConfig.JWT.ExpireTime
+}
+
+// GetJWTRefreshExpireTime 获取JWT刷新令牌过期时间(秒)
+func GetJWTRefreshExpireTime() int {
+	return AppConfig.JWT.RefreshExpireTime
+}
+
+// IsPlaceholderJWTSecret JWT密钥是否仍为示例/默认值
+// 示例密钥是公开的，使用它签发的令牌可以被任何人伪造
+func IsPlaceholderJWTSecret() bool {
+	for _, secret := range placeholderJWTSecrets {
+		if AppConfig.JWT.Secret == secret {
+			return true
+		}
+	}
+	return false
+}
+
+// GetSessionSecret 获取会话密钥，为空表示未配置
+func GetSessionSecret() string {
+	return AppConfig.Session.Secret
+}
+
+// GetSessionMaxAge 获取会话有效期(秒)
+func GetSessionMaxAge() int {
+	return AppConfig.Session.MaxAge
+}
+
+// GetSessionCleanInterval 获取过期会话清理间隔(秒)
+func GetSessionCleanInterval() int {
+	return 600 // 10分钟
+}
+
+// GetLoginGuardConfig 获取登录保护配置
+func GetLoginGuardConfig() LoginGuardConfig {
+	return AppConfig.LoginGuard
+}
+
+// GetPasswordPolicyConfig 获取密码策略配置
+func GetPasswordPolicyConfig() PasswordPolicyConfig {
+	return AppConfig.Password
+}
+
+// GetCORSConfig 获取跨域配置
+func GetCORSConfig() CORSConfig {
+	return AppConfig.CORS
+}
+
+// GetCORSAllowOrigins 获取允许跨域访问的来源列表
+func GetCORSAllowOrigins() []string {
+	origins := SplitList(AppConfig.CORS.AllowOrigins)
+	for i, origin := range origins {
+		origins[i] = strings.TrimRight(origin, "/")
+	}
+	return origins
+}
+
+// SplitList 拆分逗号分隔的配置项，忽略空白项
+func SplitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// GetExpiryConfig 获取代理到期检查配置
+func GetExpiryConfig() ExpiryConfig {
+	return AppConfig.Expiry
+}
+
+// GetCaptchaConfig 获取图形验证码配置
+func GetCaptchaConfig() CaptchaConfig {
+	return AppConfig.Captcha
+}
+
+// GetAgentStateCacheSeconds 获取代理状态校验缓存时间(秒)
+// 代理被禁用、删除或过期后，最迟在该时间后失去访问权限
+func GetAgentStateCacheSeconds() int {
+	return 30
 }
 
 // GetAppName 获取应用名称
@@ -11,6 +11,12 @@ import (
 	"gorm.io/gorm/logger"
 )
 
+// WebDatabaseName Web端附属数据库文件名
+const WebDatabaseName = "SProtectAgentWeb.db"
+
+// webDBKey Web端附属数据库在连接映射中的标识符
+const webDBKey = "#web"
+
 // DatabaseManager 数据库连接管理器
 // 负责管理所有数据库连接，包括主数据库、软件位数据库和审计日志数据库
 type DatabaseManager struct {
@@ -40,7 +46,7 @@ func NewDatabaseManager(dataPath string) *DatabaseManager {
 // 2. 默认软件位（"默认软件"）的业务数据：Agent、CardInfo、CardType等表
 // 返回: GORM数据库连接和可能的错误
 func (dm *DatabaseManager) GetDafaultDB() (*gorm.DB, error) {
-	return dm.getConnection("默认软件", "idc.db")
+	return dm.getConnection("默认软件", "idc.db", false)
 }
 
 // GetSoftwareDB 获取指定软件位的数据库连接
@@ -56,7 +62,16 @@ func (dm *DatabaseManager) GetSoftwareDB(software string) (*gorm.DB, error) {
 
 	// 其他软件位使用独立的数据库文件
 	dbName := fmt.Sprintf("idc_%s.db", software)
-	return dm.getConnection(software, dbName)
+	return dm.getConnection(software, dbName, false)
+}
+
+// GetWebDB 获取Web端附属数据库连接
+// 附属数据库文件：SProtectAgentWeb.db，与服务端数据库位于同一目录
+// 该数据库只保存Web代理端自身的数据（如持久化会话），不会被SProtect服务端读取
+// 文件不存在时自动创建
+// 返回: GORM数据库连接和可能的错误
+func (dm *DatabaseManager) GetWebDB() (*gorm.DB, error) {
+	return dm.getConnection(webDBKey, WebDatabaseName, true)
 }
 
 // GetAllSoftwareDB 获取所有软件位数据库连接列表
@@ -96,8 +111,9 @@ func (dm *DatabaseManager) GetAllSoftwareDB() (map[string]*gorm.DB, error) {
 // getConnection 获取或创建数据库连接
 // key: 连接标识符
 // filename: 数据库文件名
+// create: 文件不存在时是否自动创建（仅Web端附属数据库允许）
 // 返回: GORM数据库连接和可能的错误
-func (dm *DatabaseManager) getConnection(key, filename string) (*gorm.DB, error) {
+func (dm *DatabaseManager) getConnection(key, filename string, create bool) (*gorm.DB, error) {
 	// 先尝试读锁获取现有连接
 	dm.mutex.RLock()
 	if db, exists := dm.connections[key]; exists {
@@ -118,14 +134,16 @@ func (dm *DatabaseManager) getConnection(key, filename string) (*gorm.DB, error)
 	// 构建数据库文件路径
 	dbPath := filepath.Join(dm.dataPath, filename)
 
-	// 检查文件是否存在且非空
-	if fileInfo, err := os.Stat(dbPath); err != nil {
-		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("数据库文件不存在，路径: %s", dbPath)
+	// 检查文件是否存在且非空（服务端数据库必须已存在，不能由本程序创建）
+	if !create {
+		if fileInfo, err := os.Stat(dbPath); err != nil {
+			if os.IsNotExist(err) {
+				return nil, fmt.Errorf("数据库文件不存在，路径: %s", dbPath)
+			}
+			return nil, err
+		} else if fileInfo.Size() == 0 {
+			return nil, fmt.Errorf("数据库文件为空")
 		}
-		return nil, err
-	} else if fileInfo.Size() == 0 {
-		return nil, fmt.Errorf("数据库文件为空")
 	}
 
 	// 创建SQLite连接
@@ -145,6 +163,13 @@ func (dm *DatabaseManager) getConnection(key, filename string) (*gorm.DB, error)
 	sqlDB.SetMaxIdleConns(1)    // 最大空闲连接数
 	sqlDB.SetConnMaxLifetime(0) // 连接最大生存时间
 
+	// Web端附属数据库由本程序维护，首次连接时自动迁移表结构
+	if create {
+		if err := db.AutoMigrate(webModels...); err != nil {
+			return nil, fmt.Errorf("初始化数据库[%s] 表结构失败: %v", filename, err)
+		}
+	}
+
 	// 存储连接
 	dm.connections[key] = db
 
@@ -0,0 +1,19 @@
+package database
+
+import "SProtectAgentWeb/models"
+
+// webModels Web端附属数据库（SProtectAgentWeb.db）中的全部表
+// 新增Web端自有的表时在此登记，连接建立时会自动迁移
+var webModels = []interface{}{
+	&models.WebSession{},
+	&models.AgentTwoFactor{},
+	&models.TwoFactorRequirement{},
+	&models.ApiToken{},
+	&models.LoginHistory{},
+	&models.PasswordHistory{},
+	&models.PasswordChangeRequirement{},
+	&models.ImpersonationAudit{},
+	&models.AgentAudit{},
+	&models.BalanceLedger{},
+	&models.ExpiredAgent{},
+}
@@ -4,9 +4,18 @@ import (
 	"SProtectAgentWeb/middleware"
 	"SProtectAgentWeb/models"
 	"SProtectAgentWeb/services"
+	"SProtectAgentWeb/types"
 	"SProtectAgentWeb/util"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -14,80 +23,81 @@ import (
 // AgentHandler 代理处理器
 // 只处理HTTP请求/响应，业务逻辑委托给AgentService
 type AgentHandler struct {
-	agentService *services.AgentService
+	agentService          *services.AgentService
+	passwordPolicyService *services.PasswordPolicyService
+	agentInfoService      *services.AgentInfoService
+	subAgentService       *services.SubAgentService
+	agentTreeService      *services.AgentTreeService
+	sessionService        *services.SessionService
+	ledgerService         *services.LedgerService
+	expiryService         *services.ExpiryService
+	agentCreateService    *services.AgentCreateService
 }
 
 // NewAgentHandler 创建代理处理器实例
-func NewAgentHandler(agentService *services.AgentService) *AgentHandler {
+func NewAgentHandler(agentService *services.AgentService, passwordPolicyService *services.PasswordPolicyService, agentInfoService *services.AgentInfoService, subAgentService *services.SubAgentService, agentTreeService *services.AgentTreeService, sessionService *services.SessionService, ledgerService *services.LedgerService, expiryService *services.ExpiryService, agentCreateService *services.AgentCreateService) *AgentHandler {
 	return &AgentHandler{
-		agentService: agentService,
+		agentService:          agentService,
+		passwordPolicyService: passwordPolicyService,
+		agentInfoService:      agentInfoService,
+		subAgentService:       subAgentService,
+		agentTreeService:      agentTreeService,
+		sessionService:        sessionService,
+		ledgerService:         ledgerService,
+		expiryService:         expiryService,
+		agentCreateService:    agentCreateService,
 	}
 }
 
 // GetAgentInfo 获取代理详细信息
 // @Summary 获取代理信息
-// @Description 获取当前代理的详细信息
+// @Description 获取当前代理在软件位中的详细信息、权限、制卡权限和统计数据
 // @Tags 代理
 // @Accept json
 // @Produce json
-// @Param request body map[string]string true "请求参数"
+// @Param request body map[string]string true "请求参数（software）"
 // @Success 200 {object} types.GetAgentInfoResponse "获取成功"
 // @Failure 400 {object} map[string]interface{} "请求参数错误"
 // @Failure 401 {object} map[string]interface{} "认证失败"
 // @Router /agent/getUserInfo [post]
 func (h *AgentHandler) GetAgentInfo(c *gin.Context) {
-	// 从JWT中间件获取用户信息
-	username, exists := c.Get("username")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error":   "未找到用户信息",
-			"message": "请重新登录",
-		})
-		return
+	var req struct {
+		Software string `json:"software" binding:"required"` // 软件位名称
 	}
 
-	// 从请求体获取软件位参数
-	var req map[string]string
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":   "请求参数错误",
-			"message": err.Error(),
-		})
+		util.Response(c, util.CodeInvalidParam, "请求参数错误", nil)
 		return
 	}
 
-	software, exists := req["software"]
-	if !exists || software == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":   "请求参数错误",
-			"message": "缺少软件位参数",
-		})
+	// 获取当前用户会话
+	userSession := middleware.GetUserInfo(c)
+	if userSession == nil {
+		util.Response(c, util.CodeTokenInvalid, "用户未登录", nil)
 		return
 	}
 
-	// 调用代理服务获取信息
-	// response, err := h.agentService.GetAgentInfo(username.(string), software)
-	// if err != nil {
-	// 	c.JSON(http.StatusBadRequest, gin.H{
-	// 		"error":   "获取代理信息失败",
-	// 		"message": err.Error(),
-	// 	})
-	// 	return
-	// }
+	// 检查软件位访问权限
+	agent, exists := userSession.SoftwareAgentInfo[req.Software]
+	if !exists {
+		util.Response(c, util.CodePermissionDenied, "无权访问该软件位", nil)
+		return
+	}
+
+	response, err := h.agentInfoService.GetAgentInfo(req.Software, agent.User)
+	if err != nil {
+		util.Response(c, util.CodeInternalError, "获取代理信息失败: "+err.Error(), nil)
+		return
+	}
 
-	c.JSON(http.StatusOK, username)
+	util.Response(c, util.CodeSuccess, "获取代理信息成功", response)
 }
 
 // GetSubAgentList 获取子代理列表
+// 筛选、排序和分页在数据库中完成，没有查看所有下级代理权限时只返回直接下级
 func (h *AgentHandler) GetSubAgentList(c *gin.Context) {
 	// 解析请求参数
-	var req struct {
-		Software   string `json:"software" binding:"required"`
-		SearchType int    `json:"search_type"` // 搜索类型：0-精准搜索，1-模糊搜索
-		Keyword    string `json:"keyword"`     // 搜索关键词
-		Page       int    `json:"page"`
-		Limit      int    `json:"limit"`
-	}
+	var req types.GetAgentListRequest
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		util.Response(c, util.CodeInvalidParam, "请求参数错误", nil)
@@ -108,34 +118,17 @@ func (h *AgentHandler) GetSubAgentList(c *gin.Context) {
 		return
 	}
 
-	// 检查权限
-	authority, err := agent.GetAuthorityUint64()
-	if err != nil {
-		log.Printf("解析权限失败: %v, 原始值: %s", err, agent.Authority)
-		util.Response(c, util.CodeInternalError, "解析权限失败", nil)
-		return
-	}
-
-	// 调用服务层获取子代理列表
-	var subAgents []*models.Agent
-	var getErr error
-
 	// 根据权限决定获取直接子代理还是所有子代理
-	if (authority & util.PermManageSubAgentCard) == 0 {
-		// 没有查看所有下级代理的权限，只获取直接子代理
-		subAgents, getErr = h.agentService.GetDirectSubAgentsWithSearch(req.Software, agent.User, req.SearchType, req.Keyword)
-	} else {
-		// 有查看所有下级代理的权限，获取所有子代理
-		subAgents, getErr = h.agentService.GetSubAgentsWithSearch(req.Software, agent.User, req.SearchType, req.Keyword)
-	}
+	allLevels := agent.HasPermission(util.PermManageSubAgentCard)
 
-	if getErr != nil {
-		util.Response(c, util.CodeInternalError, "获取子代理列表失败: "+getErr.Error(), nil)
+	subAgents, pagination, err := h.subAgentService.ListSubAgents(req.Software, agent.User, &req, allLevels)
+	if err != nil {
+		util.Response(c, util.CodeInternalError, "获取子代理列表失败: "+err.Error(), nil)
 		return
 	}
 
 	// 转换为安全的响应格式
-	var safeAgents []map[string]interface{}
+	safeAgents := make([]map[string]interface{}, 0, len(subAgents))
 	for _, subAgent := range subAgents {
 		// 获取权限信息
 		permissions, _ := util.GetPermissionString(subAgent.Authority)
@@ -143,13 +136,13 @@ func (h *AgentHandler) GetSubAgentList(c *gin.Context) {
 		// 构建安全的代理信息
 		safeAgent := map[string]interface{}{
 			"username":       subAgent.User,
-			"password":       subAgent.Password, // 添加密码字段
 			"balance":        subAgent.AccountBalance,
 			"time_stock":     subAgent.AccountTime,
 			"parities":       subAgent.Parities,
 			"total_parities": subAgent.TatalParities,
 			"status":         subAgent.Stat, // 使用正确的状态字段
 			"expiration":     subAgent.Duration_,
+			"authority":      subAgent.Authority,
 			"permissions":    permissions,
 			"card_types":     subAgent.CardTypeAuthNameArray,
 			"parent":         subAgent.GetParentAgent(),
@@ -159,28 +152,62 @@ func (h *AgentHandler) GetSubAgentList(c *gin.Context) {
 		safeAgents = append(safeAgents, safeAgent)
 	}
 
-	// 处理分页
-	start := (req.Page - 1) * req.Limit
-	end := start + req.Limit
+	util.Response(c, util.CodeSuccess, "获取子代理列表成功", gin.H{
+		"data":       safeAgents,
+		"total":      pagination.Total,
+		"pagination": pagination,
+	})
+}
 
-	if start >= len(safeAgents) {
-		start = 0
-		end = 0
+// GetAgentTree 获取下级代理树
+// 每个节点包含余额、时长、状态、卡密数及整个分支的汇总，超过展开层数的分支以该节点为root再次请求
+func (h *AgentHandler) GetAgentTree(c *gin.Context) {
+	// 解析请求参数
+	var req struct {
+		Software string `json:"software" binding:"required"` // 软件位名称
+		Root     string `json:"root"`                        // 树根代理，为空表示当前代理
+		Depth    int    `json:"depth"`                       // 展开层数，默认2层
 	}
 
-	if end > len(safeAgents) {
-		end = len(safeAgents)
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.Response(c, util.CodeInvalidParam, "请求参数错误", nil)
+		return
 	}
 
-	var pagedAgents []map[string]interface{}
-	if start < end {
-		pagedAgents = safeAgents[start:end]
+	if req.Depth <= 0 {
+		req.Depth = 2
+	}
+	if req.Depth > 10 {
+		req.Depth = 10
 	}
 
-	util.Response(c, util.CodeSuccess, "获取子代理列表成功", gin.H{
-		"data":  pagedAgents,
-		"total": len(safeAgents),
-	})
+	// 获取当前用户会话
+	userSession := middleware.GetUserInfo(c)
+	if userSession == nil {
+		util.Response(c, util.CodeTokenInvalid, "用户未登录", nil)
+		return
+	}
+
+	// 检查软件位访问权限
+	agent, exists := userSession.SoftwareAgentInfo[req.Software]
+	if !exists {
+		util.Response(c, util.CodePermissionDenied, "无权访问该软件位", nil)
+		return
+	}
+
+	// 代理树包含整个下级链的数据，需要查看所有下级代理的权限
+	if !agent.HasPermission(util.PermManageSubAgentCard) {
+		util.Response(c, util.CodePermissionDenied, "无权查看所有下级代理", nil)
+		return
+	}
+
+	tree, err := h.agentTreeService.GetAgentTree(req.Software, agent.User, req.Root, req.Depth)
+	if err != nil {
+		util.Response(c, util.CodeInternalError, "获取代理树失败: "+err.Error(), nil)
+		return
+	}
+
+	util.Response(c, util.CodeSuccess, "获取代理树成功", tree)
 }
 
 // DisableAgent 禁用代理（支持批量）
@@ -230,6 +257,7 @@ func (h *AgentHandler) DisableAgent(c *gin.Context) {
 		util.Response(c, util.CodeInternalError, err.Error(), nil)
 		return
 	}
+	middleware.InvalidateAgentState(req.Software, req.Username...)
 
 	// 统计成功和失败数量
 	successCount := 0
@@ -296,6 +324,7 @@ func (h *AgentHandler) EnableAgent(c *gin.Context) {
 		util.Response(c, util.CodeInternalError, err.Error(), nil)
 		return
 	}
+	middleware.InvalidateAgentState(req.Software, req.Username...)
 
 	// 统计成功和失败数量
 	successCount := 0
@@ -379,6 +408,8 @@ func (h *AgentHandler) CreateSubAgent(c *gin.Context) {
 		ExpiryTime    int64   `json:"expiry_time" binding:"required"` // 到期时间（时间戳）
 		Parities      float64 `json:"parities" binding:"min=100"`     // 返利利率
 		Remarks       string  `json:"remarks"`                        // 备注
+
+		MustChangePassword bool `json:"must_change_password"` // 是否要求子代理首次登录后修改密码
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -414,36 +445,535 @@ func (h *AgentHandler) CreateSubAgent(c *gin.Context) {
 		return
 	}
 
-	// 构造新的子代理对象
-	newAgent := &models.Agent{
-		User:             req.Username,
-		Password:         req.Password,
-		AccountBalance:   req.Balance,
-		AccountTime:      req.StockDuration,
-		Duration:         "0", // 默认值
-		Authority:        "0", // 默认权限
-		CardTypeAuthName: "",  // 默认无卡密类型权限
-		CardsEnable:      true,
-		Remarks:          req.Remarks,
-		FNode:            "[]", // 默认空节点，会在CreateSubAgent中重新生成
-		Stat:             0,    // 启用状态
-		Deltm:            0,    // 未删除
-		Duration_:        req.ExpiryTime,
-		Parities:         req.Parities, // 使用请求中的分成比例
-		TatalParities:    100.0,        // 默认总分成比例，会在CreateSubAgent中重新计算
-	}
-
-	// 调用服务层创建子代理
-	err = h.agentService.CreateSubAgent(req.Software, agent.User, newAgent)
-	if err != nil {
+	// 按与批量创建相同的规则校验并创建子代理
+	spec := &types.SubAgentSpec{
+		Username:   req.Username,
+		Password:   req.Password,
+		Balance:    req.Balance,
+		TimeStock:  req.StockDuration,
+		Expiration: req.ExpiryTime,
+		Parities:   req.Parities,
+		Remarks:    req.Remarks,
+	}
+	if err := h.agentCreateService.CreateSubAgent(req.Software, agent.User, spec); err != nil {
 		util.Response(c, util.CodeInternalError, err.Error(), nil)
 		return
 	}
 
+	if err := h.passwordPolicyService.Remember(spec.Username, spec.Password); err != nil {
+		log.Printf("记录历史密码失败 [%s]: %v", spec.Username, err)
+	}
+	if req.MustChangePassword {
+		if err := h.passwordPolicyService.SetChangeRequirement(req.Software, agent.User, spec.Username, true); err != nil {
+			util.Response(c, util.CodeInternalError, "子代理已创建，但设置修改密码要求失败: "+err.Error(), nil)
+			return
+		}
+	}
+
 	util.Response(c, util.CodeSuccess, "子代理创建成功", nil)
 }
 
+// bulkAgentMaxFileSize 批量创建子代理上传文件的最大字节数
+const bulkAgentMaxFileSize = 2 << 20
+
+// ImportSubAgents 通过上传CSV或XLSX文件批量创建子代理
+// 使用multipart表单提交，dry_run为true时只校验不创建
+func (h *AgentHandler) ImportSubAgents(c *gin.Context) {
+	// 解析请求参数
+	var req struct {
+		Software           string `form:"software" binding:"required"` // 软件位名称
+		DryRun             bool   `form:"dry_run"`                     // 是否只校验不创建
+		MustChangePassword bool   `form:"must_change_password"`        // 是否要求子代理首次登录后修改密码
+	}
+
+	if err := c.ShouldBind(&req); err != nil {
+		util.Response(c, util.CodeInvalidParam, "请求参数错误", nil)
+		return
+	}
+
+	// 获取当前用户会话
+	userSession := middleware.GetUserInfo(c)
+	if userSession == nil {
+		util.Response(c, util.CodeTokenInvalid, "用户未登录", nil)
+		return
+	}
+
+	// 检查软件位访问权限
+	agent, exists := userSession.SoftwareAgentInfo[req.Software]
+	if !exists {
+		util.Response(c, util.CodePermissionDenied, "无权访问该软件位", nil)
+		return
+	}
+
+	// 检查管理代理权限
+	if !agent.HasPermission(util.PermManageAgent) {
+		util.Response(c, util.CodePermissionDenied, "无权管理代理", nil)
+		return
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		util.Response(c, util.CodeInvalidParam, "请上传CSV或XLSX文件", nil)
+		return
+	}
+	if fileHeader.Size > bulkAgentMaxFileSize {
+		util.Response(c, util.CodeInvalidParam, "文件不能超过2MB", nil)
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		util.Response(c, util.CodeInvalidParam, "读取上传文件失败", nil)
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, bulkAgentMaxFileSize))
+	if err != nil {
+		util.Response(c, util.CodeInvalidParam, "读取上传文件失败", nil)
+		return
+	}
+
+	records, err := util.ReadTableFile(fileHeader.Filename, data)
+	if err != nil {
+		util.Response(c, util.CodeInvalidParam, err.Error(), nil)
+		return
+	}
+
+	result, err := h.agentCreateService.ImportSubAgents(req.Software, agent.User, records, req.DryRun)
+	if err != nil {
+		util.Response(c, util.CodeInvalidParam, err.Error(), nil)
+		return
+	}
+
+	if req.DryRun || result.Created == 0 {
+		util.Response(c, util.CodeSuccess, fmt.Sprintf("校验完成，有效%d行，无效%d行", result.Valid, result.Total-result.Valid), result)
+		return
+	}
+
+	for _, row := range result.Rows {
+		if len(row.Errors) > 0 {
+			continue
+		}
+
+		if err := h.passwordPolicyService.Remember(row.Username, row.Password); err != nil {
+			log.Printf("记录历史密码失败 [%s]: %v", row.Username, err)
+		}
+		if req.MustChangePassword {
+			if err := h.passwordPolicyService.SetChangeRequirement(req.Software, agent.User, row.Username, true); err != nil {
+				log.Printf("设置修改密码要求失败 [%s]: %v", row.Username, err)
+			}
+		}
+	}
+
+	util.Response(c, util.CodeSuccess, fmt.Sprintf("已创建%d个子代理，无效%d行", result.Created, result.Total-result.Valid), result)
+}
+
+// RequirePasswordChange 设置子代理下次登录时是否必须修改密码
+func (h *AgentHandler) RequirePasswordChange(c *gin.Context) {
+	var req struct {
+		Software    string `json:"software" binding:"required"`     // 软件位名称
+		TargetAgent string `json:"target_agent" binding:"required"` // 子代理账号
+		Required    bool   `json:"required"`                        // 是否要求修改密码
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.Response(c, util.CodeInvalidParam, "请求参数错误", nil)
+		return
+	}
+
+	// 获取当前用户会话
+	userSession := middleware.GetUserInfo(c)
+	if userSession == nil {
+		util.Response(c, util.CodeTokenInvalid, "用户未登录", nil)
+		return
+	}
+
+	// 检查软件位访问权限
+	agent, exists := userSession.SoftwareAgentInfo[req.Software]
+	if !exists {
+		util.Response(c, util.CodePermissionDenied, "无权访问该软件位", nil)
+		return
+	}
+
+	// 检查管理代理权限
+	if !agent.HasPermission(util.PermManageAgent) {
+		util.Response(c, util.CodePermissionDenied, "无权管理代理", nil)
+		return
+	}
+
+	if err := h.passwordPolicyService.SetChangeRequirement(req.Software, agent.User, req.TargetAgent, req.Required); err != nil {
+		util.Response(c, util.CodeInternalError, "设置失败: "+err.Error(), nil)
+		return
+	}
+
+	util.Response(c, util.CodeSuccess, "设置成功", nil)
+}
+
+// ResetSubAgentPassword 重置下级代理密码
+// 未指定新密码时由系统生成，新密码只在本次响应中返回一次；重置后下级代理的登录会话全部失效
+func (h *AgentHandler) ResetSubAgentPassword(c *gin.Context) {
+	// 解析请求参数
+	var req struct {
+		Software    string `json:"software" binding:"required"`     // 软件位名称
+		TargetAgent string `json:"target_agent" binding:"required"` // 目标代理名称
+		Password    string `json:"password"`                        // 新密码，为空时由系统生成
+
+		MustChangePassword bool `json:"must_change_password"` // 是否要求下级代理登录后修改密码
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.Response(c, util.CodeInvalidParam, "请求参数错误", nil)
+		return
+	}
+
+	// 获取当前用户会话
+	userSession := middleware.GetUserInfo(c)
+	if userSession == nil {
+		util.Response(c, util.CodeTokenInvalid, "用户未登录", nil)
+		return
+	}
+
+	// 检查软件位访问权限
+	agent, exists := userSession.SoftwareAgentInfo[req.Software]
+	if !exists {
+		util.Response(c, util.CodePermissionDenied, "无权访问该软件位", nil)
+		return
+	}
+
+	// 检查管理代理权限
+	if !agent.HasPermission(util.PermManageAgent) {
+		util.Response(c, util.CodePermissionDenied, "无权管理代理", nil)
+		return
+	}
+
+	generated := req.Password == ""
+	password := req.Password
+	if generated {
+		var err error
+		password, err = h.passwordPolicyService.Generate(req.TargetAgent)
+		if err != nil {
+			util.Response(c, util.CodeInternalError, err.Error(), nil)
+			return
+		}
+	} else if err := h.passwordPolicyService.Validate(req.TargetAgent, password); err != nil {
+		util.Response(c, util.CodeInvalidParam, err.Error(), nil)
+		return
+	}
+
+	if err := h.subAgentService.ResetPassword(req.Software, agent.User, req.TargetAgent, password, generated, c.ClientIP()); err != nil {
+		util.Response(c, util.CodeInternalError, err.Error(), nil)
+		return
+	}
+
+	if err := h.passwordPolicyService.Remember(req.TargetAgent, password); err != nil {
+		log.Printf("记录历史密码失败 [%s]: %v", req.TargetAgent, err)
+	}
+	if req.MustChangePassword {
+		if err := h.passwordPolicyService.SetChangeRequirement(req.Software, agent.User, req.TargetAgent, true); err != nil {
+			log.Printf("设置修改密码要求失败 [%s]: %v", req.TargetAgent, err)
+		}
+	}
+
+	// 旧密码登录的会话全部下线
+	revoked, err := h.sessionService.RevokeSubAgentSessions(req.Software, agent.User, req.TargetAgent)
+	if err != nil {
+		log.Printf("下级代理会话下线失败 [%s]: %v", req.TargetAgent, err)
+	}
+
+	// 新密码只返回这一次，不允许缓存
+	c.Header("Cache-Control", "no-store")
+	util.Response(c, util.CodeSuccess, "密码重置成功，请妥善保存新密码", gin.H{
+		"password":             password,
+		"generated":            generated,
+		"must_change_password": req.MustChangePassword,
+		"revoked_count":        revoked,
+	})
+}
+
+// SetSubAgentExpiry 批量延长或设置下级代理的到期时间
+// days大于0时在原到期时间基础上延长，否则设置为expiration（0表示永不过期）
+func (h *AgentHandler) SetSubAgentExpiry(c *gin.Context) {
+	// 解析请求参数
+	var req struct {
+		Software    string   `json:"software" binding:"required"`       // 软件位名称
+		Username    []string `json:"username" binding:"required,min=1"` // 下级代理账号列表
+		Days        int      `json:"days" binding:"min=0,max=3650"`     // 延长天数
+		Expiration  int64    `json:"expiration" binding:"min=0"`        // 指定的到期时间戳
+		NeverExpire bool     `json:"never_expire"`                      // 设置为永不过期
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.Response(c, util.CodeInvalidParam, "请求参数错误", nil)
+		return
+	}
+
+	// 必须明确指定延长天数、到期时间或永不过期，且只能选择一项
+	if !req.NeverExpire && req.Days == 0 && req.Expiration == 0 {
+		util.Response(c, util.CodeInvalidParam, "请输入延长天数或到期时间", nil)
+		return
+	}
+	if (req.NeverExpire && (req.Days > 0 || req.Expiration > 0)) || (req.Days > 0 && req.Expiration > 0) {
+		util.Response(c, util.CodeInvalidParam, "延长天数、到期时间和永不过期只能选择一项", nil)
+		return
+	}
+
+	// 获取当前用户会话
+	userSession := middleware.GetUserInfo(c)
+	if userSession == nil {
+		util.Response(c, util.CodeTokenInvalid, "用户未登录", nil)
+		return
+	}
+
+	// 检查软件位访问权限
+	agent, exists := userSession.SoftwareAgentInfo[req.Software]
+	if !exists {
+		util.Response(c, util.CodePermissionDenied, "无权访问该软件位", nil)
+		return
+	}
+
+	// 检查管理代理权限
+	if !agent.HasPermission(util.PermManageAgent) {
+		util.Response(c, util.CodePermissionDenied, "无权管理代理", nil)
+		return
+	}
+
+	results, err := h.expiryService.SetExpiry(req.Software, agent.User, req.Username, req.Days, req.Expiration, req.NeverExpire)
+	if err != nil {
+		util.Response(c, util.CodeInternalError, "设置到期时间失败: "+err.Error(), nil)
+		return
+	}
+
+	succeeded := 0
+	for _, result := range results {
+		if result.Success {
+			succeeded++
+			middleware.InvalidateAgentState(req.Software, result.Username)
+		}
+	}
+
+	util.Response(c, util.CodeSuccess, fmt.Sprintf("设置完成，成功%d个，失败%d个", succeeded, len(results)-succeeded), gin.H{
+		"results": results,
+	})
+}
+
+// GetExpiringAgents 获取即将到期的下级代理
+// 按到期时间从早到晚排列，没有查看所有下级代理权限时只返回直接下级
+func (h *AgentHandler) GetExpiringAgents(c *gin.Context) {
+	// 解析请求参数
+	var req struct {
+		Software string `json:"software" binding:"required"` // 软件位名称
+		Days     int    `json:"days"`                        // 多少天内到期，默认7天
+		Page     int    `json:"page"`                        // 页码
+		Limit    int    `json:"limit"`                       // 每页数量
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.Response(c, util.CodeInvalidParam, "请求参数错误", nil)
+		return
+	}
+
+	if req.Days <= 0 {
+		req.Days = 7
+	}
+	if req.Days > 365 {
+		req.Days = 365
+	}
+
+	// 获取当前用户会话
+	userSession := middleware.GetUserInfo(c)
+	if userSession == nil {
+		util.Response(c, util.CodeTokenInvalid, "用户未登录", nil)
+		return
+	}
+
+	// 检查软件位访问权限
+	agent, exists := userSession.SoftwareAgentInfo[req.Software]
+	if !exists {
+		util.Response(c, util.CodePermissionDenied, "无权访问该软件位", nil)
+		return
+	}
+
+	listReq := &types.GetAgentListRequest{
+		Software:     req.Software,
+		Page:         req.Page,
+		PageSize:     req.Limit,
+		SortField:    "expiration",
+		SortOrder:    "asc",
+		ExpiringDays: req.Days,
+	}
+	subAgents, pagination, err := h.subAgentService.ListSubAgents(req.Software, agent.User, listReq, agent.HasPermission(util.PermManageSubAgentCard))
+	if err != nil {
+		util.Response(c, util.CodeInternalError, "获取即将到期代理失败: "+err.Error(), nil)
+		return
+	}
+
+	now := time.Now().Unix()
+	items := make([]map[string]interface{}, 0, len(subAgents))
+	for _, subAgent := range subAgents {
+		items = append(items, map[string]interface{}{
+			"username":   subAgent.User,
+			"parent":     subAgent.GetParentAgent(),
+			"status":     subAgent.Stat,
+			"expiration": subAgent.Duration_,
+			"days_left":  (subAgent.Duration_ - now + 86399) / 86400,
+			"remark":     subAgent.Remarks,
+		})
+	}
+
+	util.Response(c, util.CodeSuccess, "获取即将到期代理成功", gin.H{
+		"data":       items,
+		"total":      pagination.Total,
+		"pagination": pagination,
+	})
+}
+
+// UpdatePermission 设置下级代理权限
+// 只能变更自身拥有的权限位，且目标必须在自己的下级链中
+func (h *AgentHandler) UpdatePermission(c *gin.Context) {
+	// 解析请求参数
+	var req struct {
+		Software    string `json:"software" binding:"required"`     // 软件位名称
+		TargetAgent string `json:"target_agent" binding:"required"` // 目标代理名称
+		Authority   uint64 `json:"authority"`                       // 期望的权限位组合
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.Response(c, util.CodeInvalidParam, "请求参数错误", nil)
+		return
+	}
+
+	// 获取当前用户会话
+	userSession := middleware.GetUserInfo(c)
+	if userSession == nil {
+		util.Response(c, util.CodeTokenInvalid, "用户未登录", nil)
+		return
+	}
+
+	// 检查软件位访问权限
+	agent, exists := userSession.SoftwareAgentInfo[req.Software]
+	if !exists {
+		util.Response(c, util.CodePermissionDenied, "无权访问该软件位", nil)
+		return
+	}
+
+	// 检查管理代理权限
+	if !agent.HasPermission(util.PermManageAgent) {
+		util.Response(c, util.CodePermissionDenied, "无权管理代理", nil)
+		return
+	}
+
+	authority, permissions, err := h.subAgentService.UpdatePermission(req.Software, agent.User, req.TargetAgent, req.Authority)
+	if err != nil {
+		util.Response(c, util.CodePermissionDenied, "设置权限失败: "+err.Error(), nil)
+		return
+	}
+	// 下级代理的会话在下一个请求刷新权限
+	middleware.InvalidateAgentState(req.Software, req.TargetAgent)
+
+	util.Response(c, util.CodeSuccess, "权限设置成功", gin.H{
+		"authority":   authority,
+		"permissions": permissions,
+	})
+}
+
+// MoveSubAgent 将下级代理连同其下级代理移动到新的上级代理下
+func (h *AgentHandler) MoveSubAgent(c *gin.Context) {
+	// 解析请求参数
+	var req struct {
+		Software    string `json:"software" binding:"required"`     // 软件位名称
+		TargetAgent string `json:"target_agent" binding:"required"` // 被移动的代理名称
+		NewParent   string `json:"new_parent" binding:"required"`   // 新的上级代理名称
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.Response(c, util.CodeInvalidParam, "请求参数错误", nil)
+		return
+	}
+
+	// 获取当前用户会话
+	userSession := middleware.GetUserInfo(c)
+	if userSession == nil {
+		util.Response(c, util.CodeTokenInvalid, "用户未登录", nil)
+		return
+	}
+
+	// 检查软件位访问权限
+	agent, exists := userSession.SoftwareAgentInfo[req.Software]
+	if !exists {
+		util.Response(c, util.CodePermissionDenied, "无权访问该软件位", nil)
+		return
+	}
+
+	// 检查管理代理权限
+	if !agent.HasPermission(util.PermManageAgent) {
+		util.Response(c, util.CodePermissionDenied, "无权管理代理", nil)
+		return
+	}
+
+	moved, err := h.subAgentService.MoveSubAgent(req.Software, agent.User, req.TargetAgent, req.NewParent, c.ClientIP())
+	if err != nil {
+		util.Response(c, util.CodeInternalError, "移动代理失败: "+err.Error(), nil)
+		return
+	}
+
+	util.Response(c, util.CodeSuccess, "移动代理成功", gin.H{
+		"moved": moved,
+	})
+}
+
+// UpdateParities 修改下级代理的返利利率
+// 同时重新计算其全部下级代理的总利率，preview为true时只返回影响不保存
+func (h *AgentHandler) UpdateParities(c *gin.Context) {
+	// 解析请求参数
+	var req struct {
+		Software    string  `json:"software" binding:"required"`     // 软件位名称
+		TargetAgent string  `json:"target_agent" binding:"required"` // 下级代理账号
+		Parities    float64 `json:"parities" binding:"min=100"`      // 新的返利利率
+		Preview     bool    `json:"preview"`                         // 是否只预览
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.Response(c, util.CodeInvalidParam, "请求参数错误，返利利率不能小于100", nil)
+		return
+	}
+
+	// 获取当前用户会话
+	userSession := middleware.GetUserInfo(c)
+	if userSession == nil {
+		util.Response(c, util.CodeTokenInvalid, "用户未登录", nil)
+		return
+	}
+
+	// 检查软件位访问权限
+	agent, exists := userSession.SoftwareAgentInfo[req.Software]
+	if !exists {
+		util.Response(c, util.CodePermissionDenied, "无权访问该软件位", nil)
+		return
+	}
+
+	// 检查管理代理权限
+	if !agent.HasPermission(util.PermManageAgent) {
+		util.Response(c, util.CodePermissionDenied, "无权管理代理", nil)
+		return
+	}
+
+	result, err := h.subAgentService.UpdateParities(req.Software, agent.User, req.TargetAgent, req.Parities, req.Preview, c.ClientIP())
+	if err != nil {
+		util.Response(c, util.CodeInternalError, "修改返利利率失败: "+err.Error(), nil)
+		return
+	}
+
+	if req.Preview {
+		util.Response(c, util.CodeSuccess, "预览成功", result)
+		return
+	}
+
+	util.Response(c, util.CodeSuccess, "修改返利利率成功", result)
+}
+
 // DeleteSubAgent 删除子代理
+// 子代理剩余的余额和库存时长退回当前代理
 func (h *AgentHandler) DeleteSubAgent(c *gin.Context) {
 	// 解析请求参数
 	var req struct {
@@ -484,12 +1014,13 @@ func (h *AgentHandler) DeleteSubAgent(c *gin.Context) {
 		return
 	}
 
-	// 调用服务层删除子代理
-	err = h.agentService.DeleteSubAgent(req.Software, agent.User, req.SubAgentName)
+	// 调用服务层删除子代理，剩余余额和时长退回当前代理
+	_, err = h.subAgentService.DeleteSubAgent(req.Software, agent.User, req.SubAgentName, c.ClientIP())
 	if err != nil {
 		util.Response(c, util.CodeInternalError, "删除子代理失败: "+err.Error(), nil)
 		return
 	}
+	middleware.InvalidateAgentState(req.Software, req.SubAgentName)
 
 	util.Response(c, util.CodeSuccess, "子代理删除成功", nil)
 }
@@ -543,8 +1074,8 @@ func (h *AgentHandler) AddMoney(c *gin.Context) {
 		return
 	}
 
-	// 调用服务层进行充值
-	err = h.agentService.AddMoney(req.Software, agent.User, req.TargetAgent, req.Amount, req.TimeHours)
+	// 调用服务层进行充值，余额变动提交后记录流水
+	_, err = h.subAgentService.Recharge(req.Software, agent.User, req.TargetAgent, req.Amount, req.TimeHours*3600)
 	if err != nil {
 		util.Response(c, util.CodeInternalError, "充值失败: "+err.Error(), nil)
 		return
@@ -553,6 +1084,188 @@ func (h *AgentHandler) AddMoney(c *gin.Context) {
 	util.Response(c, util.CodeSuccess, "充值成功", nil)
 }
 
+// WithdrawMoney 从子代理回收余额和库存时长
+// 用于纠正误充值或回收离开代理的资金，必须填写回收原因
+func (h *AgentHandler) WithdrawMoney(c *gin.Context) {
+	// 解析请求参数
+	var req struct {
+		Software    string  `json:"software" binding:"required"`       // 软件位名称
+		TargetAgent string  `json:"target_agent" binding:"required"`   // 目标代理名称
+		Amount      float64 `json:"amount" binding:"min=0"`            // 回收金额
+		TimeHours   int     `json:"time_hours" binding:"min=0"`        // 回收时长（小时）
+		Reason      string  `json:"reason" binding:"required,max=200"` // 回收原因
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.Response(c, util.CodeInvalidParam, "请求参数错误", nil)
+		return
+	}
+
+	// 验证至少有一项回收内容
+	if req.Amount <= 0 && req.TimeHours <= 0 {
+		util.Response(c, util.CodeInvalidParam, "请输入回收金额或时长", nil)
+		return
+	}
+	if strings.TrimSpace(req.Reason) == "" {
+		util.Response(c, util.CodeInvalidParam, "请填写回收原因", nil)
+		return
+	}
+
+	// 获取当前用户会话
+	userSession := middleware.GetUserInfo(c)
+	if userSession == nil {
+		util.Response(c, util.CodeTokenInvalid, "用户未登录", nil)
+		return
+	}
+
+	// 检查软件位访问权限
+	agent, exists := userSession.SoftwareAgentInfo[req.Software]
+	if !exists {
+		util.Response(c, util.CodePermissionDenied, "无权访问该软件位", nil)
+		return
+	}
+
+	// 检查管理代理权限
+	if !agent.HasPermission(util.PermManageAgent) {
+		util.Response(c, util.CodePermissionDenied, "无权管理代理", nil)
+		return
+	}
+
+	result, err := h.subAgentService.Withdraw(req.Software, agent.User, req.TargetAgent, req.Amount, req.TimeHours*3600, strings.TrimSpace(req.Reason), c.ClientIP())
+	if err != nil {
+		util.Response(c, util.CodeInternalError, "回收失败: "+err.Error(), nil)
+		return
+	}
+
+	util.Response(c, util.CodeSuccess, "回收成功", result)
+}
+
+// GetLedger 查询余额流水
+// 可以查询自己和下级链中代理的流水，export为true时导出CSV文件
+func (h *AgentHandler) GetLedger(c *gin.Context) {
+	// 解析请求参数
+	var req struct {
+		Software  string `json:"software" binding:"required"` // 软件位名称
+		Username  string `json:"username"`                    // 代理账号，为空表示当前代理
+		Type      string `json:"type"`                        // 流水类型，为空表示全部
+		StartDate string `json:"start_date"`                  // 开始日期（含），格式2006-01-02
+		EndDate   string `json:"end_date"`                    // 结束日期（含），格式2006-01-02
+		Page      int    `json:"page"`                        // 页码
+		Limit     int    `json:"limit"`                       // 每页数量
+		Export    bool   `json:"export"`                      // 是否导出CSV
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.Response(c, util.CodeInvalidParam, "请求参数错误", nil)
+		return
+	}
+
+	query := &services.LedgerQuery{
+		Software: req.Software,
+		Username: req.Username,
+		Type:     req.Type,
+		Page:     req.Page,
+		Limit:    req.Limit,
+		Export:   req.Export,
+	}
+	if req.StartDate != "" {
+		start, err := time.ParseInLocation("2006-01-02", req.StartDate, time.Local)
+		if err != nil {
+			util.Response(c, util.CodeInvalidParam, "开始日期格式错误", nil)
+			return
+		}
+		query.StartTime = start.Unix()
+	}
+	if req.EndDate != "" {
+		end, err := time.ParseInLocation("2006-01-02", req.EndDate, time.Local)
+		if err != nil {
+			util.Response(c, util.CodeInvalidParam, "结束日期格式错误", nil)
+			return
+		}
+		query.EndTime = end.AddDate(0, 0, 1).Unix()
+	}
+
+	// 获取当前用户会话
+	userSession := middleware.GetUserInfo(c)
+	if userSession == nil {
+		util.Response(c, util.CodeTokenInvalid, "用户未登录", nil)
+		return
+	}
+
+	// 检查软件位访问权限
+	agent, exists := userSession.SoftwareAgentInfo[req.Software]
+	if !exists {
+		util.Response(c, util.CodePermissionDenied, "无权访问该软件位", nil)
+		return
+	}
+
+	if query.Username == "" {
+		query.Username = agent.User
+	}
+	if err := h.ledgerService.CheckAccess(req.Software, agent.User, query.Username); err != nil {
+		util.Response(c, util.CodePermissionDenied, err.Error(), nil)
+		return
+	}
+
+	records, total, err := h.ledgerService.ListLedger(query)
+	if err != nil {
+		util.Response(c, util.CodeInternalError, "获取余额流水失败: "+err.Error(), nil)
+		return
+	}
+
+	if req.Export {
+		writeLedgerCSV(c, query.Username, records)
+		return
+	}
+
+	util.Response(c, util.CodeSuccess, "获取余额流水成功", gin.H{
+		"data":  records,
+		"total": total,
+	})
+}
+
+// ledgerTypeNames 流水类型的显示名称
+var ledgerTypeNames = map[string]string{
+	models.LedgerTypeRecharge: "充值",
+	models.LedgerTypeWithdraw: "回收",
+	models.LedgerTypeCardCost: "生成卡密",
+	models.LedgerTypeRefund:   "退回",
+}
+
+// writeLedgerCSV 以CSV文件返回余额流水，带UTF-8 BOM以便Excel正确识别中文
+func writeLedgerCSV(c *gin.Context, username string, records []models.BalanceLedger) {
+	var buf bytes.Buffer
+	buf.WriteString("\xEF\xBB\xBF")
+
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"时间", "代理", "类型", "变动前余额", "余额变动", "变动后余额", "变动前时长(秒)", "时长变动(秒)", "变动后时长(秒)", "操作人", "操作编号", "说明"})
+	for _, record := range records {
+		typeName, ok := ledgerTypeNames[record.Type]
+		if !ok {
+			typeName = record.Type
+		}
+		w.Write([]string{
+			time.Unix(record.CreatedAt, 0).Format("2006-01-02 15:04:05"),
+			record.Username,
+			typeName,
+			strconv.FormatFloat(record.BalanceBefore, 'f', 2, 64),
+			strconv.FormatFloat(record.BalanceChange, 'f', 2, 64),
+			strconv.FormatFloat(record.BalanceAfter, 'f', 2, 64),
+			strconv.Itoa(record.TimeBefore),
+			strconv.Itoa(record.TimeChange),
+			strconv.Itoa(record.TimeAfter),
+			record.Actor,
+			record.Reference,
+			record.Remark,
+		})
+	}
+	w.Flush()
+
+	filename := fmt.Sprintf("ledger_%s_%s.csv", username, time.Now().Format("20060102150405"))
+	c.Header("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(filename))
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+}
+
 // GetAgentCardType 获取代理卡类型权限
 func (h *AgentHandler) GetAgentCardType(c *gin.Context) {
 	var req struct {
@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"SProtectAgentWeb/middleware"
+	"SProtectAgentWeb/services"
+	"SProtectAgentWeb/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ApiTokenHandler API令牌处理器
+type ApiTokenHandler struct {
+	apiTokenService *services.ApiTokenService
+}
+
+// NewApiTokenHandler 创建API令牌处理器实例
+func NewApiTokenHandler(apiTokenService *services.ApiTokenService) *ApiTokenHandler {
+	return &ApiTokenHandler{
+		apiTokenService: apiTokenService,
+	}
+}
+
+// CreateApiToken 创建API令牌
+// 令牌明文只在本次响应中返回，请调用方妥善保存
+func (h *ApiTokenHandler) CreateApiToken(c *gin.Context) {
+	var req struct {
+		Name        string   `json:"name" binding:"required"`
+		Permissions []uint64 `json:"permissions" binding:"required"` // 授权的权限位列表，如 [256, 1]
+		Softwares   []string `json:"softwares" binding:"required"`   // 授权的软件位列表
+		ExpireDays  int      `json:"expire_days"`                    // 有效期（天），默认30天
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.Response(c, util.CodeInvalidParam, "请求参数错误", nil)
+		return
+	}
+
+	userSession := middleware.GetUserInfo(c)
+	if userSession == nil {
+		util.Response(c, util.CodeTokenInvalid, "用户未登录", nil)
+		return
+	}
+
+	var permissions uint64
+	for _, perm := range req.Permissions {
+		permissions |= perm
+	}
+
+	plain, token, err := h.apiTokenService.CreateToken(userSession, req.Name, permissions, req.Softwares, req.ExpireDays)
+	if err != nil {
+		util.Response(c, util.CodeInvalidRequest, err.Error(), nil)
+		return
+	}
+
+	permissionNames, _ := util.GetPermissionString(token.Permissions)
+	util.Response(c, util.CodeSuccess, "令牌已创建，请立即保存，关闭后将无法再次查看", gin.H{
+		"token":            plain,
+		"info":             token,
+		"permission_names": permissionNames,
+	})
+}
+
+// ListApiTokens 获取当前代理的API令牌列表
+func (h *ApiTokenHandler) ListApiTokens(c *gin.Context) {
+	userSession := middleware.GetUserInfo(c)
+	if userSession == nil {
+		util.Response(c, util.CodeTokenInvalid, "用户未登录", nil)
+		return
+	}
+
+	tokens, err := h.apiTokenService.ListTokens(userSession.Username)
+	if err != nil {
+		util.Response(c, util.CodeInternalError, err.Error(), nil)
+		return
+	}
+
+	result := make([]gin.H, 0, len(tokens))
+	for i := range tokens {
+		permissionNames, _ := util.GetPermissionString(tokens[i].Permissions)
+		result = append(result, gin.H{
+			"info":             tokens[i],
+			"permission_names": permissionNames,
+		})
+	}
+
+	util.Response(c, util.CodeSuccess, "获取成功", result)
+}
+
+// RevokeApiToken 吊销API令牌
+func (h *ApiTokenHandler) RevokeApiToken(c *gin.Context) {
+	var req struct {
+		ID uint `json:"id" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.Response(c, util.CodeInvalidParam, "请求参数错误", nil)
+		return
+	}
+
+	userSession := middleware.GetUserInfo(c)
+	if userSession == nil {
+		util.Response(c, util.CodeTokenInvalid, "用户未登录", nil)
+		return
+	}
+
+	if err := h.apiTokenService.RevokeToken(userSession.Username, req.ID); err != nil {
+		util.Response(c, util.CodeInvalidRequest, err.Error(), nil)
+		return
+	}
+
+	util.Response(c, util.CodeSuccess, "令牌已吊销", nil)
+}
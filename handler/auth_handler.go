@@ -1,13 +1,18 @@
 package handler
 
 import (
+	"SProtectAgentWeb/middleware"
 	"SProtectAgentWeb/models"
 	"SProtectAgentWeb/services"
 	"SProtectAgentWeb/types"
 	"SProtectAgentWeb/util"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
@@ -15,56 +20,320 @@ import (
 
 // AuthHandler 认证处理器
 type AuthHandler struct {
-	authService *services.AuthService
+	authService           *services.AuthService
+	sessionService        *services.SessionService
+	twoFactorService      *services.TwoFactorService
+	loginLimiter          *services.LoginLimiter
+	jwtService            *services.JWTService
+	loginHistoryService   *services.LoginHistoryService
+	passwordPolicyService *services.PasswordPolicyService
+	captchaService        *services.CaptchaService
 }
 
 // NewAuthHandler 创建认证处理器实例
-func NewAuthHandler(authService *services.AuthService) *AuthHandler {
+func NewAuthHandler(authService *services.AuthService, sessionService *services.SessionService, twoFactorService *services.TwoFactorService, loginLimiter *services.LoginLimiter, jwtService *services.JWTService, loginHistoryService *services.LoginHistoryService, passwordPolicyService *services.PasswordPolicyService, captchaService *services.CaptchaService) *AuthHandler {
 	return &AuthHandler{
-		authService: authService,
+		authService:           authService,
+		sessionService:        sessionService,
+		twoFactorService:      twoFactorService,
+		loginLimiter:          loginLimiter,
+		jwtService:            jwtService,
+		loginHistoryService:   loginHistoryService,
+		passwordPolicyService: passwordPolicyService,
+		captchaService:        captchaService,
 	}
 }
 
+// 登录方式
+const (
+	loginModeSession = "session" // Cookie会话（默认）
+	loginModeJWT     = "jwt"     // JWT令牌，不写Cookie
+)
+
 // Login 用户登录
+// mode为jwt时签发JWT令牌，否则建立Cookie会话
 func (h *AuthHandler) Login(c *gin.Context) {
 	var req struct {
 		Username string `json:"username" binding:"required"`
 		Password string `json:"password" binding:"required"`
+		Mode     string `json:"mode"` // 登录方式：session（默认）/jwt
+		Code     string `json:"code"` // JWT登录时的二次验证码或恢复码
+
+		CaptchaID   string `json:"captcha_id"`   // 图形验证码编号
+		CaptchaCode string `json:"captcha_code"` // 图形验证码
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		util.Response(c, http.StatusBadRequest, "请求参数错误", nil)
 		return
 	}
+	if req.Mode != "" && req.Mode != loginModeSession && req.Mode != loginModeJWT {
+		util.Response(c, util.CodeInvalidParam, "不支持的登录方式", nil)
+		return
+	}
+
+	method := loginModeSession
+	if req.Mode == loginModeJWT {
+		method = loginModeJWT
+	}
+
+	// 登录失败次数过多时暂时拒绝登录，不再校验密码
+	clientIP := c.ClientIP()
+	if wait := h.loginLimiter.Check(req.Username, clientIP); wait > 0 {
+		recordLoginAttempt(c, h.loginHistoryService, req.Username, nil, method, "登录失败次数过多，已暂时锁定")
+		respondLoginLocked(c, wait)
+		return
+	}
+
+	// 连续失败达到阈值或配置为始终启用时需要图形验证码，验证码错误不计入登录失败次数
+	if h.captchaService.Required(h.loginLimiter.Failures(req.Username, clientIP)) {
+		if req.CaptchaID == "" || req.CaptchaCode == "" {
+			util.Response(c, util.CodeCaptchaRequired, util.GetErrorMessage(util.CodeCaptchaRequired), gin.H{
+				"captcha_required": true,
+			})
+			return
+		}
+		if !h.captchaService.Verify(req.CaptchaID, req.CaptchaCode) {
+			util.Response(c, util.CodeCaptchaInvalid, util.GetErrorMessage(util.CodeCaptchaInvalid), gin.H{
+				"captcha_required": true,
+			})
+			return
+		}
+	}
 
 	// 使用AuthService创建用户会话
 	userSession, err := h.authService.CreateUserSession(
 		req.Username,
 		req.Password,
-		c.ClientIP(),
+		clientIP,
 		c.GetHeader("User-Agent"),
 	)
 	if err != nil {
-		util.Response(c, http.StatusUnauthorized, err.Error(), nil)
+		recordLoginAttempt(c, h.loginHistoryService, req.Username, nil, method, err.Error())
+		wait := h.loginLimiter.RecordFailure(req.Username, clientIP)
+		util.Response(c, http.StatusUnauthorized, err.Error(), gin.H{
+			"retry_after":      retryAfterSeconds(wait),
+			"captcha_required": h.captchaService.Required(h.loginLimiter.Failures(req.Username, clientIP)),
+		})
+		return
+	}
+	userSession.UserAgent = c.GetHeader("User-Agent")
+	userSession.LoginTime = time.Now().Unix()
+
+	// 上级代理要求修改密码时，登录后只能访问修改密码接口
+	userSession.MustChangePassword, err = h.passwordPolicyService.IsChangeRequired(userSession.Username, userSession.SoftwareList)
+	if err != nil {
+		util.Response(c, util.CodeInternalError, "查询修改密码要求失败", nil)
+		return
+	}
+
+	// 二次验证：已启用的代理需要输入验证码，被上级要求但未绑定的代理需要先绑定
+	twoFactorEnabled, err := h.twoFactorService.IsEnabled(userSession.Username)
+	if err != nil {
+		util.Response(c, util.CodeInternalError, "查询二次验证状态失败", nil)
+		return
+	}
+	twoFactorRequired := false
+	if !twoFactorEnabled {
+		twoFactorRequired, err = h.twoFactorService.IsRequired(userSession.Username)
+		if err != nil {
+			util.Response(c, util.CodeInternalError, "查询二次验证状态失败", nil)
+			return
+		}
+	}
+
+	if req.Mode == loginModeJWT {
+		h.loginWithJWT(c, userSession, req.Code, twoFactorEnabled, twoFactorRequired)
+		return
+	}
+
+	if twoFactorEnabled || twoFactorRequired {
+		if err := setPendingLogin(c, userSession); err != nil {
+			util.Response(c, http.StatusInternalServerError, "保存会话失败", nil)
+			return
+		}
+
+		if twoFactorEnabled {
+			util.Response(c, util.CodeTwoFactorRequired, "请输入二次验证码", gin.H{
+				"username": userSession.Username,
+			})
+		} else {
+			util.Response(c, util.CodeTwoFactorSetup, "上级代理要求启用二次验证，请先绑定验证器", gin.H{
+				"username": userSession.Username,
+			})
+		}
 		return
 	}
 
 	// 保存到Session
-	session := sessions.Default(c)
-	session.Clear()
-	log.Println("userSession", userSession)
-	session.Set("user_info", userSession)
-	if err := session.Save(); err != nil {
+	if err := completeLogin(c, userSession); err != nil {
 		util.Response(c, http.StatusInternalServerError, "保存会话失败", nil)
 		return
 	}
+	h.loginLimiter.RecordSuccess(req.Username)
+	record := recordLoginAttempt(c, h.loginHistoryService, userSession.Username, userSession, loginModeSession, "")
 
 	// 只返回登录成功消息，不返回软件列表和代理信息
 	util.Response(c, http.StatusOK, "登录成功", gin.H{
-		"username": userSession.Username,
+		"username":             userSession.Username,
+		"alert":                loginAlert(record),
+		"must_change_password": userSession.MustChangePassword,
+	})
+}
+
+// GetCaptcha 获取图形验证码
+// 图片以data URI返回，登录时提交captcha_id和用户输入的验证码
+func (h *AuthHandler) GetCaptcha(c *gin.Context) {
+	id, image, expireSeconds, err := h.captchaService.Generate()
+	if err != nil {
+		util.Response(c, util.CodeInternalError, err.Error(), nil)
+		return
+	}
+
+	util.Response(c, util.CodeSuccess, "获取成功", gin.H{
+		"captcha_id": id,
+		"image":      "data:image/png;base64," + base64.StdEncoding.EncodeToString(image),
+		"expires_in": expireSeconds,
 	})
 }
 
+// loginWithJWT 密码校验通过后签发JWT令牌
+// JWT登录不使用临时会话，启用二次验证的代理需要在同一请求中提交验证码
+func (h *AuthHandler) loginWithJWT(c *gin.Context, userSession *models.UserSession, code string, twoFactorEnabled, twoFactorRequired bool) {
+	if userSession.MustChangePassword {
+		util.Response(c, util.CodePasswordExpired, "上级代理要求修改密码，请先在网页端修改密码", nil)
+		return
+	}
+
+	if twoFactorRequired {
+		util.Response(c, util.CodeTwoFactorSetup, "上级代理要求启用二次验证，请先在网页端绑定验证器", gin.H{
+			"username": userSession.Username,
+		})
+		return
+	}
+
+	if twoFactorEnabled {
+		if code == "" {
+			util.Response(c, util.CodeTwoFactorRequired, "请输入二次验证码", gin.H{
+				"username": userSession.Username,
+			})
+			return
+		}
+
+		ok, err := h.twoFactorService.Verify(userSession.Username, code)
+		if err != nil {
+			util.Response(c, util.CodeInternalError, err.Error(), nil)
+			return
+		}
+		if !ok {
+			recordLoginAttempt(c, h.loginHistoryService, userSession.Username, nil, loginModeJWT, "二次验证码错误")
+			wait := h.loginLimiter.RecordFailure(userSession.Username, c.ClientIP())
+			util.Response(c, util.CodeTwoFactorInvalid, "验证码错误", gin.H{
+				"retry_after": retryAfterSeconds(wait),
+			})
+			return
+		}
+	}
+
+	response, err := h.jwtService.IssueTokens(userSession)
+	if err != nil {
+		util.Response(c, util.CodeInternalError, err.Error(), nil)
+		return
+	}
+	h.loginLimiter.RecordSuccess(userSession.Username)
+	record := recordLoginAttempt(c, h.loginHistoryService, userSession.Username, userSession, loginModeJWT, "")
+	response.Alert = loginAlert(record)
+
+	util.Response(c, http.StatusOK, "登录成功", response)
+}
+
+// RefreshToken 使用刷新令牌换取新的JWT令牌
+func (h *AuthHandler) RefreshToken(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.Response(c, util.CodeInvalidParam, "请求参数错误", nil)
+		return
+	}
+
+	response, err := h.jwtService.Refresh(req.RefreshToken)
+	if err != nil {
+		util.Response(c, util.CodeTokenInvalid, err.Error(), nil)
+		return
+	}
+
+	util.Response(c, util.CodeSuccess, "刷新成功", response)
+}
+
+// VerifyTwoFactor 登录第二步：校验二次验证码或恢复码
+func (h *AuthHandler) VerifyTwoFactor(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required"` // 验证码或恢复码
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.Response(c, util.CodeInvalidParam, "请求参数错误", nil)
+		return
+	}
+
+	pending, err := getPendingLogin(c)
+	if err != nil {
+		util.Response(c, util.CodeTokenInvalid, err.Error(), nil)
+		return
+	}
+
+	clientIP := c.ClientIP()
+	if wait := h.loginLimiter.Check(pending.Username, clientIP); wait > 0 {
+		respondLoginLocked(c, wait)
+		return
+	}
+
+	ok, err := h.twoFactorService.Verify(pending.Username, req.Code)
+	if err != nil {
+		util.Response(c, util.CodeInternalError, err.Error(), nil)
+		return
+	}
+	if !ok {
+		recordLoginAttempt(c, h.loginHistoryService, pending.Username, nil, loginModeSession, "二次验证码错误")
+		h.loginLimiter.RecordFailure(pending.Username, clientIP)
+		if recordPendingLoginFailure(c) {
+			util.Response(c, util.CodeTokenInvalid, "验证码错误次数过多，请重新登录", nil)
+			return
+		}
+		util.Response(c, util.CodeTwoFactorInvalid, "验证码错误", nil)
+		return
+	}
+
+	if err := completeLogin(c, pending); err != nil {
+		util.Response(c, http.StatusInternalServerError, "保存会话失败", nil)
+		return
+	}
+	h.loginLimiter.RecordSuccess(pending.Username)
+	record := recordLoginAttempt(c, h.loginHistoryService, pending.Username, pending, loginModeSession, "")
+
+	util.Response(c, http.StatusOK, "登录成功", gin.H{
+		"username":             pending.Username,
+		"alert":                loginAlert(record),
+		"must_change_password": pending.MustChangePassword,
+	})
+}
+
+// respondLoginLocked 返回登录受限响应，retry_after为需要等待的秒数
+func respondLoginLocked(c *gin.Context, wait time.Duration) {
+	seconds := retryAfterSeconds(wait)
+	c.Header("Retry-After", strconv.FormatInt(seconds, 10))
+	util.Response(c, util.CodeLoginLocked, fmt.Sprintf("登录失败次数过多，请%d秒后再试", seconds), gin.H{
+		"retry_after": seconds,
+	})
+}
+
+// retryAfterSeconds 将等待时间向上取整为秒
+func retryAfterSeconds(wait time.Duration) int64 {
+	return int64((wait + time.Second - 1) / time.Second)
+}
+
 // GetUserInfo 获取用户信息（从session中获取，不重新查询数据库）
 func (h *AuthHandler) GetUserInfo(c *gin.Context) {
 	userSession, err := h.getCurrentUserSession(c)
@@ -75,9 +344,10 @@ func (h *AuthHandler) GetUserInfo(c *gin.Context) {
 
 	// 直接从session中返回用户信息，不重新查询数据库
 	util.Response(c, http.StatusOK, "获取成功", gin.H{
-		"username":            userSession.Username,
-		"software_list":       userSession.SoftwareList,
-		"software_agent_info": userSession.SoftwareAgentInfo,
+		"username":             userSession.Username,
+		"software_list":        userSession.SoftwareList,
+		"software_agent_info":  userSession.SoftwareAgentInfo,
+		"must_change_password": userSession.MustChangePassword,
 	})
 }
 
@@ -89,8 +359,10 @@ func (h *AuthHandler) RefreshUserInfo(c *gin.Context) {
 		return
 	}
 
-	// 重新从数据库查询用户信息
-	userInfo, err := h.authService.GetUserInfo(userSession.Username, userSession.Password)
+	// 重新从数据库查询用户信息，会话中只有密码指纹，由中间件比对
+	userInfo, err := h.sessionService.ReloadUserSession(userSession.Username, func(password string) bool {
+		return middleware.SessionPasswordMatches(userSession, password)
+	})
 	if err != nil {
 		util.Response(c, http.StatusUnauthorized, err.Error(), nil)
 		return
@@ -98,11 +370,15 @@ func (h *AuthHandler) RefreshUserInfo(c *gin.Context) {
 
 	// 更新session中的用户信息
 	updatedSession := &models.UserSession{
-		Username:          userInfo.Username,
-		Password:          userSession.Password,  // 保持原密码
-		IPAddress:         userSession.IPAddress, // 保持原IP
-		SoftwareList:      userInfo.SoftwareList,
-		SoftwareAgentInfo: userInfo.SoftwareAgentInfo,
+		Username:            userInfo.Username,
+		Password:            userSession.Password,            // 保持原密码（仅当前请求内有效）
+		PasswordFingerprint: userSession.PasswordFingerprint, // 保持原密码指纹
+		IPAddress:           userSession.IPAddress,           // 保持原IP
+		UserAgent:           userSession.UserAgent,
+		LoginTime:           userSession.LoginTime,
+		MustChangePassword:  userSession.MustChangePassword,
+		SoftwareList:        userInfo.SoftwareList,
+		SoftwareAgentInfo:   userInfo.SoftwareAgentInfo,
 	}
 	h.updateUserSession(c, updatedSession)
 
@@ -114,6 +390,11 @@ func (h *AuthHandler) RefreshUserInfo(c *gin.Context) {
 }
 
 // ChangePassword 修改密码
+// 账号从当前会话获取，会话中所有软件位的密码同时修改，返回各软件位的修改结果
+// 修改成功后吊销该账号的其他会话，当前会话改用新密码；修改后各软件位密码不一致时吊销包括当前会话在内的全部会话，
+// 重新登录后必须再次修改密码
+// API令牌不绑定密码，不会被吊销，修改密码后仍然有效；各软件位密码不一致期间因存在修改密码要求暂时无法使用。
+// JWT按密码指纹校验，已修改密码的软件位中签发的JWT随之失效
 func (h *AuthHandler) ChangePassword(c *gin.Context) {
 	var req types.ChangePasswordRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -121,13 +402,78 @@ func (h *AuthHandler) ChangePassword(c *gin.Context) {
 		return
 	}
 
-	// 使用AuthService修改密码
-	if err := h.authService.ChangePassword(req.Username, req.Software, req.OldPassword, req.NewPassword); err != nil {
+	userSession, err := h.getCurrentUserSession(c)
+	if err != nil {
+		util.Response(c, util.CodeTokenInvalid, err.Error(), nil)
+		return
+	}
+	if len(userSession.SoftwareList) == 0 {
+		util.Response(c, http.StatusBadRequest, "修改密码失败: 没有可用的软件位", nil)
+		return
+	}
+
+	// 检查新密码是否符合密码策略
+	if req.NewPassword == req.OldPassword {
+		util.Response(c, http.StatusBadRequest, "修改密码失败: 新密码不能与旧密码相同", nil)
+		return
+	}
+	if err := h.passwordPolicyService.Validate(userSession.Username, req.NewPassword); err != nil {
 		util.Response(c, http.StatusBadRequest, "修改密码失败: "+err.Error(), nil)
 		return
 	}
 
-	util.Response(c, http.StatusOK, "密码修改成功", nil)
+	results, err := h.passwordPolicyService.ChangePassword(userSession.Username, userSession.SoftwareList, req.OldPassword, req.NewPassword)
+	if errors.Is(err, services.ErrPasswordInconsistent) {
+		log.Printf("修改密码后各软件位密码不一致 [%s]: %v", userSession.Username, err)
+		for _, software := range userSession.SoftwareList {
+			middleware.InvalidateAgentState(software, userSession.Username)
+		}
+		count, revokeErr := h.sessionService.RevokeAllSessions(userSession.Username, "")
+		if revokeErr != nil {
+			log.Printf("吊销会话失败 [%s]: %v", userSession.Username, revokeErr)
+		}
+		h.clearSession(c)
+		util.Response(c, util.CodePasswordInconsistent, "修改密码失败: "+err.Error(), gin.H{
+			"results":       results,
+			"revoked_count": count,
+		})
+		return
+	}
+	if err != nil {
+		util.Response(c, http.StatusBadRequest, "修改密码失败: "+err.Error(), gin.H{
+			"results": results,
+		})
+		return
+	}
+
+	// 记录历史密码并清除上级代理的修改密码要求
+	if err := h.passwordPolicyService.Remember(userSession.Username, req.NewPassword); err != nil {
+		log.Printf("记录历史密码失败 [%s]: %v", userSession.Username, err)
+	}
+	if err := h.passwordPolicyService.ClearChangeRequirement(userSession.Username, userSession.SoftwareList); err != nil {
+		log.Printf("清除修改密码要求失败 [%s]: %v", userSession.Username, err)
+	}
+
+	// 更新当前会话，其他设备上的会话需要使用新密码重新登录
+	userSession.Password = req.NewPassword
+	userSession.MustChangePassword = false
+	for _, software := range userSession.SoftwareList {
+		if agent := userSession.SoftwareAgentInfo[software]; agent != nil {
+			agent.Password = req.NewPassword
+		}
+		middleware.InvalidateAgentState(software, userSession.Username)
+	}
+	h.updateUserSession(c, userSession)
+
+	count, err := h.sessionService.RevokeAllSessions(userSession.Username, sessions.Default(c).ID())
+	if err != nil {
+		log.Printf("吊销其他会话失败 [%s]: %v", userSession.Username, err)
+	}
+
+	util.Response(c, http.StatusOK, "密码修改成功", gin.H{
+		"results":       results,
+		"revoked_count": count,
+	})
 }
 
 // Logout 用户登出
@@ -136,10 +482,156 @@ func (h *AuthHandler) Logout(c *gin.Context) {
 	util.Response(c, http.StatusOK, "登出成功", nil)
 }
 
+// ListSessions 获取当前代理的所有活跃会话
+func (h *AuthHandler) ListSessions(c *gin.Context) {
+	userSession, err := h.getCurrentUserSession(c)
+	if err != nil {
+		util.Response(c, util.CodeTokenInvalid, err.Error(), nil)
+		return
+	}
+
+	records, err := h.sessionService.ListSessions(userSession.Username)
+	if err != nil {
+		util.Response(c, util.CodeInternalError, "获取会话列表失败: "+err.Error(), nil)
+		return
+	}
+
+	currentID := sessions.Default(c).ID()
+	list := make([]map[string]interface{}, 0, len(records))
+	for _, record := range records {
+		list = append(list, map[string]interface{}{
+			"session_id": services.SessionHandle(record.ID),
+			"ip_address": record.IPAddress,
+			"user_agent": record.UserAgent,
+			"login_time": record.LoginTime,
+			"last_seen":  record.LastSeen,
+			"expires_at": record.ExpiresAt,
+			"current":    record.ID == currentID,
+		})
+	}
+
+	util.Response(c, util.CodeSuccess, "获取会话列表成功", gin.H{
+		"data":  list,
+		"total": len(list),
+	})
+}
+
+// RevokeSession 吊销当前代理的指定会话
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	var req struct {
+		SessionID string `json:"session_id" binding:"required"` // 会话标识（listSessions返回）
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.Response(c, util.CodeInvalidParam, "请求参数错误", nil)
+		return
+	}
+
+	userSession, err := h.getCurrentUserSession(c)
+	if err != nil {
+		util.Response(c, util.CodeTokenInvalid, err.Error(), nil)
+		return
+	}
+
+	revokedID, err := h.sessionService.RevokeSession(userSession.Username, req.SessionID)
+	if err != nil {
+		util.Response(c, util.CodeInvalidParam, err.Error(), nil)
+		return
+	}
+
+	// 吊销的是当前会话时同时清除Cookie
+	if revokedID == sessions.Default(c).ID() {
+		h.clearSession(c)
+	}
+
+	util.Response(c, util.CodeSuccess, "会话已下线", nil)
+}
+
+// LogoutAll 退出所有设备
+// keep_current 为true时保留当前会话，只下线其他设备
+func (h *AuthHandler) LogoutAll(c *gin.Context) {
+	var req struct {
+		KeepCurrent bool `json:"keep_current"`
+	}
+	c.ShouldBindJSON(&req)
+
+	userSession, err := h.getCurrentUserSession(c)
+	if err != nil {
+		util.Response(c, util.CodeTokenInvalid, err.Error(), nil)
+		return
+	}
+
+	exceptID := ""
+	if req.KeepCurrent {
+		exceptID = sessions.Default(c).ID()
+	}
+
+	count, err := h.sessionService.RevokeAllSessions(userSession.Username, exceptID)
+	if err != nil {
+		util.Response(c, util.CodeInternalError, err.Error(), nil)
+		return
+	}
+
+	if !req.KeepCurrent {
+		h.clearSession(c)
+	}
+
+	util.Response(c, util.CodeSuccess, "已退出所有设备", gin.H{
+		"revoked_count": count,
+	})
+}
+
+// RevokeSubAgentSessions 强制下线子代理
+func (h *AuthHandler) RevokeSubAgentSessions(c *gin.Context) {
+	var req struct {
+		Software    string `json:"software" binding:"required"`
+		TargetAgent string `json:"target_agent" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.Response(c, util.CodeInvalidParam, "请求参数错误", nil)
+		return
+	}
+
+	userSession, err := h.getCurrentUserSession(c)
+	if err != nil {
+		util.Response(c, util.CodeTokenInvalid, err.Error(), nil)
+		return
+	}
+
+	// 检查软件位访问权限
+	agent, exists := userSession.SoftwareAgentInfo[req.Software]
+	if !exists {
+		util.Response(c, util.CodePermissionDenied, "无权访问该软件位", nil)
+		return
+	}
+
+	// 检查管理代理权限
+	if !agent.HasPermission(util.PermManageAgent) {
+		util.Response(c, util.CodePermissionDenied, "无权管理代理", nil)
+		return
+	}
+
+	count, err := h.sessionService.RevokeSubAgentSessions(req.Software, agent.User, req.TargetAgent)
+	if err != nil {
+		util.Response(c, util.CodeInternalError, "强制下线失败: "+err.Error(), nil)
+		return
+	}
+
+	util.Response(c, util.CodeSuccess, "子代理已强制下线", gin.H{
+		"revoked_count": count,
+	})
+}
+
 // ===== 私有辅助方法 =====
 
 // getCurrentUserSession 从Session中获取用户会话信息
+// API令牌认证的请求从请求上下文中获取
 func (h *AuthHandler) getCurrentUserSession(c *gin.Context) (*models.UserSession, error) {
+	if middleware.IsAPITokenRequest(c) {
+		return middleware.GetUserInfo(c), nil
+	}
+
 	session := sessions.Default(c)
 	userSessionData := session.Get("user_info")
 	if userSessionData == nil {
@@ -2,7 +2,6 @@ package handler
 
 import (
 	"SProtectAgentWeb/middleware"
-	"SProtectAgentWeb/models"
 	"SProtectAgentWeb/services"
 	"SProtectAgentWeb/util"
 	"log"
@@ -13,15 +12,17 @@ import (
 
 // CardHandler card处理器
 type CardHandler struct {
-	cardService     *services.CardService
-	cardTypeService *services.CardTypeService
+	cardService         *services.CardService
+	cardTypeService     *services.CardTypeService
+	cardGenerateService *services.CardGenerateService
 }
 
 // NewCardHandler 创建card处理器实例
-func NewCardHandler(cardService *services.CardService, cardTypeService *services.CardTypeService) *CardHandler {
+func NewCardHandler(cardService *services.CardService, cardTypeService *services.CardTypeService, cardGenerateService *services.CardGenerateService) *CardHandler {
 	return &CardHandler{
-		cardService:     cardService,
-		cardTypeService: cardTypeService,
+		cardService:         cardService,
+		cardTypeService:     cardTypeService,
+		cardGenerateService: cardGenerateService,
 	}
 }
 
@@ -294,41 +295,12 @@ func (h *CardHandler) GenerateCards(c *gin.Context) {
 		return
 	}
 
-	// 获取卡类型信息以计算成本
-	cardTypes, err := h.cardTypeService.GetCardTypeList(req.Software, agent.User)
-	if err != nil {
-		util.Response(c, util.CodeInternalError, "获取卡类型信息失败: "+err.Error(), nil)
-		return
-	}
-
-	// 找到对应的卡类型
-	var selectedCardType *models.CardType
-	for _, ct := range cardTypes {
-		if ct.Name == req.CardType {
-			selectedCardType = &ct
-			break
-		}
-	}
-
-	if selectedCardType == nil {
-		util.Response(c, util.CodeInternalError, "卡类型不存在或无权限", nil)
-		return
-	}
-
-	// 计算总成本
-	totalCost := selectedCardType.Price * float64(req.Count)
-
-	// 调用服务层生成卡密
-	cards, err := h.cardService.GenerateCards(req.Software, req.CardType, agent.User, req.Count, req.Remarks)
+	// 调用服务层生成卡密，扣费、写入卡密在同一事务中完成，提交后记录流水
+	result, err := h.cardGenerateService.GenerateCards(req.Software, agent.User, req.CardType, req.Count, req.Remarks)
 	if err != nil {
 		util.Response(c, util.CodeInternalError, "生成卡密失败: "+err.Error(), nil)
 		return
 	}
 
-	util.Response(c, util.CodeSuccess, "卡密生成成功", gin.H{
-		"cards":      cards,
-		"count":      len(cards),
-		"total_cost": totalCost,
-		"unit_price": selectedCardType.Price,
-	})
+	util.Response(c, util.CodeSuccess, "卡密生成成功", result)
 }
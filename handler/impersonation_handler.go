@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"SProtectAgentWeb/middleware"
+	"SProtectAgentWeb/models"
+	"SProtectAgentWeb/services"
+	"SProtectAgentWeb/util"
+	"log"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// ImpersonationHandler 模拟查看处理器
+type ImpersonationHandler struct {
+	impersonationService *services.ImpersonationService
+}
+
+// NewImpersonationHandler 创建模拟查看处理器实例
+func NewImpersonationHandler(impersonationService *services.ImpersonationService) *ImpersonationHandler {
+	return &ImpersonationHandler{
+		impersonationService: impersonationService,
+	}
+}
+
+// StartImpersonation 以只读方式查看下级代理
+// 需要管理代理权限，被查看的代理必须在当前代理的下级链中
+func (h *ImpersonationHandler) StartImpersonation(c *gin.Context) {
+	var req struct {
+		Software    string `json:"software" binding:"required"`     // 软件位名称
+		TargetAgent string `json:"target_agent" binding:"required"` // 被查看的下级代理账号
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.Response(c, util.CodeInvalidParam, "请求参数错误", nil)
+		return
+	}
+
+	// 获取当前用户会话
+	userSession := middleware.GetUserInfo(c)
+	if userSession == nil {
+		util.Response(c, util.CodeTokenInvalid, "用户未登录", nil)
+		return
+	}
+
+	// 检查软件位访问权限
+	agent, exists := userSession.SoftwareAgentInfo[req.Software]
+	if !exists {
+		util.Response(c, util.CodePermissionDenied, "无权访问该软件位", nil)
+		return
+	}
+
+	// 检查管理代理权限
+	if !agent.HasPermission(util.PermManageAgent) {
+		util.Response(c, util.CodePermissionDenied, "无权管理代理", nil)
+		return
+	}
+
+	targetSession, err := h.impersonationService.Start(req.Software, agent.User, req.TargetAgent, c.ClientIP())
+	if err != nil {
+		util.Response(c, util.CodeInvalidRequest, err.Error(), nil)
+		return
+	}
+	targetSession.UserAgent = c.GetHeader("User-Agent")
+
+	// 保留上级代理的原会话，结束模拟查看时恢复；会话身份变化时更换会话ID
+	if err := middleware.RegenerateSession(c); err != nil {
+		util.Response(c, util.CodeInternalError, "保存会话失败", nil)
+		return
+	}
+	session := sessions.Default(c)
+	session.Set(middleware.ImpersonatorSessionKey, userSession)
+	session.Set("user_info", targetSession)
+	if err := session.Save(); err != nil {
+		util.Response(c, util.CodeInternalError, "保存会话失败", nil)
+		return
+	}
+
+	c.Set(util.ImpersonationContextKey, &util.ImpersonationInfo{
+		Actor:    agent.User,
+		Target:   targetSession.Username,
+		Software: req.Software,
+		ReadOnly: true,
+	})
+	util.Response(c, util.CodeSuccess, "已进入只读查看模式", gin.H{
+		"username":      targetSession.Username,
+		"software_list": targetSession.SoftwareList,
+	})
+}
+
+// StopImpersonation 结束模拟查看，恢复上级代理的会话
+func (h *ImpersonationHandler) StopImpersonation(c *gin.Context) {
+	userSession := middleware.GetUserInfo(c)
+	impersonator := middleware.GetImpersonator(c)
+	if userSession == nil || userSession.ImpersonatedBy == "" || impersonator == nil {
+		util.Response(c, util.CodeInvalidRequest, "当前不在模拟查看中", nil)
+		return
+	}
+
+	err := h.impersonationService.Record(userSession.ImpersonatedBy, userSession.Username, userSession.ImpersonationSoftware, models.ImpersonationActionStop, "", c.ClientIP())
+	if err != nil {
+		log.Printf("记录模拟查看日志失败: %v", err)
+	}
+
+	if err := middleware.RegenerateSession(c); err != nil {
+		util.Response(c, util.CodeInternalError, "保存会话失败", nil)
+		return
+	}
+	session := sessions.Default(c)
+	session.Delete(middleware.ImpersonatorSessionKey)
+	session.Set("user_info", impersonator)
+	if err := session.Save(); err != nil {
+		util.Response(c, util.CodeInternalError, "保存会话失败", nil)
+		return
+	}
+
+	c.Set(util.ImpersonationContextKey, nil)
+	util.Response(c, util.CodeSuccess, "已退出只读查看模式", gin.H{
+		"username": impersonator.Username,
+	})
+}
+
+// GetImpersonationAudit 查询模拟查看记录
+// 包括自己查看下级代理的记录和被上级代理查看的记录
+func (h *ImpersonationHandler) GetImpersonationAudit(c *gin.Context) {
+	var req struct {
+		Page  int `json:"page"`
+		Limit int `json:"limit"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.Response(c, util.CodeInvalidParam, "请求参数错误", nil)
+		return
+	}
+
+	userSession := middleware.GetUserInfo(c)
+	if userSession == nil {
+		util.Response(c, util.CodeTokenInvalid, "用户未登录", nil)
+		return
+	}
+
+	records, total, err := h.impersonationService.ListAudit(userSession.Username, req.Page, req.Limit)
+	if err != nil {
+		util.Response(c, util.CodeInternalError, err.Error(), nil)
+		return
+	}
+
+	util.Response(c, util.CodeSuccess, "获取模拟查看记录成功", gin.H{
+		"data":  records,
+		"total": total,
+	})
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"SProtectAgentWeb/middleware"
+	"SProtectAgentWeb/models"
+	"SProtectAgentWeb/services"
+	"SProtectAgentWeb/util"
+	"fmt"
+	"log"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LoginHistoryHandler 登录记录处理器
+type LoginHistoryHandler struct {
+	loginHistoryService *services.LoginHistoryService
+}
+
+// NewLoginHistoryHandler 创建登录记录处理器实例
+func NewLoginHistoryHandler(loginHistoryService *services.LoginHistoryService) *LoginHistoryHandler {
+	return &LoginHistoryHandler{
+		loginHistoryService: loginHistoryService,
+	}
+}
+
+// GetLoginHistory 查询登录记录
+// 不指定软件位时查询自己的登录记录；
+// 指定软件位时上级代理可以查询下级代理（target_agent为空表示全部下级）的登录记录，需要管理代理权限
+func (h *LoginHistoryHandler) GetLoginHistory(c *gin.Context) {
+	var req struct {
+		Software       string `json:"software"`        // 软件位名称，查询下级代理时必填
+		TargetAgent    string `json:"target_agent"`    // 下级代理账号，为空表示全部下级
+		Subtree        bool   `json:"subtree"`         // 是否查询下级代理
+		OnlySuspicious bool   `json:"only_suspicious"` // 只返回可疑登录
+		Page           int    `json:"page"`
+		Limit          int    `json:"limit"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.Response(c, util.CodeInvalidParam, "请求参数错误", nil)
+		return
+	}
+
+	userSession := middleware.GetUserInfo(c)
+	if userSession == nil {
+		util.Response(c, util.CodeTokenInvalid, "用户未登录", nil)
+		return
+	}
+
+	usernames := []string{userSession.Username}
+	if req.Subtree || (req.TargetAgent != "" && req.TargetAgent != userSession.Username) {
+		// 检查软件位访问权限
+		agent, exists := userSession.SoftwareAgentInfo[req.Software]
+		if !exists {
+			util.Response(c, util.CodePermissionDenied, "无权访问该软件位", nil)
+			return
+		}
+
+		// 检查管理代理权限
+		if !agent.HasPermission(util.PermManageAgent) {
+			util.Response(c, util.CodePermissionDenied, "无权管理代理", nil)
+			return
+		}
+
+		var err error
+		usernames, err = h.loginHistoryService.SubtreeUsernames(req.Software, agent.User, req.TargetAgent)
+		if err != nil {
+			util.Response(c, util.CodeInvalidRequest, err.Error(), nil)
+			return
+		}
+	}
+
+	records, total, err := h.loginHistoryService.ListHistory(usernames, req.OnlySuspicious, req.Page, req.Limit)
+	if err != nil {
+		util.Response(c, util.CodeInternalError, err.Error(), nil)
+		return
+	}
+
+	util.Response(c, util.CodeSuccess, "获取登录记录成功", gin.H{
+		"data":  records,
+		"total": total,
+	})
+}
+
+// recordLoginAttempt 记录一次登录尝试，记录失败只写日志不影响登录
+// userSession: 登录成功时的用户会话，失败时为nil
+// method: 登录方式（loginModeSession/loginModeJWT）
+// reason: 失败原因，成功时为空
+// 返回: 保存的登录记录，保存失败时为nil
+func recordLoginAttempt(c *gin.Context, loginHistoryService *services.LoginHistoryService, username string, userSession *models.UserSession, method, reason string) *models.LoginHistory {
+	record := &models.LoginHistory{
+		Username:  username,
+		Success:   userSession != nil,
+		Reason:    reason,
+		Method:    method,
+		IPAddress: c.ClientIP(),
+		UserAgent: c.GetHeader("User-Agent"),
+	}
+	if userSession != nil {
+		record.Softwares = util.BuildBracketList(userSession.SoftwareList)
+	}
+
+	if err := loginHistoryService.RecordAttempt(record); err != nil {
+		log.Printf("记录登录日志失败 [%s]: %v", username, err)
+		return nil
+	}
+	return record
+}
+
+// loginAlert 可疑登录的提示信息，非可疑登录返回空字符串
+func loginAlert(record *models.LoginHistory) string {
+	if record == nil || !record.Suspicious {
+		return ""
+	}
+	if record.NewIP {
+		return fmt.Sprintf("本次登录来自新的IP地址 %s，如非本人操作请立即修改密码", record.IPAddress)
+	}
+	return fmt.Sprintf("本次登录前账号已连续%d次登录失败，如非本人操作请立即修改密码", record.AfterFailures)
+}
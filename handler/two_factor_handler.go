@@ -0,0 +1,297 @@
+package handler
+
+import (
+	"SProtectAgentWeb/middleware"
+	"SProtectAgentWeb/models"
+	"SProtectAgentWeb/services"
+	"SProtectAgentWeb/util"
+	"fmt"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// 登录第二步（二次验证）使用的会话键
+const (
+	pendingLoginKey         = "pending_login"          // 已通过密码验证、等待二次验证的用户会话
+	pendingLoginTimeKey     = "pending_login_time"     // 密码验证通过的时间戳
+	pendingLoginAttemptsKey = "pending_login_attempts" // 二次验证失败次数
+	pendingLoginTTL         = 5 * 60                   // 等待二次验证的有效期（秒）
+	pendingLoginMaxAttempts = 5                        // 二次验证最大失败次数，超过后需要重新登录
+)
+
+// TwoFactorHandler 二次验证处理器
+type TwoFactorHandler struct {
+	twoFactorService    *services.TwoFactorService
+	loginHistoryService *services.LoginHistoryService
+}
+
+// NewTwoFactorHandler 创建二次验证处理器实例
+func NewTwoFactorHandler(twoFactorService *services.TwoFactorService, loginHistoryService *services.LoginHistoryService) *TwoFactorHandler {
+	return &TwoFactorHandler{
+		twoFactorService:    twoFactorService,
+		loginHistoryService: loginHistoryService,
+	}
+}
+
+// GetTwoFactorStatus 获取当前代理的二次验证状态
+func (h *TwoFactorHandler) GetTwoFactorStatus(c *gin.Context) {
+	userSession := middleware.GetUserInfo(c)
+	if userSession == nil {
+		util.Response(c, util.CodeTokenInvalid, "用户未登录", nil)
+		return
+	}
+
+	status, err := h.twoFactorService.GetStatus(userSession.Username)
+	if err != nil {
+		util.Response(c, util.CodeInternalError, "获取二次验证状态失败: "+err.Error(), nil)
+		return
+	}
+
+	util.Response(c, util.CodeSuccess, "获取成功", status)
+}
+
+// BeginTwoFactor 开始绑定验证器
+// 已登录的代理可随时绑定；被上级要求启用二次验证的代理在登录第二步中绑定
+func (h *TwoFactorHandler) BeginTwoFactor(c *gin.Context) {
+	username, _, err := twoFactorIdentity(c)
+	if err != nil {
+		util.Response(c, util.CodeTokenInvalid, err.Error(), nil)
+		return
+	}
+
+	secret, uri, err := h.twoFactorService.BeginEnrollment(username)
+	if err != nil {
+		util.Response(c, util.CodeInvalidRequest, err.Error(), nil)
+		return
+	}
+
+	util.Response(c, util.CodeSuccess, "请使用验证器扫描二维码", gin.H{
+		"secret":           secret,
+		"provisioning_uri": uri,
+	})
+}
+
+// ConfirmTwoFactor 输入验证码完成绑定
+// 在登录第二步中完成绑定时同时完成登录
+func (h *TwoFactorHandler) ConfirmTwoFactor(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.Response(c, util.CodeInvalidParam, "请求参数错误", nil)
+		return
+	}
+
+	username, pending, err := twoFactorIdentity(c)
+	if err != nil {
+		util.Response(c, util.CodeTokenInvalid, err.Error(), nil)
+		return
+	}
+
+	codes, err := h.twoFactorService.ConfirmEnrollment(username, req.Code)
+	if err != nil {
+		if pending != nil {
+			recordLoginAttempt(c, h.loginHistoryService, username, nil, loginModeSession, "绑定二次验证失败: "+err.Error())
+		}
+		util.Response(c, util.CodeTwoFactorInvalid, err.Error(), nil)
+		return
+	}
+
+	alert := ""
+	mustChangePassword := false
+	if pending != nil {
+		mustChangePassword = pending.MustChangePassword
+		if err := completeLogin(c, pending); err != nil {
+			util.Response(c, util.CodeInternalError, "保存会话失败", nil)
+			return
+		}
+		alert = loginAlert(recordLoginAttempt(c, h.loginHistoryService, username, pending, loginModeSession, ""))
+	}
+
+	util.Response(c, util.CodeSuccess, "二次验证已启用，请妥善保存恢复码", gin.H{
+		"username":             username,
+		"recovery_codes":       codes,
+		"alert":                alert,
+		"must_change_password": mustChangePassword,
+	})
+}
+
+// DisableTwoFactor 关闭二次验证
+func (h *TwoFactorHandler) DisableTwoFactor(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required"` // 验证码或恢复码
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.Response(c, util.CodeInvalidParam, "请求参数错误", nil)
+		return
+	}
+
+	userSession := middleware.GetUserInfo(c)
+	if userSession == nil {
+		util.Response(c, util.CodeTokenInvalid, "用户未登录", nil)
+		return
+	}
+
+	if err := h.twoFactorService.Disable(userSession.Username, req.Code); err != nil {
+		util.Response(c, util.CodeTwoFactorInvalid, err.Error(), nil)
+		return
+	}
+
+	util.Response(c, util.CodeSuccess, "二次验证已关闭", nil)
+}
+
+// RegenerateRecoveryCodes 重新生成恢复码
+func (h *TwoFactorHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required"` // 验证码或恢复码
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.Response(c, util.CodeInvalidParam, "请求参数错误", nil)
+		return
+	}
+
+	userSession := middleware.GetUserInfo(c)
+	if userSession == nil {
+		util.Response(c, util.CodeTokenInvalid, "用户未登录", nil)
+		return
+	}
+
+	codes, err := h.twoFactorService.RegenerateRecoveryCodes(userSession.Username, req.Code)
+	if err != nil {
+		util.Response(c, util.CodeTwoFactorInvalid, err.Error(), nil)
+		return
+	}
+
+	util.Response(c, util.CodeSuccess, "恢复码已重新生成，旧恢复码已作废", gin.H{
+		"recovery_codes": codes,
+	})
+}
+
+// SetSubAgentTwoFactor 设置子代理是否必须启用二次验证
+func (h *TwoFactorHandler) SetSubAgentTwoFactor(c *gin.Context) {
+	var req struct {
+		Software    string `json:"software" binding:"required"`
+		TargetAgent string `json:"target_agent" binding:"required"`
+		Required    bool   `json:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		util.Response(c, util.CodeInvalidParam, "请求参数错误", nil)
+		return
+	}
+
+	userSession := middleware.GetUserInfo(c)
+	if userSession == nil {
+		util.Response(c, util.CodeTokenInvalid, "用户未登录", nil)
+		return
+	}
+
+	// 检查软件位访问权限
+	agent, exists := userSession.SoftwareAgentInfo[req.Software]
+	if !exists {
+		util.Response(c, util.CodePermissionDenied, "无权访问该软件位", nil)
+		return
+	}
+
+	// 检查管理代理权限
+	if !agent.HasPermission(util.PermManageAgent) {
+		util.Response(c, util.CodePermissionDenied, "无权管理代理", nil)
+		return
+	}
+
+	err := h.twoFactorService.SetSubAgentRequirement(req.Software, agent.User, req.TargetAgent, req.Required)
+	if err != nil {
+		util.Response(c, util.CodeInternalError, "设置失败: "+err.Error(), nil)
+		return
+	}
+
+	util.Response(c, util.CodeSuccess, "设置成功", nil)
+}
+
+// ===== 登录第二步辅助方法 =====
+
+// twoFactorIdentity 获取二次验证操作的代理账号
+// 优先使用已登录会话，其次使用等待二次验证的登录
+// 返回: 代理账号、等待中的用户会话（已登录时为nil）和可能的错误
+func twoFactorIdentity(c *gin.Context) (string, *models.UserSession, error) {
+	if userSession := middleware.GetUserInfo(c); userSession != nil {
+		return userSession.Username, nil, nil
+	}
+
+	pending, err := getPendingLogin(c)
+	if err != nil {
+		return "", nil, err
+	}
+	return pending.Username, pending, nil
+}
+
+// setPendingLogin 保存已通过密码验证、等待二次验证的用户会话，同时更换会话ID
+func setPendingLogin(c *gin.Context, userSession *models.UserSession) error {
+	if err := middleware.RegenerateSession(c); err != nil {
+		return err
+	}
+	session := sessions.Default(c)
+	session.Clear()
+	session.Set(pendingLoginKey, userSession)
+	session.Set(pendingLoginTimeKey, time.Now().Unix())
+	session.Set(pendingLoginAttemptsKey, 0)
+	if err := middleware.IssueCSRFToken(c, session); err != nil {
+		return err
+	}
+	return session.Save()
+}
+
+// getPendingLogin 获取等待二次验证的用户会话
+func getPendingLogin(c *gin.Context) (*models.UserSession, error) {
+	session := sessions.Default(c)
+	pending, ok := session.Get(pendingLoginKey).(*models.UserSession)
+	if !ok {
+		return nil, fmt.Errorf("用户未登录")
+	}
+
+	loginTime, _ := session.Get(pendingLoginTimeKey).(int64)
+	if time.Now().Unix()-loginTime > pendingLoginTTL {
+		session.Clear()
+		session.Save()
+		return nil, fmt.Errorf("验证已超时，请重新登录")
+	}
+
+	return pending, nil
+}
+
+// recordPendingLoginFailure 记录一次二次验证失败
+// 返回: 是否已超过最大失败次数（超过后清除等待中的登录）
+func recordPendingLoginFailure(c *gin.Context) bool {
+	session := sessions.Default(c)
+	attempts, _ := session.Get(pendingLoginAttemptsKey).(int)
+	attempts++
+
+	if attempts >= pendingLoginMaxAttempts {
+		session.Clear()
+		session.Save()
+		return true
+	}
+
+	session.Set(pendingLoginAttemptsKey, attempts)
+	session.Save()
+	return false
+}
+
+// completeLogin 完成登录，更换会话ID后将用户会话写入Session
+func completeLogin(c *gin.Context, userSession *models.UserSession) error {
+	if err := middleware.RegenerateSession(c); err != nil {
+		return err
+	}
+	session := sessions.Default(c)
+	session.Clear()
+	session.Set("user_info", userSession)
+	if err := middleware.IssueCSRFToken(c, session); err != nil {
+		return err
+	}
+	return session.Save()
+}
@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"SProtectAgentWeb/database"
+	"SProtectAgentWeb/models"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// agentValidator 代理状态校验器
+// 每个已认证请求都会重新检查会话中各软件位代理的状态（禁用、删除、过期）和权限，
+// 查询结果按 软件位+代理账号 短时间缓存，避免每个请求都访问软件位数据库
+type agentValidator struct {
+	dbManager *database.DatabaseManager
+	ttl       time.Duration
+	mutex     sync.RWMutex
+	entries   map[string]agentStateEntry
+}
+
+// agentStateEntry 代理状态缓存项
+type agentStateEntry struct {
+	valid     bool      // 代理是否有效
+	authority string    // 代理当前权限
+	checkedAt time.Time // 查询时间
+}
+
+// validator 全局代理状态校验器，在SetupSessionMiddleware中初始化
+var validator *agentValidator
+
+// newAgentValidator 创建代理状态校验器
+// dbManager: 数据库管理器
+// ttl: 缓存有效期
+func newAgentValidator(dbManager *database.DatabaseManager, ttl time.Duration) *agentValidator {
+	return &agentValidator{
+		dbManager: dbManager,
+		ttl:       ttl,
+		entries:   make(map[string]agentStateEntry),
+	}
+}
+
+// check 检查代理在指定软件位中是否仍然有效，并返回其当前权限
+// 代理不存在视为无效；数据库访问失败时返回错误，由调用方决定是否放行
+func (v *agentValidator) check(software, username string) (agentStateEntry, error) {
+	key := software + "\x00" + username
+
+	v.mutex.RLock()
+	entry, exists := v.entries[key]
+	v.mutex.RUnlock()
+	if exists && time.Since(entry.checkedAt) < v.ttl {
+		return entry, nil
+	}
+
+	db, err := v.dbManager.GetSoftwareDB(software)
+	if err != nil {
+		return agentStateEntry{}, err
+	}
+
+	var agent models.Agent
+	err = db.Where("User = ?", username).First(&agent).Error
+	valid := false
+	if err == nil {
+		valid = agent.IsValid()
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return agentStateEntry{}, err
+	}
+
+	entry = agentStateEntry{valid: valid, authority: agent.Authority, checkedAt: time.Now()}
+	v.mutex.Lock()
+	v.entries[key] = entry
+	v.mutex.Unlock()
+
+	return entry, nil
+}
+
+// invalidate 清除指定代理的状态缓存
+func (v *agentValidator) invalidate(software, username string) {
+	v.mutex.Lock()
+	delete(v.entries, software+"\x00"+username)
+	v.mutex.Unlock()
+}
+
+// InvalidateAgentState 清除代理状态缓存，使禁用/删除/修改权限等操作在下一个请求立即生效
+// software: 软件位名称
+// usernames: 代理账号列表
+func InvalidateAgentState(software string, usernames ...string) {
+	if validator == nil {
+		return
+	}
+	for _, username := range usernames {
+		validator.invalidate(software, username)
+	}
+}
+
+// revalidateUserSession 重新校验会话中每个软件位的代理状态
+// 无效的软件位会从会话中移除，权限被上级修改的软件位同步更新会话中的权限
+// 任一软件位的状态无法查询时返回错误且不修改会话，由调用方拒绝本次请求
+// 返回: 会话是否被修改和可能的错误
+func revalidateUserSession(userSession *models.UserSession) (bool, error) {
+	if validator == nil {
+		return false, nil
+	}
+
+	states := make(map[string]agentStateEntry, len(userSession.SoftwareList))
+	for _, software := range userSession.SoftwareList {
+		state, err := validator.check(software, userSession.Username)
+		if err != nil {
+			return false, fmt.Errorf("校验代理状态失败 [%s/%s]: %v", software, userSession.Username, err)
+		}
+		states[software] = state
+	}
+
+	var validList []string
+	changed := false
+	for _, software := range userSession.SoftwareList {
+		state := states[software]
+		if state.valid {
+			validList = append(validList, software)
+			if agent := userSession.SoftwareAgentInfo[software]; agent != nil && agent.Authority != state.authority {
+				agent.Authority = state.authority
+				changed = true
+			}
+			continue
+		}
+
+		delete(userSession.SoftwareAgentInfo, software)
+		changed = true
+	}
+
+	userSession.SoftwareList = validList
+	return changed, nil
+}
@@ -1,35 +1,13 @@
 package middleware
 
 import (
+	"SProtectAgentWeb/config"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
 
-// CORS 跨域资源共享中间件
-// 用于处理浏览器的跨域请求，允许前端页面访问后端API
-// 这对于前后端分离的应用是必需的
-func CORS() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		method := c.Request.Method
-
-		// 设置跨域头
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE, UPDATE")
-		c.Header("Access-Control-Allow-Headers", "Origin, X-Requested-With, Content-Type, Accept, Authorization, Cache-Control, X-File-Name")
-		c.Header("Access-Control-Expose-Headers", "Content-Length, Access-Control-Allow-Origin, Access-Control-Allow-Headers, Cache-Control, Content-Language, Content-Type")
-		c.Header("Access-Control-Allow-Credentials", "true")
-
-		// 处理预检请求
-		if method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
-
-		c.Next()
-	}
-}
-
 // CORSWithConfig 带配置的CORS中间件
 // 允许自定义CORS配置，适用于不同环境的需求
 type CORSConfig struct {
@@ -58,6 +36,8 @@ func CORSWithConfigFunc(config CORSConfig) gin.HandlerFunc {
 		}
 
 		// 如果找到匹配的源，设置响应头
+		// 允许所有来源时浏览器不接受携带凭证，只有明确列出的来源才能携带Cookie
+		c.Header("Vary", "Origin")
 		if allowedOrigin != "" {
 			if allowedOrigin == "*" {
 				c.Header("Access-Control-Allow-Origin", "*")
@@ -103,18 +83,18 @@ func CORSWithConfigFunc(config CORSConfig) gin.HandlerFunc {
 		}
 
 		// 设置是否允许认证信息
-		if config.AllowCredentials {
+		if config.AllowCredentials && allowedOrigin != "" && allowedOrigin != "*" {
 			c.Header("Access-Control-Allow-Credentials", "true")
 		}
 
 		// 设置预检请求缓存时间
 		if config.MaxAge > 0 {
-			c.Header("Access-Control-Max-Age", string(rune(config.MaxAge)))
+			c.Header("Access-Control-Max-Age", strconv.Itoa(config.MaxAge))
 		}
 
 		// 处理OPTIONS预检请求
 		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(http.StatusOK)
+			c.AbortWithStatus(http.StatusNoContent)
 			return
 		}
 
@@ -178,24 +158,16 @@ func GetProductionCORSConfig(frontendDomains []string) CORSConfig {
 	}
 }
 
-// CORSMiddleware 跨域资源共享中间件
-// 允许前端应用从不同域名访问API
-func CORSMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// 设置CORS头
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
-		c.Header("Access-Control-Expose-Headers", "Content-Length, Access-Control-Allow-Origin, Access-Control-Allow-Headers, Content-Type")
-		c.Header("Access-Control-Max-Age", "172800")
-		c.Header("Access-Control-Allow-Credentials", "true")
-
-		// 处理预检请求
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
-
-		c.Next()
+// GetConfiguredCORSConfig 获取配置文件[跨域设置]中的CORS配置
+// 返回: 路由使用的CORS配置
+func GetConfiguredCORSConfig() CORSConfig {
+	settings := config.GetCORSConfig()
+	return CORSConfig{
+		AllowOrigins:     config.GetCORSAllowOrigins(),
+		AllowMethods:     config.SplitList(settings.AllowMethods),
+		AllowHeaders:     config.SplitList(settings.AllowHeaders),
+		ExposeHeaders:    config.SplitList(settings.ExposeHeaders),
+		AllowCredentials: settings.AllowCredentials,
+		MaxAge:           settings.MaxAge,
 	}
 }
@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"SProtectAgentWeb/config"
+	"SProtectAgentWeb/util"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// CSRF令牌在Session、Cookie和请求头中使用的名称
+const (
+	csrfSessionKey = "csrf_token"   // Session中保存的令牌
+	CSRFCookieName = "csrf_token"   // 供前端脚本读取的Cookie（非HttpOnly）
+	CSRFHeaderName = "X-CSRF-Token" // 前端提交令牌使用的请求头
+)
+
+// csrfExemptPaths 不校验CSRF令牌的接口
+// 登录前还没有令牌；刷新JWT使用请求体中的刷新令牌，不依赖Cookie
+var csrfExemptPaths = map[string]bool{
+	"/api/auth/login":        true,
+	"/api/auth/captcha":      true,
+	"/api/auth/refreshToken": true,
+}
+
+// IssueCSRFToken 生成新的CSRF令牌并写入Session和Cookie
+// 在登录（包括等待二次验证的临时会话）写入Session时调用，调用方负责保存Session
+func IssueCSRFToken(c *gin.Context, session sessions.Session) error {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Errorf("生成CSRF令牌失败: %v", err)
+	}
+	token := hex.EncodeToString(buf)
+
+	session.Set(csrfSessionKey, token)
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(CSRFCookieName, token, config.GetSessionMaxAge(), "/", "", c.Request.TLS != nil, false)
+	return nil
+}
+
+// CSRFProtection CSRF防护中间件
+// 对所有修改状态的请求（非GET/HEAD/OPTIONS）：
+// 1. 带有Origin头时，只接受同源或跨域设置中允许的来源；
+// 2. 请求头中的CSRF令牌必须与Session中保存的令牌一致。
+// 使用 Authorization: Bearer 令牌认证的请求不依赖Cookie，不校验CSRF令牌
+func CSRFProtection() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+
+		if origin := c.GetHeader("Origin"); origin != "" && !isSameOrigin(c, origin) && !isAllowedOrigin(origin) {
+			abortCSRF(c, "请求来源不被允许")
+			return
+		}
+
+		if csrfExemptPaths[c.FullPath()] || bearerToken(c) != "" {
+			c.Next()
+			return
+		}
+
+		expected, _ := sessions.Default(c).Get(csrfSessionKey).(string)
+		actual := c.GetHeader(CSRFHeaderName)
+		if expected == "" || actual == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(actual)) != 1 {
+			abortCSRF(c, util.GetErrorMessage(util.CodeCSRFInvalid))
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// abortCSRF 拒绝未通过CSRF校验的请求
+func abortCSRF(c *gin.Context, message string) {
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+		"code":    util.CodeCSRFInvalid,
+		"message": message,
+	})
+}
+
+// isSameOrigin 请求来源是否与服务端同源（按主机名和端口比较）
+func isSameOrigin(c *gin.Context, origin string) bool {
+	parsed, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return parsed.Host == c.Request.Host
+}
+
+// isAllowedOrigin 请求来源是否在跨域设置的允许列表中（*表示允许所有来源）
+func isAllowedOrigin(origin string) bool {
+	for _, allowed := range config.GetCORSAllowOrigins() {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"SProtectAgentWeb/models"
+	"SProtectAgentWeb/services"
+	"SProtectAgentWeb/util"
+	"log"
+	"net/http"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// ImpersonatorSessionKey 模拟查看期间Session中保存上级代理原会话的键，结束模拟查看时恢复
+const ImpersonatorSessionKey = "impersonator"
+
+// impersonationService 模拟查看审计服务，在SetupSessionMiddleware中初始化
+var impersonationService *services.ImpersonationService
+
+// impersonationReadOnlyPaths 模拟查看期间允许访问的只读接口，其余接口一律拒绝
+var impersonationReadOnlyPaths = map[string]bool{
+	"/api/auth/getUserInfo":            true,
+	"/api/auth/loginHistory":           true,
+	"/api/auth/stopImpersonation":      true,
+	"/api/auth/logout":                 true,
+	"/api/agent/getUserInfo":           true,
+	"/api/agent/getSubAgentList":       true,
+	"/api/agent/getAgentTree":          true,
+	"/api/agent/getExpiringAgents":     true,
+	"/api/agent/getLedger":             true,
+	"/api/agent/getAgentCardType":      true,
+	"/api/software/GetSoftware":        true,
+	"/api/software/GetEnabledSoftware": true,
+	"/api/software/GetSoftwareList":    true,
+	"/api/software/GetSoftwareInfo":    true,
+	"/api/card/getCardList":            true,
+	"/api/cardtype/getCardTypeList":    true,
+	"/api/cardtype/getCardTypeByName":  true,
+}
+
+// GetImpersonator 获取模拟查看期间保存的上级代理原会话，非模拟查看时返回nil
+func GetImpersonator(c *gin.Context) *models.UserSession {
+	impersonator, _ := sessions.Default(c).Get(ImpersonatorSessionKey).(*models.UserSession)
+	return impersonator
+}
+
+// checkImpersonation 模拟查看期间的请求检查
+// 上级代理自身失效时结束会话；为响应加上模拟查看标记，记录审计日志，并拒绝非只读接口
+// 返回: 是否允许继续处理请求
+func checkImpersonation(c *gin.Context, userSession *models.UserSession) bool {
+	impersonator := GetImpersonator(c)
+	if impersonator != nil {
+		revalidateUserSession(impersonator)
+	}
+	if impersonator == nil || impersonator.SoftwareAgentInfo[userSession.ImpersonationSoftware] == nil {
+		session := sessions.Default(c)
+		session.Clear()
+		session.Save()
+		log.Printf("模拟查看的上级代理 [%s] 已失效，会话已终止", userSession.ImpersonatedBy)
+
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"code":     401,
+			"message":  "会话无效，请重新登录",
+			"redirect": "/views/login.html",
+		})
+		return false
+	}
+
+	c.Set(util.ImpersonationContextKey, &util.ImpersonationInfo{
+		Actor:    userSession.ImpersonatedBy,
+		Target:   userSession.Username,
+		Software: userSession.ImpersonationSoftware,
+		ReadOnly: true,
+	})
+	c.Header("X-Impersonation", "read-only")
+
+	path := c.FullPath()
+	allowed := impersonationReadOnlyPaths[path]
+
+	action := models.ImpersonationActionRequest
+	if !allowed {
+		action = models.ImpersonationActionDenied
+	}
+	if impersonationService != nil {
+		err := impersonationService.Record(userSession.ImpersonatedBy, userSession.Username, userSession.ImpersonationSoftware, action, path, c.ClientIP())
+		if err != nil {
+			log.Printf("记录模拟查看日志失败: %v", err)
+		}
+	}
+
+	if !allowed {
+		util.Response(c, util.CodeImpersonationDeny, util.GetErrorMessage(util.CodeImpersonationDeny), nil)
+		c.Abort()
+		return false
+	}
+	return true
+}
@@ -1,47 +1,113 @@
 package middleware
 
 import (
+	"SProtectAgentWeb/config"
+	"SProtectAgentWeb/database"
 	"SProtectAgentWeb/models"
+	"SProtectAgentWeb/services"
+	"SProtectAgentWeb/util"
+	"crypto/hmac"
 	"crypto/rand"
-	"encoding/hex"
+	"crypto/sha256"
+	"crypto/subtle"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gin-contrib/sessions"
-	"github.com/gin-contrib/sessions/memstore"
 	"github.com/gin-gonic/gin"
 )
 
+// sessionCookieName 会话Cookie名称
+const sessionCookieName = "sessionid"
+
+// sessionStore 当前使用的持久化Session存储，供认证中间件更新最后访问时间和比对密码指纹
+var sessionStore *SQLiteStore
+
+// passwordChangeAllowedPaths 被要求修改密码的代理在修改密码前仍可访问的接口
+var passwordChangeAllowedPaths = map[string]bool{
+	"/api/auth/changePassword": true,
+	"/api/auth/getUserInfo":    true,
+	"/api/auth/logout":         true,
+}
+
 // SetupSessionMiddleware 设置Session中间件
-func SetupSessionMiddleware() gin.HandlerFunc {
-	// 每次启动生成新的32字节随机密钥
-	secretKey := make([]byte, 32)
-	if _, err := rand.Read(secretKey); err != nil {
-		log.Fatal("生成Session密钥失败:", err)
+// 会话数据持久化到Web端附属数据库，程序重启后会话依然有效
+func SetupSessionMiddleware(dbManager *database.DatabaseManager) gin.HandlerFunc {
+	db, err := dbManager.GetWebDB()
+	if err != nil {
+		log.Fatal("打开会话数据库失败:", err)
 	}
 
-	// 打印密钥用于调试（可选）
-	log.Printf("Session密钥已生成: %s", hex.EncodeToString(secretKey))
-
-	// 创建内存存储
-	store := memstore.NewStore(secretKey)
+	store := NewSQLiteStore(db, sessionKeyPairs()...)
 
 	// 配置Session选项
 	store.Options(sessions.Options{
-		Path:     "/",                  // Cookie路径
-		MaxAge:   3 * 3600,             // 1小时过期
-		Secure:   false,                // 开发环境设为false，生产环境设为true
-		HttpOnly: true,                 // 防止XSS攻击
-		SameSite: http.SameSiteLaxMode, // 防止CSRF攻击
+		Path:     "/",                       // Cookie路径
+		MaxAge:   config.GetSessionMaxAge(), // 会话有效期
+		Secure:   false,                     // 开发环境设为false，生产环境设为true
+		HttpOnly: true,                      // 防止XSS攻击
+		SameSite: http.SameSiteLaxMode,      // 防止CSRF攻击
 	})
 
-	// 返回Session中间件，Cookie名称为 "sessionID"
-	return sessions.Sessions("sessionid", store)
+	// 后台定期清理过期会话
+	store.StartCleanup(time.Duration(config.GetSessionCleanInterval()) * time.Second)
+	sessionStore = store
+
+	// 代理状态校验器，认证中间件用它在每个请求中重新检查代理状态
+	validator = newAgentValidator(dbManager, time.Duration(config.GetAgentStateCacheSeconds())*time.Second)
+
+	// 令牌认证服务，认证中间件用它校验 Authorization: Bearer 中的API令牌和JWT
+	apiTokenService = services.NewApiTokenService(dbManager)
+	jwtService = services.NewJWTService(dbManager)
+	impersonationService = services.NewImpersonationService(dbManager)
+
+	// 返回Session中间件
+	return sessions.Sessions(sessionCookieName, store)
+}
+
+// RegenerateSession 登录、完成二次验证、开始或结束模拟查看等权限变化时更换会话ID
+// 旧会话ID的服务端记录立即删除，防止登录前被植入的会话ID在登录后继续有效（会话固定攻击）
+// 必须在session.Save()之前调用
+func RegenerateSession(c *gin.Context) error {
+	if sessionStore == nil {
+		return nil
+	}
+	return sessionStore.Regenerate(c.Request, sessionCookieName)
+}
+
+// sessionKeyPairs 根据配置的会话密钥派生签名密钥和加密密钥
+// 未配置会话密钥时每次启动生成新的随机密钥，重启后旧会话全部失效
+func sessionKeyPairs() [][]byte {
+	secret := config.GetSessionSecret()
+	if secret == "" {
+		log.Println("未配置会话密钥，使用随机密钥，重启后需要重新登录")
+
+		hashKey := make([]byte, 32)
+		blockKey := make([]byte, 32)
+		if _, err := rand.Read(hashKey); err != nil {
+			log.Fatal("生成Session密钥失败:", err)
+		}
+		if _, err := rand.Read(blockKey); err != nil {
+			log.Fatal("生成Session密钥失败:", err)
+		}
+		return [][]byte{hashKey, blockKey}
+	}
+
+	hashKey := sha256.Sum256([]byte("session-hash:" + secret))
+	blockKey := sha256.Sum256([]byte("session-block:" + secret))
+	return [][]byte{hashKey[:], blockKey[:]}
 }
 
 // RequireSessionAuth 需要Session认证的中间件
+// 携带 Authorization: Bearer 令牌的请求改用令牌认证（个人API令牌或JWT），由客户端逐请求选择
 func RequireSessionAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if token := bearerToken(c); token != "" {
+			authenticateBearer(c, token)
+			return
+		}
+
 		// 检查是否为AJAX请求
 		isAjaxRequest := c.GetHeader("X-Requested-With") == "XMLHttpRequest"
 
@@ -108,12 +174,87 @@ func RequireSessionAuth() gin.HandlerFunc {
 			return
 		}
 
+		// 重新校验代理状态，被禁用、删除或过期的软件位立即从会话中移除
+		// 状态无法查询时拒绝本次请求，会话保持不变，数据库恢复后无需重新登录
+		changed, err := revalidateUserSession(userSession)
+		if err != nil {
+			log.Println(err)
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"code":    util.CodeDatabaseError,
+				"message": "代理状态校验失败，请稍后重试",
+			})
+			return
+		}
+		if changed {
+			if len(userSession.SoftwareList) == 0 {
+				session.Clear()
+				session.Save()
+				log.Printf("代理 [%s] 已被禁用或已过期，会话已终止", userSession.Username)
+
+				if isAjaxRequest {
+					c.JSON(http.StatusUnauthorized, gin.H{
+						"code":     401,
+						"message":  "账号已被禁用或已过期，请联系上级代理",
+						"redirect": "/views/login.html",
+					})
+				} else {
+					c.Redirect(http.StatusFound, "/views/login.html")
+				}
+
+				c.Abort()
+				return
+			}
+
+			session.Set("user_info", userSession)
+			if err := session.Save(); err != nil {
+				log.Printf("更新会话失败: %v", err)
+			}
+		}
+
+		// 记录会话最后访问时间，用于活跃会话列表
+		if sessionStore != nil {
+			if err := sessionStore.Touch(session.ID()); err != nil {
+				log.Printf("更新会话访问时间失败: %v", err)
+			}
+		}
+
+		// 模拟查看期间只能访问只读接口
+		if userSession.ImpersonatedBy != "" && !checkImpersonation(c, userSession) {
+			return
+		}
+
+		// 上级代理要求修改密码时，修改前只能访问修改密码相关接口
+		if userSession.MustChangePassword && !passwordChangeAllowedPaths[c.FullPath()] {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"code":    util.CodePasswordExpired,
+				"message": util.GetErrorMessage(util.CodePasswordExpired),
+			})
+			return
+		}
+
 		c.Next()
 	}
 }
 
+// SessionPasswordMatches 判断代理当前密码是否与会话登录时使用的密码一致
+// 持久化后的会话中没有明文密码，只能与保存的密码指纹比对
+func SessionPasswordMatches(userSession *models.UserSession, password string) bool {
+	if userSession.Password != "" {
+		return subtle.ConstantTimeCompare([]byte(userSession.Password), []byte(password)) == 1
+	}
+	if sessionStore == nil || userSession.PasswordFingerprint == "" {
+		return false
+	}
+	return hmac.Equal([]byte(sessionStore.PasswordFingerprint(password)), []byte(userSession.PasswordFingerprint))
+}
+
 // GetUserInfo 获取用户会话信息（从session中获取，不重新查询数据库）
+// API令牌认证的请求从请求上下文中获取
 func GetUserInfo(c *gin.Context) *models.UserSession {
+	if userSession := userInfoFromContext(c); userSession != nil {
+		return userSession
+	}
+
 	session := sessions.Default(c)
 	userSessionData := session.Get("user_info")
 	if userSessionData == nil {
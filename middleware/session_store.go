@@ -0,0 +1,307 @@
+package middleware
+
+import (
+	"SProtectAgentWeb/models"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gorilla/securecookie"
+	gsessions "github.com/gorilla/sessions"
+	"gorm.io/gorm"
+)
+
+// SQLiteStore 基于SQLite的持久化Session存储
+// Cookie中只保存签名加密后的会话ID，会话数据保存在Web端附属数据库的WebSessions表中
+// 程序重启后只要会话密钥不变，已登录的代理无需重新登录
+type SQLiteStore struct {
+	db             *gorm.DB
+	codecs         []securecookie.Codec
+	options        *gsessions.Options
+	fingerprintKey []byte
+	quit           chan struct{}
+}
+
+// NewSQLiteStore 创建SQLite Session存储
+// db: Web端附属数据库连接（WebSessions表由DatabaseManager自动迁移）
+// keyPairs: 签名密钥和加密密钥（与gorilla/sessions的约定一致）
+// 返回: Session存储实例
+func NewSQLiteStore(db *gorm.DB, keyPairs ...[]byte) *SQLiteStore {
+	store := &SQLiteStore{
+		db:     db,
+		codecs: securecookie.CodecsFromPairs(keyPairs...),
+		options: &gsessions.Options{
+			Path:   "/",
+			MaxAge: 3 * 3600,
+		},
+	}
+	if len(keyPairs) > 0 {
+		store.fingerprintKey = keyPairs[0]
+	}
+	return store
+}
+
+// Options 设置Session选项（实现 sessions.Store 接口）
+func (s *SQLiteStore) Options(options sessions.Options) {
+	s.options = options.ToGorillaOptions()
+
+	// Cookie中的会话ID与服务端记录使用相同的有效期
+	for _, codec := range s.codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(options.MaxAge)
+		}
+	}
+}
+
+// Get 获取当前请求的Session，同一请求内多次调用返回同一个实例
+func (s *SQLiteStore) Get(r *http.Request, name string) (*gsessions.Session, error) {
+	return gsessions.GetRegistry(r).Get(s, name)
+}
+
+// New 创建或加载Session
+// Cookie无效、会话不存在或已过期时返回一个新的空Session
+func (s *SQLiteStore) New(r *http.Request, name string) (*gsessions.Session, error) {
+	session := gsessions.NewSession(s, name)
+	options := *s.options
+	session.Options = &options
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+
+	var id string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &id, s.codecs...); err != nil {
+		// 密钥变更或Cookie被篡改，视为未登录
+		return session, nil
+	}
+
+	var record models.WebSession
+	err = s.db.Where("ID = ?", id).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return session, nil
+	}
+	if err != nil {
+		return session, fmt.Errorf("读取会话失败: %v", err)
+	}
+
+	// 服务端过期校验，不依赖浏览器是否按时删除Cookie
+	if record.ExpiresAt < time.Now().Unix() {
+		s.db.Where("ID = ?", id).Delete(&models.WebSession{})
+		return session, nil
+	}
+
+	if err := gob.NewDecoder(bytes.NewReader(record.Data)).Decode(&session.Values); err != nil {
+		log.Printf("解析会话数据失败: %v", err)
+		return session, nil
+	}
+
+	session.ID = id
+	session.IsNew = false
+	return session, nil
+}
+
+// Save 保存Session
+// MaxAge < 0 或会话数据已被清空时删除服务端记录
+func (s *SQLiteStore) Save(r *http.Request, w http.ResponseWriter, session *gsessions.Session) error {
+	if session.Options.MaxAge < 0 || len(session.Values) == 0 {
+		if session.ID != "" {
+			if err := s.Delete(session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, gsessions.NewCookie(session.Name(), "", &gsessions.Options{
+			Path:     session.Options.Path,
+			Domain:   session.Options.Domain,
+			MaxAge:   -1,
+			Secure:   session.Options.Secure,
+			HttpOnly: session.Options.HttpOnly,
+			SameSite: session.Options.SameSite,
+		}))
+		return nil
+	}
+
+	if session.ID == "" {
+		session.ID = strings.TrimRight(base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "=")
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(s.persistentValues(session.Values)); err != nil {
+		return fmt.Errorf("序列化会话数据失败: %v", err)
+	}
+
+	now := time.Now().Unix()
+	record := &models.WebSession{
+		ID:        session.ID,
+		Data:      buf.Bytes(),
+		LastSeen:  now,
+		CreatedAt: now,
+		UpdatedAt: now,
+		ExpiresAt: now + int64(session.Options.MaxAge),
+	}
+	// 模拟查看期间会话仍归属发起查看的上级代理
+	userSession, ok := session.Values[ImpersonatorSessionKey].(*models.UserSession)
+	if !ok {
+		userSession, ok = session.Values["user_info"].(*models.UserSession)
+	}
+	if ok {
+		record.Username = userSession.Username
+		record.IPAddress = userSession.IPAddress
+		record.UserAgent = userSession.UserAgent
+		record.LoginTime = userSession.LoginTime
+	}
+
+	// 已存在则只更新数据和过期时间，保留创建时间
+	result := s.db.Model(&models.WebSession{}).Where("ID = ?", record.ID).Updates(map[string]interface{}{
+		"Username":  record.Username,
+		"IPAddress": record.IPAddress,
+		"UserAgent": record.UserAgent,
+		"LoginTime": record.LoginTime,
+		"LastSeen":  record.LastSeen,
+		"Data":      record.Data,
+		"UpdatedAt": record.UpdatedAt,
+		"ExpiresAt": record.ExpiresAt,
+	})
+	if result.Error != nil {
+		return fmt.Errorf("保存会话失败: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		if err := s.db.Create(record).Error; err != nil {
+			return fmt.Errorf("保存会话失败: %v", err)
+		}
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return fmt.Errorf("编码会话Cookie失败: %v", err)
+	}
+	http.SetCookie(w, gsessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// persistentValues 生成写入数据库的会话数据
+// 用户会话中的明文密码（包括各软件位代理信息中的密码）替换为密码指纹，内存中的会话不受影响
+func (s *SQLiteStore) persistentValues(values map[interface{}]interface{}) map[interface{}]interface{} {
+	persisted := make(map[interface{}]interface{}, len(values))
+	for key, value := range values {
+		if userSession, ok := value.(*models.UserSession); ok && userSession != nil {
+			value = s.stripPasswords(userSession)
+		}
+		persisted[key] = value
+	}
+	return persisted
+}
+
+// stripPasswords 复制用户会话并去掉其中的明文密码
+func (s *SQLiteStore) stripPasswords(userSession *models.UserSession) *models.UserSession {
+	if userSession.Password != "" {
+		userSession.PasswordFingerprint = s.PasswordFingerprint(userSession.Password)
+	}
+
+	stripped := *userSession
+	stripped.Password = ""
+	if userSession.SoftwareAgentInfo != nil {
+		stripped.SoftwareAgentInfo = make(map[string]*models.Agent, len(userSession.SoftwareAgentInfo))
+		for software, agent := range userSession.SoftwareAgentInfo {
+			if agent != nil {
+				copied := *agent
+				copied.Password = ""
+				agent = &copied
+			}
+			stripped.SoftwareAgentInfo[software] = agent
+		}
+	}
+	return &stripped
+}
+
+// PasswordFingerprint 计算会话中保存的密码指纹，使用会话签名密钥作为HMAC密钥
+func (s *SQLiteStore) PasswordFingerprint(password string) string {
+	mac := hmac.New(sha256.New, s.fingerprintKey)
+	mac.Write([]byte("password:" + password))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Regenerate 更换当前请求会话的ID，并删除旧ID的服务端记录
+// 会话数据保留，保存时生成新的会话ID并下发新的Cookie
+func (s *SQLiteStore) Regenerate(r *http.Request, name string) error {
+	session, err := s.Get(r, name)
+	if err != nil {
+		return err
+	}
+
+	if session.ID != "" {
+		if err := s.Delete(session.ID); err != nil {
+			return err
+		}
+	}
+	session.ID = ""
+	session.IsNew = true
+	return nil
+}
+
+// Delete 删除指定会话的服务端记录
+func (s *SQLiteStore) Delete(id string) error {
+	if err := s.db.Where("ID = ?", id).Delete(&models.WebSession{}).Error; err != nil {
+		return fmt.Errorf("删除会话失败: %v", err)
+	}
+	return nil
+}
+
+// Touch 更新会话的最后访问时间
+// 同一会话一分钟内只写一次数据库，避免每个请求都产生写操作
+func (s *SQLiteStore) Touch(id string) error {
+	now := time.Now().Unix()
+	return s.db.Model(&models.WebSession{}).
+		Where("ID = ? AND LastSeen < ?", id, now-60).
+		Update("LastSeen", now).Error
+}
+
+// Cleanup 清除所有已过期的会话
+// 返回: 清除的会话数量和可能的错误
+func (s *SQLiteStore) Cleanup() (int64, error) {
+	result := s.db.Where("ExpiresAt < ?", time.Now().Unix()).Delete(&models.WebSession{})
+	return result.RowsAffected, result.Error
+}
+
+// StartCleanup 启动后台协程定期清除过期会话
+// interval: 清理间隔
+func (s *SQLiteStore) StartCleanup(interval time.Duration) {
+	s.quit = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				count, err := s.Cleanup()
+				if err != nil {
+					log.Printf("清理过期会话失败: %v", err)
+				} else if count > 0 {
+					log.Printf("已清理过期会话: %d 个", count)
+				}
+			case <-s.quit:
+				return
+			}
+		}
+	}()
+}
+
+// StopCleanup 停止后台清理协程
+func (s *SQLiteStore) StopCleanup() {
+	if s.quit != nil {
+		close(s.quit)
+		s.quit = nil
+	}
+}
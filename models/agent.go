@@ -2,6 +2,7 @@ package models
 
 import (
 	"SProtectAgentWeb/util"
+	"math"
 	"strconv"
 	"time"
 )
@@ -141,3 +142,11 @@ func (a *Agent) CalcCardPrice(basePrice float64) float64 {
 	// 使用总利率计算
 	return basePrice * (a.TatalParities / 100.0)
 }
+
+// CalcTatalParities 根据上级代理的总利率计算下级代理的总利率
+// 总利率为代理链上各级利率的乘积，保留2位小数
+// parentTotal: 上级代理的总利率
+// parities: 下级代理自身的利率
+func CalcTatalParities(parentTotal, parities float64) float64 {
+	return math.Round(parentTotal*parities/100.0*100) / 100
+}
@@ -0,0 +1,28 @@
+package models
+
+// 下级代理管理审计动作
+const (
+	AgentAuditResetPassword = "reset_password" // 重置下级代理密码
+	AgentAuditWithdraw      = "withdraw"       // 从下级代理回收余额和时长
+	AgentAuditParities      = "parities"       // 修改下级代理返利利率
+	AgentAuditMove          = "move"           // 移动下级代理到新的上级代理下
+	AgentAuditDelete        = "delete"         // 删除下级代理
+)
+
+// AgentAudit 下级代理管理审计记录
+// 对应Web端附属数据库中的AgentAudit表，记录上级代理对下级代理的敏感操作
+type AgentAudit struct {
+	ID        uint   `gorm:"column:ID;primaryKey;autoIncrement" json:"id"` // 记录编号
+	Software  string `gorm:"column:Software;size:100" json:"software"`     // 软件位名称
+	Actor     string `gorm:"column:Actor;size:100;index" json:"actor"`     // 执行操作的上级代理
+	Target    string `gorm:"column:Target;size:100;index" json:"target"`   // 被操作的下级代理
+	Action    string `gorm:"column:Action;size:30" json:"action"`          // 动作
+	Detail    string `gorm:"column:Detail;size:400" json:"detail"`         // 操作说明，不包含密码等敏感内容
+	IPAddress string `gorm:"column:IPAddress;size:64" json:"ip_address"`   // 客户端IP
+	CreatedAt int64  `gorm:"column:CreatedAt;index" json:"created_at"`     // 记录时间戳
+}
+
+// TableName 指定表名
+func (AgentAudit) TableName() string {
+	return "AgentAudit"
+}
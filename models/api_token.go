@@ -0,0 +1,25 @@
+package models
+
+// ApiToken 代理个人API令牌
+// 对应Web端附属数据库中的ApiTokens表，供自动化脚本通过 Authorization: Bearer 调用接口
+// 令牌明文只在创建时返回一次，数据库中只保存SHA-256摘要
+type ApiToken struct {
+	ID           uint     `gorm:"column:ID;primaryKey;autoIncrement" json:"id"`           // 令牌编号
+	Username     string   `gorm:"column:Username;size:100;index" json:"-"`                // 所属代理账号
+	Name         string   `gorm:"column:Name;size:100" json:"name"`                       // 令牌名称
+	TokenHash    string   `gorm:"column:TokenHash;size:64;uniqueIndex" json:"-"`          // 令牌SHA-256摘要
+	Prefix       string   `gorm:"column:Prefix;size:16" json:"prefix"`                    // 令牌前缀，便于识别
+	Permissions  string   `gorm:"column:Permissions;size:50" json:"permissions"`          // 授权的权限位掩码(十六进制)
+	Softwares    string   `gorm:"column:Softwares;type:text" json:"-"`                    // 授权的软件位，格式 [软件位1],[软件位2]
+	SoftwareList []string `gorm:"-" json:"softwares"`                                     // 授权的软件位(数组格式，用于JSON序列化)
+	ExpiresAt    int64    `gorm:"column:ExpiresAt" json:"expires_at"`                     // 过期时间戳
+	LastUsedAt   int64    `gorm:"column:LastUsedAt" json:"last_used_at"`                  // 最后使用时间戳
+	LastUsedIP   string   `gorm:"column:LastUsedIP;size:64" json:"last_used_ip"`          // 最后使用IP
+	CreatedAt    int64    `gorm:"column:CreatedAt" json:"created_at"`                     // 创建时间戳
+	RevokedAt    int64    `gorm:"column:RevokedAt;default:0" json:"revoked_at,omitempty"` // 吊销时间戳，0表示未吊销
+}
+
+// TableName 指定表名
+func (ApiToken) TableName() string {
+	return "ApiTokens"
+}
@@ -22,6 +22,8 @@ type ChangePasswordRequest struct {
 // RefreshTokenResponse Token刷新响应结构
 // Token刷新成功后返回的信息
 type RefreshTokenResponse struct {
-	Token     string `json:"token"`      // 新的JWT Token
-	ExpiresIn int64  `json:"expires_in"` // 新Token过期时间（秒）
+	Token            string `json:"token"`              // 新的JWT Token
+	ExpiresIn        int64  `json:"expires_in"`         // 新Token过期时间（秒）
+	RefreshToken     string `json:"refresh_token"`      // 新的刷新Token
+	RefreshExpiresIn int64  `json:"refresh_expires_in"` // 新刷新Token过期时间（秒）
 }
@@ -0,0 +1,18 @@
+package models
+
+// ExpiredAgent 到期检查标记的已到期代理
+// 对应Web端附属数据库中的ExpiredAgent表，上级代理续期后删除对应记录
+// Disabled为true表示代理是因到期被自动禁用的，续期时自动恢复启用
+type ExpiredAgent struct {
+	ID        uint   `gorm:"column:ID;primaryKey;autoIncrement" json:"id"`                           // 记录编号
+	Software  string `gorm:"column:Software;size:100;uniqueIndex:idx_expired_agent" json:"software"` // 软件位名称
+	Username  string `gorm:"column:Username;size:100;uniqueIndex:idx_expired_agent" json:"username"` // 代理账号
+	ExpiredAt int64  `gorm:"column:ExpiredAt" json:"expired_at"`                                     // 到期时间戳
+	Disabled  bool   `gorm:"column:Disabled" json:"disabled"`                                        // 是否由到期检查自动禁用
+	MarkedAt  int64  `gorm:"column:MarkedAt" json:"marked_at"`                                       // 标记时间戳
+}
+
+// TableName 指定表名
+func (ExpiredAgent) TableName() string {
+	return "ExpiredAgent"
+}
@@ -0,0 +1,27 @@
+package models
+
+// 模拟查看审计动作
+const (
+	ImpersonationActionStart   = "start"   // 开始模拟查看
+	ImpersonationActionStop    = "stop"    // 结束模拟查看
+	ImpersonationActionRequest = "request" // 模拟查看期间访问只读接口
+	ImpersonationActionDenied  = "denied"  // 模拟查看期间尝试访问非只读接口，已拒绝
+)
+
+// ImpersonationAudit 模拟查看审计记录
+// 对应Web端附属数据库中的ImpersonationAudit表，记录上级代理以只读方式查看下级代理期间的全部操作
+type ImpersonationAudit struct {
+	ID        uint   `gorm:"column:ID;primaryKey;autoIncrement" json:"id"` // 记录编号
+	Actor     string `gorm:"column:Actor;size:100;index" json:"actor"`     // 发起模拟查看的上级代理
+	Target    string `gorm:"column:Target;size:100;index" json:"target"`   // 被查看的下级代理
+	Software  string `gorm:"column:Software;size:100" json:"software"`     // 软件位名称
+	Action    string `gorm:"column:Action;size:20" json:"action"`          // 动作：start/stop/request/denied
+	Path      string `gorm:"column:Path;size:200" json:"path"`             // 访问的接口
+	IPAddress string `gorm:"column:IPAddress;size:64" json:"ip_address"`   // 客户端IP
+	CreatedAt int64  `gorm:"column:CreatedAt;index" json:"created_at"`     // 记录时间戳
+}
+
+// TableName 指定表名
+func (ImpersonationAudit) TableName() string {
+	return "ImpersonationAudit"
+}
@@ -0,0 +1,34 @@
+package models
+
+// 余额流水类型
+const (
+	LedgerTypeRecharge = "recharge"  // 上级代理给下级代理充值（双方各一条）
+	LedgerTypeWithdraw = "withdraw"  // 上级代理从下级代理回收余额和时长
+	LedgerTypeCardCost = "card_cost" // 生成卡密扣费
+	LedgerTypeRefund   = "refund"    // 退回余额和时长（回收退回、删除下级代理退回等）
+)
+
+// BalanceLedger 余额流水
+// 对应Web端附属数据库中的BalanceLedger表，记录代理余额和库存时长的每一次变动
+// 同一次操作产生的多条流水使用相同的Reference
+type BalanceLedger struct {
+	ID            uint    `gorm:"column:ID;primaryKey;autoIncrement" json:"id"`    // 记录编号
+	Software      string  `gorm:"column:Software;size:100;index" json:"software"`  // 软件位名称
+	Username      string  `gorm:"column:Username;size:100;index" json:"username"`  // 余额变动的代理
+	Actor         string  `gorm:"column:Actor;size:100" json:"actor"`              // 执行操作的代理
+	Type          string  `gorm:"column:Type;size:20" json:"type"`                 // 流水类型
+	BalanceBefore float64 `gorm:"column:BalanceBefore" json:"balance_before"`      // 变动前余额
+	BalanceAfter  float64 `gorm:"column:BalanceAfter" json:"balance_after"`        // 变动后余额
+	BalanceChange float64 `gorm:"column:BalanceChange" json:"balance_change"`      // 余额变动（正数为增加）
+	TimeBefore    int     `gorm:"column:TimeBefore" json:"time_before"`            // 变动前库存时长（秒）
+	TimeAfter     int     `gorm:"column:TimeAfter" json:"time_after"`              // 变动后库存时长（秒）
+	TimeChange    int     `gorm:"column:TimeChange" json:"time_change"`            // 库存时长变动（秒，正数为增加）
+	Reference     string  `gorm:"column:Reference;size:40;index" json:"reference"` // 操作编号
+	Remark        string  `gorm:"column:Remark;size:400" json:"remark"`            // 说明
+	CreatedAt     int64   `gorm:"column:CreatedAt;index" json:"created_at"`        // 记录时间戳
+}
+
+// TableName 指定表名
+func (BalanceLedger) TableName() string {
+	return "BalanceLedger"
+}
@@ -0,0 +1,24 @@
+package models
+
+// LoginHistory 代理登录记录
+// 对应Web端附属数据库中的LoginHistory表，记录每一次登录尝试（成功或失败）
+type LoginHistory struct {
+	ID            uint     `gorm:"column:ID;primaryKey;autoIncrement" json:"id"`   // 记录编号
+	Username      string   `gorm:"column:Username;size:100;index" json:"username"` // 登录账号
+	Success       bool     `gorm:"column:Success" json:"success"`                  // 是否登录成功
+	Reason        string   `gorm:"column:Reason;size:200" json:"reason"`           // 失败原因
+	Method        string   `gorm:"column:Method;size:20" json:"method"`            // 登录方式：session/jwt
+	IPAddress     string   `gorm:"column:IPAddress;size:64" json:"ip_address"`     // 客户端IP
+	UserAgent     string   `gorm:"column:UserAgent;size:500" json:"user_agent"`    // 客户端User-Agent
+	Softwares     string   `gorm:"column:Softwares;type:text" json:"-"`            // 登录成功后可访问的软件位，格式 [软件位1],[软件位2]
+	SoftwareList  []string `gorm:"-" json:"softwares"`                             // 可访问的软件位(数组格式，用于JSON序列化)
+	NewIP         bool     `gorm:"column:NewIP" json:"new_ip"`                     // 是否首次从该IP登录成功
+	AfterFailures int      `gorm:"column:AfterFailures" json:"after_failures"`     // 本次成功前的连续失败次数
+	Suspicious    bool     `gorm:"column:Suspicious;index" json:"suspicious"`      // 是否为可疑登录
+	CreatedAt     int64    `gorm:"column:CreatedAt;index" json:"created_at"`       // 登录时间戳
+}
+
+// TableName 指定表名
+func (LoginHistory) TableName() string {
+	return "LoginHistory"
+}
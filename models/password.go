@@ -0,0 +1,30 @@
+package models
+
+// PasswordHistory 代理历史密码
+// 对应Web端附属数据库中的PasswordHistory表，只保存bcrypt摘要，用于禁止重复使用最近的密码
+type PasswordHistory struct {
+	ID        uint   `gorm:"column:ID;primaryKey;autoIncrement"`
+	Username  string `gorm:"column:Username;size:100;index"` // 代理账号
+	Hash      string `gorm:"column:Hash;size:64"`            // 密码的bcrypt摘要
+	CreatedAt int64  `gorm:"column:CreatedAt"`               // 设置时间戳
+}
+
+// TableName 指定表名
+func (PasswordHistory) TableName() string {
+	return "PasswordHistory"
+}
+
+// PasswordChangeRequirement 上级代理要求子代理下次登录时修改密码
+// 对应Web端附属数据库中的PasswordChangeRequirement表，按软件位和账号区分，子代理修改密码后删除
+// 不同软件位中的同名账号可能属于不同的人，要求只对设置时所在的软件位生效
+type PasswordChangeRequirement struct {
+	Software   string `gorm:"column:Software;primaryKey;size:100"` // 设置要求时所在的软件位
+	Username   string `gorm:"column:Username;primaryKey;size:100"` // 被要求的子代理账号
+	RequiredBy string `gorm:"column:RequiredBy;size:100"`          // 设置要求的上级代理账号，修改密码后各软件位不一致时为代理自身
+	CreatedAt  int64  `gorm:"column:CreatedAt"`                    // 设置时间戳
+}
+
+// TableName 指定表名
+func (PasswordChangeRequirement) TableName() string {
+	return "PasswordChangeRequirement"
+}
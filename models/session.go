@@ -14,9 +14,19 @@ func init() {
 // 只存储会话相关信息，不包含业务操作参数
 type UserSession struct {
 	// 基本信息
-	Username  string `json:"username,omitempty"`   // 用户名
-	Password  string `json:"-"`                    // 密码（不序列化到JSON）
-	IPAddress string `json:"ip_address,omitempty"` // 登录IP
+	Username            string `json:"username,omitempty"`   // 用户名
+	Password            string `json:"-"`                    // 密码（不序列化到JSON，会话持久化时不保存）
+	PasswordFingerprint string `json:"-"`                    // 登录密码指纹，会话持久化时代替明文密码保存
+	IPAddress           string `json:"ip_address,omitempty"` // 登录IP
+	UserAgent           string `json:"user_agent,omitempty"` // 登录时的User-Agent
+	LoginTime           int64  `json:"login_time,omitempty"` // 登录时间戳
+
+	// 修改密码前只能访问修改密码、登出等接口
+	MustChangePassword bool `json:"must_change_password,omitempty"` // 是否需要先修改密码
+
+	// 模拟查看：上级代理以只读方式查看下级代理时，会话中保存的是下级代理的信息
+	ImpersonatedBy        string `json:"impersonated_by,omitempty"`        // 发起模拟查看的上级代理账号，为空表示非模拟查看
+	ImpersonationSoftware string `json:"impersonation_software,omitempty"` // 模拟查看的软件位
 
 	// 会话相关字段
 	SoftwareList      []string          `json:"software_list,omitempty"`   // 该用户可控制的软件位名称列表
@@ -0,0 +1,32 @@
+package models
+
+// AgentTwoFactor 代理二次验证（TOTP）配置
+// 对应Web端附属数据库中的AgentTwoFactor表，按代理账号记录，对所有软件位生效
+type AgentTwoFactor struct {
+	Username      string `gorm:"column:Username;primaryKey;size:100"` // 代理账号
+	Secret        string `gorm:"column:Secret;size:64"`               // Base32编码的TOTP密钥
+	Enabled       bool   `gorm:"column:Enabled"`                      // 是否已完成绑定并启用
+	RecoveryCodes string `gorm:"column:RecoveryCodes;type:text"`      // 恢复码SHA-256摘要，逗号分隔，使用后移除
+	LastUsedStep  int64  `gorm:"column:LastUsedStep"`                 // 最后一次使用的时间步，防止验证码重放
+	CreatedAt     int64  `gorm:"column:CreatedAt"`                    // 创建时间戳
+	EnabledAt     int64  `gorm:"column:EnabledAt"`                    // 启用时间戳
+}
+
+// TableName 指定表名
+func (AgentTwoFactor) TableName() string {
+	return "AgentTwoFactor"
+}
+
+// TwoFactorRequirement 上级代理对子代理的强制二次验证要求
+// 对应Web端附属数据库中的TwoFactorRequirement表
+type TwoFactorRequirement struct {
+	Username   string `gorm:"column:Username;primaryKey;size:100"` // 被要求的子代理账号
+	RequiredBy string `gorm:"column:RequiredBy;size:100"`          // 设置要求的上级代理账号
+	Software   string `gorm:"column:Software;size:100"`            // 设置要求时所在的软件位
+	CreatedAt  int64  `gorm:"column:CreatedAt"`                    // 设置时间戳
+}
+
+// TableName 指定表名
+func (TwoFactorRequirement) TableName() string {
+	return "TwoFactorRequirement"
+}
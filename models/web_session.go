@@ -0,0 +1,22 @@
+package models
+
+// WebSession Web端持久化会话模型
+// 对应Web端附属数据库中的WebSessions表，由Web代理端自行创建和维护
+// Data 字段保存gob序列化后的会话数据（其中包含 *UserSession）
+type WebSession struct {
+	ID        string `gorm:"column:ID;primaryKey;size:64"`   // 会话ID（Cookie中签名加密后保存）
+	Username  string `gorm:"column:Username;size:100;index"` // 会话所属代理账号，未登录时为空
+	IPAddress string `gorm:"column:IPAddress;size:64"`       // 登录IP
+	UserAgent string `gorm:"column:UserAgent;size:400"`      // 登录时的User-Agent
+	LoginTime int64  `gorm:"column:LoginTime"`               // 登录时间戳
+	LastSeen  int64  `gorm:"column:LastSeen"`                // 最后访问时间戳
+	Data      []byte `gorm:"column:Data;type:blob"`          // 序列化后的会话数据
+	CreatedAt int64  `gorm:"column:CreatedAt"`               // 创建时间戳
+	UpdatedAt int64  `gorm:"column:UpdatedAt"`               // 最后保存时间戳
+	ExpiresAt int64  `gorm:"column:ExpiresAt;index"`         // 过期时间戳
+}
+
+// TableName 指定表名
+func (WebSession) TableName() string {
+	return "WebSessions"
+}
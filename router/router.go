@@ -8,6 +8,7 @@ import (
 	"SProtectAgentWeb/services"
 	"SProtectAgentWeb/util"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -19,10 +20,10 @@ func SetupNewRouter(dbManager *database.DatabaseManager) *gin.Engine {
 	r := gin.New()
 
 	// 设置全局中间件
-	r.Use(gin.Logger())                        // 日志中间件
-	r.Use(gin.Recovery())                      // 恢复中间件
-	r.Use(middleware.CORSMiddleware())         // 跨域中间件
-	r.Use(middleware.SetupSessionMiddleware()) // Session中间件（新增）
+	r.Use(gin.Logger())                                                        // 日志中间件
+	r.Use(gin.Recovery())                                                      // 恢复中间件
+	r.Use(middleware.CORSWithConfigFunc(middleware.GetConfiguredCORSConfig())) // 跨域中间件（配置文件[跨域设置]）
+	r.Use(middleware.SetupSessionMiddleware(dbManager))                        // Session中间件（持久化）
 
 	// 创建服务实例
 	authService := services.NewAuthService(dbManager)
@@ -30,16 +31,37 @@ func SetupNewRouter(dbManager *database.DatabaseManager) *gin.Engine {
 	softwareService := services.NewSoftwareService(dbManager)
 	cardService := services.NewCardService(dbManager)
 	cardTypeService := services.NewCardTypeService(dbManager, softwareService)
+	sessionService := services.NewSessionService(dbManager)
+	twoFactorService := services.NewTwoFactorService(dbManager)
+	loginLimiter := services.NewLoginLimiter()
+	captchaService := services.NewCaptchaService()
+	apiTokenService := services.NewApiTokenService(dbManager)
+	jwtService := services.NewJWTService(dbManager)
+	loginHistoryService := services.NewLoginHistoryService(dbManager)
+	passwordPolicyService := services.NewPasswordPolicyService(dbManager)
+	impersonationService := services.NewImpersonationService(dbManager)
+	agentInfoService := services.NewAgentInfoService(dbManager)
+	ledgerService := services.NewLedgerService(dbManager)
+	subAgentService := services.NewSubAgentService(dbManager, ledgerService)
+	agentTreeService := services.NewAgentTreeService(dbManager)
+	expiryService := services.NewExpiryService(dbManager)
+	agentCreateService := services.NewAgentCreateService(dbManager, passwordPolicyService, ledgerService)
+	cardGenerateService := services.NewCardGenerateService(dbManager, ledgerService)
+	expiryService.StartSweeper(time.Duration(config.GetExpiryConfig().SweepInterval) * time.Second)
 
 	// 创建处理器实例
-	authHandler := handler.NewAuthHandler(authService)
-	agentHandler := handler.NewAgentHandler(agentService)
+	authHandler := handler.NewAuthHandler(authService, sessionService, twoFactorService, loginLimiter, jwtService, loginHistoryService, passwordPolicyService, captchaService)
+	twoFactorHandler := handler.NewTwoFactorHandler(twoFactorService, loginHistoryService)
+	apiTokenHandler := handler.NewApiTokenHandler(apiTokenService)
+	loginHistoryHandler := handler.NewLoginHistoryHandler(loginHistoryService)
+	impersonationHandler := handler.NewImpersonationHandler(impersonationService)
+	agentHandler := handler.NewAgentHandler(agentService, passwordPolicyService, agentInfoService, subAgentService, agentTreeService, sessionService, ledgerService, expiryService, agentCreateService)
 	softwareHandler := handler.NewSoftwareHandler(softwareService)
-	cardHandler := handler.NewCardHandler(cardService, cardTypeService)
+	cardHandler := handler.NewCardHandler(cardService, cardTypeService, cardGenerateService)
 	cardTypeHandler := handler.NewCardTypeHandler(cardTypeService)
 
-	// 设置API路由 - RPC风格
-	api := r.Group("/api")
+	// 设置API路由 - RPC风格，所有修改状态的请求都需要校验CSRF令牌
+	api := r.Group("/api", middleware.CSRFProtection())
 
 	{
 		// 认证相关路由组
@@ -47,12 +69,39 @@ func SetupNewRouter(dbManager *database.DatabaseManager) *gin.Engine {
 		{
 			// 公开路由（无需认证）
 			authGroup.POST("/login", authHandler.Login)
+			authGroup.POST("/captcha", authHandler.GetCaptcha)
+			authGroup.POST("/refreshToken", authHandler.RefreshToken)
 
-			// 需要认证的路由
-			authGroup.POST("/changePassword", middleware.RequireSessionAuth(), authHandler.ChangePassword)
+			// 登录第二步（二次验证），使用密码验证通过后的临时会话
+			authGroup.POST("/verifyTwoFactor", authHandler.VerifyTwoFactor)
+			authGroup.POST("/beginTwoFactor", twoFactorHandler.BeginTwoFactor)
+			authGroup.POST("/confirmTwoFactor", twoFactorHandler.ConfirmTwoFactor)
+
+			// 需要认证的路由（API令牌可用）
 			authGroup.POST("/getUserInfo", middleware.RequireSessionAuth(), authHandler.GetUserInfo)
-			authGroup.POST("/refreshUserInfo", middleware.RequireSessionAuth(), authHandler.RefreshUserInfo)
-			authGroup.POST("/logout", middleware.RequireSessionAuth(), authHandler.Logout)
+			authGroup.POST("/loginHistory", middleware.RequireSessionAuth(), loginHistoryHandler.GetLoginHistory)
+
+			// 账号安全相关路由，必须通过登录会话访问，不接受API令牌
+			sessionOnly := authGroup.Group("", middleware.RequireSessionAuth(), middleware.DenyAPIToken())
+			{
+				sessionOnly.POST("/changePassword", authHandler.ChangePassword)
+				sessionOnly.POST("/refreshUserInfo", authHandler.RefreshUserInfo)
+				sessionOnly.POST("/logout", authHandler.Logout)
+				sessionOnly.POST("/listSessions", authHandler.ListSessions)
+				sessionOnly.POST("/revokeSession", authHandler.RevokeSession)
+				sessionOnly.POST("/logoutAll", authHandler.LogoutAll)
+				sessionOnly.POST("/revokeSubAgentSessions", authHandler.RevokeSubAgentSessions)
+				sessionOnly.POST("/getTwoFactorStatus", twoFactorHandler.GetTwoFactorStatus)
+				sessionOnly.POST("/disableTwoFactor", twoFactorHandler.DisableTwoFactor)
+				sessionOnly.POST("/regenerateRecoveryCodes", twoFactorHandler.RegenerateRecoveryCodes)
+				sessionOnly.POST("/setSubAgentTwoFactor", twoFactorHandler.SetSubAgentTwoFactor)
+				sessionOnly.POST("/createApiToken", apiTokenHandler.CreateApiToken)
+				sessionOnly.POST("/listApiTokens", apiTokenHandler.ListApiTokens)
+				sessionOnly.POST("/revokeApiToken", apiTokenHandler.RevokeApiToken)
+				sessionOnly.POST("/startImpersonation", impersonationHandler.StartImpersonation)
+				sessionOnly.POST("/stopImpersonation", impersonationHandler.StopImpersonation)
+				sessionOnly.POST("/impersonationAudit", impersonationHandler.GetImpersonationAudit)
+			}
 		}
 
 		// 代理相关路由组
@@ -60,12 +109,23 @@ func SetupNewRouter(dbManager *database.DatabaseManager) *gin.Engine {
 		{
 			agentGroup.POST("/getUserInfo", agentHandler.GetAgentInfo)
 			agentGroup.POST("/getSubAgentList", agentHandler.GetSubAgentList)
+			agentGroup.POST("/getAgentTree", agentHandler.GetAgentTree)
+			agentGroup.POST("/getExpiringAgents", agentHandler.GetExpiringAgents)
 			agentGroup.POST("/enableAgent", agentHandler.EnableAgent)
 			agentGroup.POST("/disableAgent", agentHandler.DisableAgent)
 			agentGroup.POST("/updateAgentRemark", agentHandler.UpdateAgentRemark)
 			agentGroup.POST("/createSubAgent", agentHandler.CreateSubAgent)
+			agentGroup.POST("/importSubAgents", agentHandler.ImportSubAgents)
+			agentGroup.POST("/requirePasswordChange", agentHandler.RequirePasswordChange)
+			agentGroup.POST("/resetSubAgentPassword", agentHandler.ResetSubAgentPassword)
+			agentGroup.POST("/updatePermission", agentHandler.UpdatePermission)
+			agentGroup.POST("/moveSubAgent", agentHandler.MoveSubAgent)
+			agentGroup.POST("/updateParities", agentHandler.UpdateParities)
+			agentGroup.POST("/setSubAgentExpiry", agentHandler.SetSubAgentExpiry)
 			agentGroup.POST("/deleteSubAgent", agentHandler.DeleteSubAgent)
 			agentGroup.POST("/addMoney", agentHandler.AddMoney)
+			agentGroup.POST("/withdrawMoney", agentHandler.WithdrawMoney)
+			agentGroup.POST("/getLedger", agentHandler.GetLedger)
 			agentGroup.POST("/getAgentCardType", agentHandler.GetAgentCardType)
 			agentGroup.POST("/setAgentCardType", agentHandler.SetAgentCardType)
 		}
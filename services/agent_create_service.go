@@ -0,0 +1,516 @@
+package services
+
+import (
+	"SProtectAgentWeb/database"
+	"SProtectAgentWeb/models"
+	"SProtectAgentWeb/types"
+	"SProtectAgentWeb/util"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// bulkAgentMaxRows 单次批量创建的最大行数
+const bulkAgentMaxRows = 500
+
+// bulkAgentColumns 表头名称与字段的对应关系，同时支持中文和英文表头
+var bulkAgentColumns = map[string]string{
+	"username":    "username",
+	"账号":          "username",
+	"password":    "password",
+	"密码":          "password",
+	"balance":     "balance",
+	"余额":          "balance",
+	"stock":       "stock",
+	"库存时长":        "stock",
+	"expiry":      "expiry",
+	"到期时间":        "expiry",
+	"parities":    "parities",
+	"利率":          "parities",
+	"返利利率":        "parities",
+	"remarks":     "remarks",
+	"备注":          "remarks",
+	"card_types":  "card_types",
+	"卡类型":         "card_types",
+	"制卡权限":        "card_types",
+	"stock_hours": "stock",
+}
+
+// bulkExpiryLayouts 到期时间支持的日期格式
+var bulkExpiryLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"2006/01/02 15:04:05",
+	"2006/01/02 15:04",
+	"2006/01/02",
+}
+
+// AgentCreateService 创建子代理服务
+// 单个创建和批量创建共用同一套校验、换算和创建逻辑
+type AgentCreateService struct {
+	dbManager      *database.DatabaseManager
+	passwordPolicy *PasswordPolicyService
+	ledger         *LedgerService
+}
+
+// NewAgentCreateService 创建子代理服务实例
+func NewAgentCreateService(dbManager *database.DatabaseManager, passwordPolicy *PasswordPolicyService, ledger *LedgerService) *AgentCreateService {
+	return &AgentCreateService{
+		dbManager:      dbManager,
+		passwordPolicy: passwordPolicy,
+		ledger:         ledger,
+	}
+}
+
+// CreateSubAgent 创建单个子代理
+// 当前代理支付余额N，子代理到账N*利率；库存时长等额扣除
+// software: 软件位名称
+// parentUser: 当前代理账号
+// spec: 子代理参数，校验通过后AccountBalance为子代理实际到账的余额
+// 返回: 可能的错误，校验不通过时返回全部校验错误
+func (s *AgentCreateService) CreateSubAgent(software, parentUser string, spec *types.SubAgentSpec) error {
+	db, err := s.dbManager.GetSoftwareDB(software)
+	if err != nil {
+		return err
+	}
+
+	parent, err := findParentAgent(db, parentUser)
+	if err != nil {
+		return err
+	}
+
+	if errs := s.validateSubAgent(parent, spec, time.Now().Unix()); len(errs) > 0 {
+		return errors.New(strings.Join(errs, "；"))
+	}
+
+	var count int64
+	if err := db.Model(&models.Agent{}).Where("User = ?", spec.Username).Count(&count).Error; err != nil {
+		return fmt.Errorf("查询代理失败: %v", err)
+	}
+	if count > 0 {
+		return fmt.Errorf("账号已存在")
+	}
+
+	_, err = s.createSubAgents(software, parent, []*types.SubAgentSpec{spec}, "创建下级代理 "+spec.Username)
+	return err
+}
+
+// ImportSubAgents 按表格内容批量创建子代理
+// 第一行为表头，每一行按单个创建子代理的规则校验；所有有效行在同一事务中创建，无效行只返回错误不创建
+// software: 软件位名称
+// parentUser: 当前代理账号
+// records: 表格内容（含表头）
+// dryRun: 是否只校验不创建
+// 返回: 批量创建结果和可能的错误
+func (s *AgentCreateService) ImportSubAgents(software, parentUser string, records [][]string, dryRun bool) (*types.BulkAgentResult, error) {
+	if len(records) < 2 {
+		return nil, fmt.Errorf("表格中没有数据")
+	}
+	if len(records)-1 > bulkAgentMaxRows {
+		return nil, fmt.Errorf("单次最多创建%d个代理", bulkAgentMaxRows)
+	}
+
+	columns, err := parseBulkHeader(records[0])
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := s.dbManager.GetSoftwareDB(software)
+	if err != nil {
+		return nil, err
+	}
+
+	parent, err := findParentAgent(db, parentUser)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &types.BulkAgentResult{
+		DryRun:      dryRun,
+		Total:       len(records) - 1,
+		Balance:     parent.AccountBalance,
+		AccountTime: parent.AccountTime,
+		Rows:        make([]types.BulkAgentRow, 0, len(records)-1),
+	}
+
+	now := time.Now().Unix()
+	seen := make(map[string]int, len(records)-1)
+	for i, record := range records[1:] {
+		row := parseBulkRow(columns, record)
+		row.Line = i + 2
+		if len(row.Errors) == 0 {
+			row.Errors = s.validateSubAgent(parent, &row.SubAgentSpec, now)
+		}
+
+		if row.Username != "" {
+			if line, ok := seen[row.Username]; ok {
+				row.Errors = append(row.Errors, fmt.Sprintf("账号与第%d行重复", line))
+			} else {
+				seen[row.Username] = row.Line
+			}
+		}
+		result.Rows = append(result.Rows, row)
+	}
+
+	if err := markExistingUsernames(db, result.Rows); err != nil {
+		return nil, err
+	}
+
+	specs := make([]*types.SubAgentSpec, 0, len(result.Rows))
+	var cost float64
+	for i := range result.Rows {
+		row := &result.Rows[i]
+		if len(row.Errors) > 0 {
+			continue
+		}
+		specs = append(specs, &row.SubAgentSpec)
+		cost += row.Balance
+		result.TotalTime += row.TimeStock
+	}
+	result.Valid = len(specs)
+	result.TotalCost = math.Round(cost*100) / 100
+	result.Sufficient = result.TotalCost <= parent.AccountBalance && result.TotalTime <= parent.AccountTime
+
+	if dryRun || result.Valid == 0 {
+		return result, nil
+	}
+	if !result.Sufficient {
+		return nil, fmt.Errorf("余额或库存时长不足：需要余额%.2f、库存时长%d秒", result.TotalCost, result.TotalTime)
+	}
+
+	updated, err := s.createSubAgents(software, parent, specs, fmt.Sprintf("批量创建子代理 %d 个", len(specs)))
+	if err != nil {
+		return nil, err
+	}
+
+	result.Balance = updated.AccountBalance
+	result.AccountTime = updated.AccountTime
+	result.Created = result.Valid
+	return result, nil
+}
+
+// validateSubAgent 按创建子代理的规则校验参数，并计算子代理到账的余额
+// 单个创建和批量创建共用，返回全部校验错误，为空表示校验通过
+func (s *AgentCreateService) validateSubAgent(parent *models.Agent, spec *types.SubAgentSpec, now int64) []string {
+	var errs []string
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+
+	spec.Username = strings.TrimSpace(spec.Username)
+	switch {
+	case spec.Username == "":
+		fail("账号不能为空")
+	case utf8.RuneCountInString(spec.Username) > 100:
+		fail("账号不能超过100个字符")
+	case strings.ContainsAny(spec.Username, "[],"):
+		fail("账号不能包含[ ] ,等字符")
+	}
+
+	if spec.Password == "" {
+		fail("密码不能为空")
+	} else if spec.Username != "" {
+		if err := s.passwordPolicy.Validate(spec.Username, spec.Password); err != nil {
+			fail("%s", err.Error())
+		}
+	}
+
+	if spec.Balance < 0 || math.IsInf(spec.Balance, 0) || math.IsNaN(spec.Balance) {
+		fail("余额不能为负数")
+	} else {
+		spec.Balance = math.Round(spec.Balance*100) / 100
+	}
+
+	if spec.TimeStock < 0 || spec.TimeStock > math.MaxInt32 {
+		fail("库存时长超出范围")
+	}
+
+	if math.IsNaN(spec.Parities) || spec.Parities < 100 || math.IsInf(spec.Parities, 0) {
+		fail("返利利率不能小于100")
+	} else {
+		spec.AccountBalance = math.Round(spec.Balance*spec.Parities) / 100
+	}
+
+	switch {
+	case spec.Expiration <= now:
+		fail("到期时间必须晚于当前时间")
+	case parent.Duration_ > 0 && spec.Expiration > parent.Duration_:
+		fail("到期时间不能晚于您自己的到期时间")
+	}
+
+	if utf8.RuneCountInString(spec.Remarks) > 400 {
+		fail("备注不能超过400个字符")
+	}
+
+	for _, name := range spec.CardTypes {
+		if !parent.HasCreateCardType(name) {
+			fail("无权分配卡类型: %s", name)
+		}
+	}
+
+	return errs
+}
+
+// createSubAgents 在同一事务中扣除当前代理的余额和库存时长、创建子代理并记录余额流水
+// specs必须已经通过validateSubAgent校验
+// 返回: 扣除后当前代理的余额和库存时长，以及可能的错误
+func (s *AgentCreateService) createSubAgents(software string, parent *models.Agent, specs []*types.SubAgentSpec, remark string) (*models.Agent, error) {
+	var cost float64
+	var stock int
+	agents := make([]models.Agent, 0, len(specs))
+	usernames := make([]string, 0, len(specs))
+	for _, spec := range specs {
+		cost += spec.Balance
+		stock += spec.TimeStock
+		usernames = append(usernames, spec.Username)
+		agents = append(agents, models.Agent{
+			User:             spec.Username,
+			Password:         spec.Password,
+			AccountBalance:   spec.AccountBalance,
+			AccountTime:      spec.TimeStock,
+			Duration:         "0",
+			Authority:        "0",
+			CardTypeAuthName: util.BuildBracketList(spec.CardTypes),
+			CardsEnable:      true,
+			Remarks:          spec.Remarks,
+			FNode:            childFNode(parent, spec.Username),
+			Stat:             0,
+			Deltm:            0,
+			Duration_:        spec.Expiration,
+			Parities:         spec.Parities,
+			TatalParities:    models.CalcTatalParities(parent.TatalParities, spec.Parities),
+		})
+	}
+	cost = math.Round(cost*100) / 100
+
+	updated := &models.Agent{User: parent.User}
+	_, err := s.ledger.Apply(software, parent.User, remark, func(tx *gorm.DB) ([]LedgerChange, error) {
+		// 校验之后可能有同名代理被创建，事务内再确认一次
+		var count int64
+		if err := tx.Model(&models.Agent{}).Where("User IN ?", usernames).Count(&count).Error; err != nil {
+			return nil, fmt.Errorf("查询代理失败: %v", err)
+		}
+		if count > 0 {
+			return nil, fmt.Errorf("部分账号已被占用，请重新校验后再提交")
+		}
+
+		before, err := readBalances(tx, []string{parent.User})
+		if err != nil {
+			return nil, err
+		}
+
+		// 条件更新保证并发操作时不会扣成负数
+		update := tx.Model(&models.Agent{}).
+			Where("User = ? AND AccountBalance >= ? AND AccountTime >= ?", parent.User, cost, stock).
+			Updates(map[string]interface{}{
+				"AccountBalance": gorm.Expr("AccountBalance - ?", cost),
+				"AccountTime":    gorm.Expr("AccountTime - ?", stock),
+			})
+		if update.Error != nil {
+			return nil, fmt.Errorf("扣除余额失败: %v", update.Error)
+		}
+		if update.RowsAffected == 0 {
+			return nil, fmt.Errorf("余额或库存时长不足")
+		}
+
+		if err := tx.Create(&agents).Error; err != nil {
+			return nil, fmt.Errorf("创建代理失败: %v", err)
+		}
+
+		after, err := readBalances(tx, []string{parent.User})
+		if err != nil {
+			return nil, err
+		}
+		updated.AccountBalance = after[parent.User].balance
+		updated.AccountTime = after[parent.User].time
+
+		changes := make([]LedgerChange, 0, len(agents)+1)
+		changes = append(changes, newLedgerChange(parent.User, models.LedgerTypeRecharge, before[parent.User], after[parent.User]))
+		for i := range agents {
+			changes = append(changes, newLedgerChange(agents[i].User, models.LedgerTypeRecharge, ledgerBalance{},
+				ledgerBalance{balance: agents[i].AccountBalance, time: agents[i].AccountTime}))
+		}
+		return changes, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// findParentAgent 查询当前代理
+func findParentAgent(db *gorm.DB, username string) (*models.Agent, error) {
+	var parent models.Agent
+	err := db.Where("User = ?", username).First(&parent).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("代理不存在")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询代理失败: %v", err)
+	}
+	return &parent, nil
+}
+
+// parseBulkRow 将表格中的一行解析为子代理参数
+// 只检查单元格格式，格式正确的行再按validateSubAgent的规则校验
+func parseBulkRow(columns map[string]int, record []string) types.BulkAgentRow {
+	cell := func(name string) string {
+		index, ok := columns[name]
+		if !ok || index >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[index])
+	}
+	row := types.BulkAgentRow{
+		SubAgentSpec: types.SubAgentSpec{
+			Username: cell("username"),
+			Password: cell("password"),
+			Remarks:  cell("remarks"),
+			Parities: 100,
+		},
+	}
+	fail := func(format string, args ...interface{}) {
+		row.Errors = append(row.Errors, fmt.Sprintf(format, args...))
+	}
+
+	if value := cell("balance"); value != "" {
+		balance, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsInf(balance, 0) || math.IsNaN(balance) {
+			fail("余额格式错误")
+		} else {
+			row.Balance = balance
+		}
+	}
+
+	if value := cell("stock"); value != "" {
+		hours, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.Abs(hours) > math.MaxInt32/3600 || math.IsNaN(hours) {
+			fail("库存时长格式错误")
+		} else {
+			row.TimeStock = int(math.Round(hours * 3600))
+		}
+	}
+
+	if value := cell("parities"); value != "" {
+		parities, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsInf(parities, 0) || math.IsNaN(parities) {
+			fail("返利利率格式错误")
+		} else {
+			row.Parities = parities
+		}
+	}
+
+	if value := cell("expiry"); value == "" {
+		fail("到期时间不能为空")
+	} else if expiration, err := parseBulkExpiry(value); err != nil {
+		fail("%s", err.Error())
+	} else {
+		row.Expiration = expiration
+	}
+
+	if value := cell("card_types"); value != "" {
+		names := strings.FieldsFunc(value, func(r rune) bool {
+			return r == '|' || r == ';' || r == '；'
+		})
+		for _, name := range names {
+			if name = strings.TrimSpace(name); name != "" {
+				row.CardTypes = append(row.CardTypes, name)
+			}
+		}
+	}
+
+	return row
+}
+
+// parseBulkHeader 解析表头，返回各字段所在的列
+func parseBulkHeader(header []string) (map[string]int, error) {
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		// 表头可以带单位说明，如"库存时长(小时)"
+		if index := strings.IndexAny(name, "(（"); index > 0 {
+			name = strings.TrimSpace(name[:index])
+		}
+		if field, ok := bulkAgentColumns[name]; ok {
+			if _, exists := columns[field]; !exists {
+				columns[field] = i
+			}
+		}
+	}
+
+	for _, required := range []string{"username", "password", "expiry"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("表头缺少必需的列: %s", required)
+		}
+	}
+	return columns, nil
+}
+
+// parseBulkExpiry 解析到期时间
+// 支持日期文本、Unix时间戳（秒）以及XLSX中以序列号保存的日期
+func parseBulkExpiry(value string) (int64, error) {
+	for _, layout := range bulkExpiryLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t.Unix(), nil
+		}
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number <= 0 {
+		return 0, fmt.Errorf("到期时间格式错误，请使用YYYY-MM-DD或YYYY-MM-DD HH:MM:SS")
+	}
+	if number >= 1e9 {
+		return int64(number), nil
+	}
+	if number < 100000 {
+		// Excel日期序列号，从1899-12-30开始计算天数，小数部分为时间
+		base := time.Date(1899, 12, 30, 0, 0, 0, 0, time.Local)
+		return base.Add(time.Duration(math.Round(number*86400)) * time.Second).Unix(), nil
+	}
+	return 0, fmt.Errorf("到期时间格式错误，请使用YYYY-MM-DD或YYYY-MM-DD HH:MM:SS")
+}
+
+// markExistingUsernames 为已被占用的账号添加校验错误
+func markExistingUsernames(db *gorm.DB, rows []types.BulkAgentRow) error {
+	usernames := make([]string, 0, len(rows))
+	for i := range rows {
+		if rows[i].Username != "" {
+			usernames = append(usernames, rows[i].Username)
+		}
+	}
+	if len(usernames) == 0 {
+		return nil
+	}
+
+	var existing []string
+	if err := db.Model(&models.Agent{}).Where("User IN ?", usernames).Pluck("User", &existing).Error; err != nil {
+		return fmt.Errorf("查询代理失败: %v", err)
+	}
+
+	taken := make(map[string]bool, len(existing))
+	for _, username := range existing {
+		taken[username] = true
+	}
+	for i := range rows {
+		if taken[rows[i].Username] {
+			rows[i].Errors = append(rows[i].Errors, "账号已存在")
+		}
+	}
+	return nil
+}
+
+// childFNode 生成子代理的代理链，上级代理的代理链为空时以上级代理自身为起点
+func childFNode(parent *models.Agent, username string) string {
+	chain := util.ParseAgentFNode(parent.FNode)
+	if len(chain) == 0 {
+		chain = []string{parent.User}
+	}
+	return util.BuildBracketList(append(chain, username))
+}
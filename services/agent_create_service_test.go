@@ -0,0 +1,44 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBulkExpiry(t *testing.T) {
+	local := func(year int, month time.Month, day, hour int) int64 {
+		return time.Date(year, month, day, hour, 0, 0, 0, time.Local).Unix()
+	}
+
+	// 小于100000的数字按XLSX日期序列号解析，不小于1e9的按Unix时间戳解析，两者之间的数字无法区分，按错误处理
+	tests := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{value: "47120", want: local(2029, 1, 2, 0)},
+		{value: "47120.5", want: local(2029, 1, 2, 12)},
+		{value: "99999", want: local(2173, 10, 13, 0)},
+		{value: "1000000000", want: 1000000000},
+		{value: "100000", wantErr: true},
+		{value: "999999999", wantErr: true},
+		{value: "0", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseBulkExpiry(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseBulkExpiry(%q) = %d, want error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseBulkExpiry(%q) error = %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseBulkExpiry(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"SProtectAgentWeb/database"
+	"SProtectAgentWeb/models"
+	"SProtectAgentWeb/types"
+	"SProtectAgentWeb/util"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AgentInfoService 代理信息服务
+// 读取代理在软件位中的最新信息，并统计其制卡和下级代理数据
+type AgentInfoService struct {
+	dbManager *database.DatabaseManager
+}
+
+// NewAgentInfoService 创建代理信息服务实例
+func NewAgentInfoService(dbManager *database.DatabaseManager) *AgentInfoService {
+	return &AgentInfoService{
+		dbManager: dbManager,
+	}
+}
+
+// GetAgentInfo 获取代理在软件位中的详细信息、权限和统计数据
+// software: 软件位名称
+// username: 代理账号
+// 返回: 代理信息和可能的错误
+func (s *AgentInfoService) GetAgentInfo(software, username string) (*types.GetAgentInfoResponse, error) {
+	db, err := s.dbManager.GetSoftwareDB(software)
+	if err != nil {
+		return nil, err
+	}
+
+	var agent models.Agent
+	err = db.Where("User = ?", username).First(&agent).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("代理不存在")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询代理失败: %v", err)
+	}
+	agent.CardTypeAuthNameArray = util.ParseBracketList(agent.CardTypeAuthName)
+
+	stats, err := agentCardStats(db, username)
+	if err != nil {
+		return nil, err
+	}
+
+	subAgents, err := findSubtreeAgents(db, username)
+	if err != nil {
+		return nil, err
+	}
+	stats.SubAgents = int64(len(subAgents))
+
+	info := buildSoftwareAgentInfo(software, username, &agent).AgentInfo
+	info.Status = agentStatus(&agent)
+
+	return &types.GetAgentInfoResponse{
+		Agent:       &agent,
+		Permissions: info,
+		Statistics:  stats,
+	}, nil
+}
+
+// agentCardStats 统计代理制作的卡密
+// 已使用：已激活的卡密（含已过期）；激活中：已激活且未过期；已过期：已激活且已到期
+func agentCardStats(db *gorm.DB, username string) (*types.AgentStats, error) {
+	now := time.Now().Unix()
+
+	var stats types.AgentStats
+	err := db.Model(&models.CardInfo{}).
+		Select(`COUNT(*) AS total_cards,
+			COALESCE(SUM(CASE WHEN ActivateTime_ > 0 AND (ExpiredTime__ = 0 OR ExpiredTime__ > ?) THEN 1 ELSE 0 END), 0) AS active_cards,
+			COALESCE(SUM(CASE WHEN ActivateTime_ > 0 THEN 1 ELSE 0 END), 0) AS used_cards,
+			COALESCE(SUM(CASE WHEN ActivateTime_ > 0 AND ExpiredTime__ > 0 AND ExpiredTime__ <= ? THEN 1 ELSE 0 END), 0) AS expired_cards`, now, now).
+		Where("Whom = ? AND delstate = 0", username).
+		Scan(&stats).Error
+	if err != nil {
+		return nil, fmt.Errorf("统计卡密失败: %v", err)
+	}
+
+	return &stats, nil
+}
+
+// agentStatus 代理状态的显示文本
+func agentStatus(agent *models.Agent) string {
+	switch {
+	case agent.Deltm != 0:
+		return "已删除"
+	case agent.Stat != 0:
+		return "禁用"
+	case agent.IsExpired():
+		return "已过期"
+	default:
+		return "启用"
+	}
+}
@@ -0,0 +1,51 @@
+package services
+
+import (
+	"SProtectAgentWeb/database"
+	"SProtectAgentWeb/models"
+	"SProtectAgentWeb/util"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// loadSessionAgents 从各软件位数据库读取代理信息，构造令牌认证和刷新会话使用的用户会话
+// 代理不存在、被禁用、删除或过期的软件位会被跳过
+// dbManager: 数据库管理器
+// username: 代理账号
+// softwares: 需要加载的软件位
+// accept: 额外的校验条件，返回false的软件位同样被跳过，可为nil
+// 返回: 用户会话（没有可用软件位时SoftwareList为空）和可能的错误
+func loadSessionAgents(dbManager *database.DatabaseManager, username string, softwares []string, accept func(agent *models.Agent) bool) (*models.UserSession, error) {
+	userSession := &models.UserSession{
+		Username:          username,
+		SoftwareAgentInfo: make(map[string]*models.Agent),
+	}
+
+	for _, software := range softwares {
+		db, err := dbManager.GetSoftwareDB(software)
+		if err != nil {
+			return nil, err
+		}
+
+		var agent models.Agent
+		err = db.Where("User = ?", username).First(&agent).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("查询代理失败: %v", err)
+		}
+
+		if !agent.IsValid() || (accept != nil && !accept(&agent)) {
+			continue
+		}
+		agent.CardTypeAuthNameArray = util.ParseBracketList(agent.CardTypeAuthName)
+
+		userSession.SoftwareList = append(userSession.SoftwareList, software)
+		userSession.SoftwareAgentInfo[software] = &agent
+	}
+
+	return userSession, nil
+}
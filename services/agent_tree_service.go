@@ -0,0 +1,186 @@
+package services
+
+import (
+	"SProtectAgentWeb/database"
+	"SProtectAgentWeb/models"
+	"SProtectAgentWeb/types"
+	"SProtectAgentWeb/util"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// cardCountBatch 按代理统计卡密时每次查询的账号数，避免超出SQLite参数数量限制
+const cardCountBatch = 500
+
+// AgentTreeService 代理树服务
+// 根据FNode代理链构建上级代理的下级代理树，并汇总每个分支的余额、时长和卡密数
+type AgentTreeService struct {
+	dbManager *database.DatabaseManager
+}
+
+// NewAgentTreeService 创建代理树服务实例
+func NewAgentTreeService(dbManager *database.DatabaseManager) *AgentTreeService {
+	return &AgentTreeService{
+		dbManager: dbManager,
+	}
+}
+
+// agentCardCount 单个代理的卡密数量
+type agentCardCount struct {
+	Whom        string
+	TotalCards  int64
+	ActiveCards int64
+}
+
+// GetAgentTree 获取代理树
+// 汇总数据始终覆盖完整分支，超过展开层数的节点不返回下级代理，由前端以该节点为根再次请求
+// software: 软件位名称
+// username: 当前代理账号
+// root: 树根代理账号，为空表示当前代理；非当前代理时必须在其下级链中
+// depth: 展开层数，树根的直接下级为第1层
+// 返回: 树根节点和可能的错误
+func (s *AgentTreeService) GetAgentTree(software, username, root string, depth int) (*types.AgentTreeNode, error) {
+	db, err := s.dbManager.GetSoftwareDB(software)
+	if err != nil {
+		return nil, err
+	}
+
+	var rootAgent *models.Agent
+	if root == "" || root == username {
+		var agent models.Agent
+		err = db.Where("User = ?", username).First(&agent).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("代理不存在")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("查询代理失败: %v", err)
+		}
+		rootAgent = &agent
+	} else {
+		rootAgent, err = findOwnedSubAgent(db, username, root)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	agents, err := findSubtreeAgents(db, rootAgent.User)
+	if err != nil {
+		return nil, err
+	}
+
+	usernames := make([]string, 0, len(agents)+1)
+	usernames = append(usernames, rootAgent.User)
+	for i := range agents {
+		usernames = append(usernames, agents[i].User)
+	}
+	counts, err := agentCardCounts(db, usernames)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[string]*types.AgentTreeNode, len(agents)+1)
+	rootNode := newAgentTreeNode(rootAgent, counts)
+	nodes[rootAgent.User] = rootNode
+	for i := range agents {
+		nodes[agents[i].User] = newAgentTreeNode(&agents[i], counts)
+	}
+
+	// 挂到代理链中最近的、位于本树内的上级代理下，代理链中间缺失的代理不会导致分支丢失
+	for i := range agents {
+		node := nodes[agents[i].User]
+		chain := util.GetAgentChain(agents[i].FNode)
+		for j := len(chain) - 1; j >= 0; j-- {
+			if parent, ok := nodes[chain[j]]; ok && parent != node {
+				node.Parent = parent.Username
+				parent.Children = append(parent.Children, node)
+				break
+			}
+		}
+	}
+
+	finishAgentTree(rootNode, 0, depth)
+	return rootNode, nil
+}
+
+// newAgentTreeNode 根据代理信息创建树节点
+func newAgentTreeNode(agent *models.Agent, counts map[string]agentCardCount) *types.AgentTreeNode {
+	count := counts[agent.User]
+	return &types.AgentTreeNode{
+		Username:    agent.User,
+		Parent:      util.GetAgentParent(agent.FNode),
+		Balance:     agent.AccountBalance,
+		TimeStock:   agent.AccountTime,
+		Status:      agentStatus(agent),
+		Expiration:  agent.Duration_,
+		Remark:      agent.Remarks,
+		TotalCards:  count.TotalCards,
+		ActiveCards: count.ActiveCards,
+		Children:    []*types.AgentTreeNode{},
+	}
+}
+
+// finishAgentTree 计算节点层级和分支汇总，并裁剪超过展开层数的下级代理
+func finishAgentTree(node *types.AgentTreeNode, level, depth int) *types.AgentTreeTotal {
+	node.Depth = level
+	sort.Slice(node.Children, func(i, j int) bool {
+		return node.Children[i].Username < node.Children[j].Username
+	})
+
+	total := &types.AgentTreeTotal{
+		Agents:      1,
+		Balance:     node.Balance,
+		TimeStock:   node.TimeStock,
+		TotalCards:  node.TotalCards,
+		ActiveCards: node.ActiveCards,
+	}
+	for _, child := range node.Children {
+		sub := finishAgentTree(child, level+1, depth)
+		total.Agents += sub.Agents
+		total.Balance += sub.Balance
+		total.TimeStock += sub.TimeStock
+		total.TotalCards += sub.TotalCards
+		total.ActiveCards += sub.ActiveCards
+	}
+
+	node.Subtotal = total
+	node.ChildCount = len(node.Children)
+	node.HasChildren = node.ChildCount > 0
+	node.Loaded = level < depth
+	if !node.Loaded {
+		node.Children = []*types.AgentTreeNode{}
+	}
+	return total
+}
+
+// agentCardCounts 按代理统计卡密总数和激活中的卡密数
+func agentCardCounts(db *gorm.DB, usernames []string) (map[string]agentCardCount, error) {
+	now := time.Now().Unix()
+	counts := make(map[string]agentCardCount, len(usernames))
+
+	for start := 0; start < len(usernames); start += cardCountBatch {
+		end := start + cardCountBatch
+		if end > len(usernames) {
+			end = len(usernames)
+		}
+
+		var rows []agentCardCount
+		err := db.Model(&models.CardInfo{}).
+			Select(`Whom AS whom, COUNT(*) AS total_cards,
+				COALESCE(SUM(CASE WHEN ActivateTime_ > 0 AND (ExpiredTime__ = 0 OR ExpiredTime__ > ?) THEN 1 ELSE 0 END), 0) AS active_cards`, now).
+			Where("Whom IN ? AND delstate = 0", usernames[start:end]).
+			Group("Whom").
+			Scan(&rows).Error
+		if err != nil {
+			return nil, fmt.Errorf("统计卡密失败: %v", err)
+		}
+		for _, row := range rows {
+			counts[row.Whom] = row
+		}
+	}
+
+	return counts, nil
+}
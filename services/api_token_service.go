@@ -0,0 +1,267 @@
+package services
+
+import (
+	"SProtectAgentWeb/database"
+	"SProtectAgentWeb/models"
+	"SProtectAgentWeb/util"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// API令牌相关限制
+const (
+	ApiTokenPrefix        = "sat_" // 令牌明文前缀
+	apiTokenDefaultDays   = 30     // 未指定有效期时的默认天数
+	apiTokenMaxDays       = 365    // 最长有效期（天）
+	apiTokenMaxPerAgent   = 20     // 每个代理最多持有的有效令牌数量
+	apiTokenTouchInterval = 60     // 最后使用时间的最小更新间隔（秒）
+)
+
+// ApiTokenService 个人API令牌服务
+// 令牌保存在Web端附属数据库中，权限为创建时选定的权限位与代理当前权限的交集
+type ApiTokenService struct {
+	dbManager *database.DatabaseManager
+}
+
+// NewApiTokenService 创建API令牌服务实例
+func NewApiTokenService(dbManager *database.DatabaseManager) *ApiTokenService {
+	return &ApiTokenService{
+		dbManager: dbManager,
+	}
+}
+
+// CreateToken 创建API令牌
+// userSession: 当前登录的用户会话
+// name: 令牌名称
+// permissions: 授权的权限位，必须是代理在每个授权软件位中都拥有的权限
+// softwares: 授权的软件位，必须是当前会话可访问的软件位
+// expireDays: 有效期（天），0表示使用默认值
+// 返回: 令牌明文（只返回这一次）、令牌记录和可能的错误
+func (s *ApiTokenService) CreateToken(userSession *models.UserSession, name string, permissions uint64, softwares []string, expireDays int) (string, *models.ApiToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len([]rune(name)) > 50 {
+		return "", nil, fmt.Errorf("令牌名称不能为空且不能超过50个字符")
+	}
+
+	if expireDays == 0 {
+		expireDays = apiTokenDefaultDays
+	}
+	if expireDays < 0 || expireDays > apiTokenMaxDays {
+		return "", nil, fmt.Errorf("令牌有效期必须在1-%d天之间", apiTokenMaxDays)
+	}
+
+	if permissions == 0 {
+		return "", nil, fmt.Errorf("请至少选择一项权限")
+	}
+	if unknown := permissions &^ util.AllPermissionBits(); unknown != 0 {
+		return "", nil, fmt.Errorf("未知的权限位: 0x%x", unknown)
+	}
+
+	softwares = uniqueStrings(softwares)
+	if len(softwares) == 0 {
+		return "", nil, fmt.Errorf("请至少选择一个软件位")
+	}
+	for _, software := range softwares {
+		agent, exists := userSession.SoftwareAgentInfo[software]
+		if !exists {
+			return "", nil, fmt.Errorf("无权访问软件位: %s", software)
+		}
+
+		authority, err := agent.GetAuthorityUint64()
+		if err != nil {
+			return "", nil, fmt.Errorf("解析权限失败: %v", err)
+		}
+		if missing := permissions &^ authority; missing != 0 {
+			return "", nil, fmt.Errorf("在软件位 [%s] 中没有要授权的全部权限", software)
+		}
+	}
+
+	db, err := s.dbManager.GetWebDB()
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now().Unix()
+	var count int64
+	err = db.Model(&models.ApiToken{}).
+		Where("Username = ? AND RevokedAt = 0 AND ExpiresAt > ?", userSession.Username, now).
+		Count(&count).Error
+	if err != nil {
+		return "", nil, fmt.Errorf("查询令牌数量失败: %v", err)
+	}
+	if count >= apiTokenMaxPerAgent {
+		return "", nil, fmt.Errorf("有效令牌数量已达上限(%d个)，请先吊销不再使用的令牌", apiTokenMaxPerAgent)
+	}
+
+	plain, err := generateApiToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	token := &models.ApiToken{
+		Username:    userSession.Username,
+		Name:        name,
+		TokenHash:   hashApiToken(plain),
+		Prefix:      plain[:12],
+		Permissions: strconv.FormatUint(permissions, 16),
+		Softwares:   util.BuildBracketList(softwares),
+		ExpiresAt:   now + int64(expireDays)*24*3600,
+		CreatedAt:   now,
+	}
+	if err := db.Create(token).Error; err != nil {
+		return "", nil, fmt.Errorf("保存令牌失败: %v", err)
+	}
+	token.SoftwareList = softwares
+
+	return plain, token, nil
+}
+
+// ListTokens 获取代理的所有令牌（含已过期和已吊销的），按创建时间倒序
+func (s *ApiTokenService) ListTokens(username string) ([]models.ApiToken, error) {
+	db, err := s.dbManager.GetWebDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens []models.ApiToken
+	if err := db.Where("Username = ?", username).Order("CreatedAt DESC").Find(&tokens).Error; err != nil {
+		return nil, fmt.Errorf("查询令牌列表失败: %v", err)
+	}
+	for i := range tokens {
+		tokens[i].SoftwareList = util.ParseBracketList(tokens[i].Softwares)
+	}
+
+	return tokens, nil
+}
+
+// RevokeToken 吊销代理自己的令牌
+func (s *ApiTokenService) RevokeToken(username string, id uint) error {
+	db, err := s.dbManager.GetWebDB()
+	if err != nil {
+		return err
+	}
+
+	result := db.Model(&models.ApiToken{}).
+		Where("ID = ? AND Username = ? AND RevokedAt = 0", id, username).
+		Update("RevokedAt", time.Now().Unix())
+	if result.Error != nil {
+		return fmt.Errorf("吊销令牌失败: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("令牌不存在或已吊销")
+	}
+
+	return nil
+}
+
+// Authenticate 校验请求携带的令牌并构造对应的用户会话
+// 会话中每个软件位的代理权限为令牌权限与代理当前权限的交集，
+// 代理在某个软件位中被禁用、删除或过期时该软件位不可用
+// plain: 令牌明文
+// ip: 客户端IP
+// 返回: 用户会话和可能的错误
+func (s *ApiTokenService) Authenticate(plain, ip string) (*models.UserSession, error) {
+	if !strings.HasPrefix(plain, ApiTokenPrefix) {
+		return nil, fmt.Errorf("令牌无效")
+	}
+
+	db, err := s.dbManager.GetWebDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var token models.ApiToken
+	err = db.Where("TokenHash = ?", hashApiToken(plain)).First(&token).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("令牌无效")
+		}
+		return nil, fmt.Errorf("查询令牌失败: %v", err)
+	}
+
+	now := time.Now().Unix()
+	if token.RevokedAt != 0 {
+		return nil, fmt.Errorf("令牌已被吊销")
+	}
+	if token.ExpiresAt <= now {
+		return nil, fmt.Errorf("令牌已过期")
+	}
+
+	if err := checkPasswordChangeRequirement(s.dbManager, token.Username, util.ParseBracketList(token.Softwares)); err != nil {
+		return nil, err
+	}
+
+	scope, err := util.ParseAuthority(token.Permissions)
+	if err != nil {
+		return nil, fmt.Errorf("令牌权限格式错误")
+	}
+
+	userSession, err := loadSessionAgents(s.dbManager, token.Username, util.ParseBracketList(token.Softwares), nil)
+	if err != nil {
+		return nil, err
+	}
+	userSession.IPAddress = ip
+	userSession.LoginTime = token.CreatedAt
+
+	// 权限取令牌授权范围与代理当前权限的交集
+	for _, agent := range userSession.SoftwareAgentInfo {
+		authority, err := agent.GetAuthorityUint64()
+		if err != nil {
+			authority = 0
+		}
+		agent.Authority = strconv.FormatUint(authority&scope, 16)
+	}
+	if len(userSession.SoftwareList) == 0 {
+		return nil, fmt.Errorf("令牌授权的软件位均不可用")
+	}
+
+	// 记录最后使用时间和IP，短时间内重复调用不重复写库
+	if now-token.LastUsedAt >= apiTokenTouchInterval || token.LastUsedIP != ip {
+		db.Model(&models.ApiToken{}).Where("ID = ?", token.ID).Updates(map[string]interface{}{
+			"LastUsedAt": now,
+			"LastUsedIP": ip,
+		})
+	}
+
+	return userSession, nil
+}
+
+// generateApiToken 生成令牌明文：前缀 + 160位随机数的Base32编码
+func generateApiToken() (string, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("生成令牌失败: %v", err)
+	}
+	encoded := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf)
+	return ApiTokenPrefix + strings.ToLower(encoded), nil
+}
+
+// hashApiToken 计算令牌明文的SHA-256摘要
+func hashApiToken(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
+
+// uniqueStrings 去除空字符串和重复项，保持原有顺序
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	var result []string
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" || seen[value] {
+			continue
+		}
+		seen[value] = true
+		result = append(result, value)
+	}
+	return result
+}
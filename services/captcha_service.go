@@ -0,0 +1,117 @@
+package services
+
+import (
+	"SProtectAgentWeb/config"
+	"SProtectAgentWeb/util"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// captchaLength 图形验证码位数
+const captchaLength = 5
+
+// captchaMaxPending 同时保存的未使用验证码数量上限，防止被刷接口占满内存
+const captchaMaxPending = 10000
+
+// CaptchaService 图形验证码服务
+// 验证码答案只保存在服务端内存中，每个验证码只能校验一次，过期自动失效
+type CaptchaService struct {
+	mutex      sync.Mutex
+	challenges map[string]*captchaChallenge
+	lastPrune  time.Time
+}
+
+// captchaChallenge 单个验证码
+type captchaChallenge struct {
+	answer    string
+	expiresAt time.Time
+}
+
+// NewCaptchaService 创建图形验证码服务实例
+func NewCaptchaService() *CaptchaService {
+	return &CaptchaService{
+		challenges: make(map[string]*captchaChallenge),
+	}
+}
+
+// Generate 生成一个新的图形验证码
+// 返回: 验证码编号、PNG图片数据、有效期（秒）和可能的错误
+func (s *CaptchaService) Generate() (string, []byte, int, error) {
+	answer, err := util.RandomDigits(captchaLength)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	image, err := util.RenderCaptcha(answer)
+	if err != nil {
+		return "", nil, 0, err
+	}
+
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", nil, 0, fmt.Errorf("生成验证码编号失败: %v", err)
+	}
+	id := hex.EncodeToString(idBytes)
+
+	expireSeconds := config.GetCaptchaConfig().ExpireSeconds
+	now := time.Now()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.prune(now, len(s.challenges) >= captchaMaxPending)
+	if len(s.challenges) >= captchaMaxPending {
+		return "", nil, 0, fmt.Errorf("验证码请求过于频繁，请稍后再试")
+	}
+	s.challenges[id] = &captchaChallenge{
+		answer:    answer,
+		expiresAt: now.Add(time.Duration(expireSeconds) * time.Second),
+	}
+
+	return id, image, expireSeconds, nil
+}
+
+// Verify 校验图形验证码，无论是否正确验证码都会作废
+// id: 验证码编号
+// answer: 用户输入的验证码
+// 返回: 是否正确且未过期
+func (s *CaptchaService) Verify(id, answer string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	challenge, exists := s.challenges[id]
+	if !exists {
+		return false
+	}
+	delete(s.challenges, id)
+
+	if time.Now().After(challenge.expiresAt) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(challenge.answer), []byte(answer)) == 1
+}
+
+// Required 登录时是否需要图形验证码
+// failures: 账号和IP中较大的连续失败次数
+func (s *CaptchaService) Required(failures int) bool {
+	captcha := config.GetCaptchaConfig()
+	return captcha.Always || failures >= captcha.AfterFailures
+}
+
+// prune 清理过期的验证码，调用方需持有锁
+// force为false时每分钟最多清理一次
+func (s *CaptchaService) prune(now time.Time, force bool) {
+	if !force && now.Sub(s.lastPrune) < time.Minute {
+		return
+	}
+	s.lastPrune = now
+
+	for id, challenge := range s.challenges {
+		if now.After(challenge.expiresAt) {
+			delete(s.challenges, id)
+		}
+	}
+}
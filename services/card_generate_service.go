@@ -0,0 +1,162 @@
+package services
+
+import (
+	"SProtectAgentWeb/database"
+	"SProtectAgentWeb/models"
+	"SProtectAgentWeb/types"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// cardKeyLength 卡号中前缀之后随机部分的长度
+const cardKeyLength = 24
+
+// cardKeyAlphabet 卡号随机部分使用的字符，去掉了容易混淆的0、1、I、O
+const cardKeyAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// CardGenerateService 生成卡密服务
+// 扣除制卡费用、写入卡密和记录余额流水经过流水服务在同一个软件位事务中完成
+type CardGenerateService struct {
+	dbManager *database.DatabaseManager
+	ledger    *LedgerService
+}
+
+// NewCardGenerateService 创建生成卡密服务实例
+func NewCardGenerateService(dbManager *database.DatabaseManager, ledger *LedgerService) *CardGenerateService {
+	return &CardGenerateService{
+		dbManager: dbManager,
+		ledger:    ledger,
+	}
+}
+
+// GenerateCards 生成卡密并从代理余额中扣除制卡费用
+// 单价为卡类型原价按代理总利率折算后的价格（与修改利率时预览的制卡价格一致），余额不足时不生成任何卡密
+// software: 软件位名称
+// username: 制卡代理账号
+// cardType: 卡类型名称
+// count: 生成数量
+// remarks: 卡密备注
+// 返回: 生成结果和可能的错误
+func (s *CardGenerateService) GenerateCards(software, username, cardType string, count int, remarks string) (*types.GenerateCardsResult, error) {
+	if count < 1 || count > 1000 {
+		return nil, fmt.Errorf("生成数量必须在1到1000之间")
+	}
+
+	result := &types.GenerateCardsResult{Count: count}
+	remark := fmt.Sprintf("生成卡密 %s × %d", cardType, count)
+	reference, err := s.ledger.Apply(software, username, remark, func(tx *gorm.DB) ([]LedgerChange, error) {
+		// 会话中的代理信息可能已过时，以数据库中的最新值为准
+		var agent models.Agent
+		err := tx.Where("User = ?", username).First(&agent).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("代理不存在")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("查询代理失败: %v", err)
+		}
+		if !agent.IsValid() {
+			return nil, fmt.Errorf("代理已被禁用、删除或已过期")
+		}
+		if !agent.HasCreateCardType(cardType) {
+			return nil, fmt.Errorf("无权制作该类型的卡密")
+		}
+
+		var ct models.CardType
+		err = tx.Where("Name = ?", cardType).First(&ct).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("卡类型不存在")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("查询卡类型失败: %v", err)
+		}
+		result.UnitPrice = ct.CalculatePrice(agent.TatalParities)
+		result.TotalCost = math.Round(result.UnitPrice*float64(count)*100) / 100
+
+		usernames := []string{username}
+		before, err := readBalances(tx, usernames)
+		if err != nil {
+			return nil, err
+		}
+
+		// 条件更新保证并发制卡时不会扣成负数
+		if result.TotalCost > 0 {
+			update := tx.Model(&models.Agent{}).
+				Where("User = ? AND AccountBalance >= ?", username, result.TotalCost).
+				Update("AccountBalance", gorm.Expr("AccountBalance - ?", result.TotalCost))
+			if update.Error != nil {
+				return nil, fmt.Errorf("扣除余额失败: %v", update.Error)
+			}
+			if update.RowsAffected == 0 {
+				return nil, fmt.Errorf("余额不足，需要%.2f", result.TotalCost)
+			}
+		}
+
+		now := time.Now().Unix()
+		cards := make([]models.CardInfo, 0, count)
+		result.Cards = make([]string, 0, count)
+		for i := 0; i < count; i++ {
+			key, err := newCardKey(ct.Prefix)
+			if err != nil {
+				return nil, err
+			}
+			cards = append(cards, models.CardInfo{
+				PrefixName:           key,
+				Whom:                 username,
+				CardType:             ct.Name,
+				FYI:                  ct.FYI,
+				State:                "启用",
+				Bind:                 ct.Bind,
+				OpenNum:              ct.OpenNum,
+				Remarks:              remarks,
+				CreateData_:          now,
+				ExpiredTime_:         int64(ct.Duration),
+				Price:                result.UnitPrice,
+				AttrUnBindLimitTime:  ct.AttrUnBindLimitTime,
+				AttrUnBindDeductTime: ct.AttrUnBindDeductTime,
+				AttrUnBindFreeCount:  ct.AttrUnBindFreeCount,
+				AttrUnBindMaxCount:   ct.AttrUnBindMaxCount,
+				BindIP:               ct.BindIP,
+				BindMachineNum:       ct.BindMachineNum,
+				LockBindPcsign:       ct.LockBindPcsign,
+			})
+			result.Cards = append(result.Cards, key)
+		}
+		if err := tx.CreateInBatches(&cards, 100).Error; err != nil {
+			return nil, fmt.Errorf("写入卡密失败: %v", err)
+		}
+
+		after, err := readBalances(tx, usernames)
+		if err != nil {
+			return nil, err
+		}
+		return []LedgerChange{
+			newLedgerChange(username, models.LedgerTypeCardCost, before[username], after[username]),
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Reference = reference
+
+	return result, nil
+}
+
+// newCardKey 生成卡号：卡类型前缀加随机字符
+func newCardKey(prefix string) (string, error) {
+	buf := make([]byte, cardKeyLength)
+	limit := big.NewInt(int64(len(cardKeyAlphabet)))
+	for i := range buf {
+		n, err := rand.Int(rand.Reader, limit)
+		if err != nil {
+			return "", fmt.Errorf("生成卡号失败: %v", err)
+		}
+		buf[i] = cardKeyAlphabet[n.Int64()]
+	}
+	return prefix + string(buf), nil
+}
@@ -0,0 +1,240 @@
+package services
+
+import (
+	"SProtectAgentWeb/config"
+	"SProtectAgentWeb/database"
+	"SProtectAgentWeb/models"
+	"SProtectAgentWeb/types"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ExpiryService 代理到期管理服务
+// 负责下级代理的续期，以及后台定期检查已到期的代理
+type ExpiryService struct {
+	dbManager *database.DatabaseManager
+	quit      chan struct{}
+}
+
+// NewExpiryService 创建代理到期管理服务实例
+func NewExpiryService(dbManager *database.DatabaseManager) *ExpiryService {
+	return &ExpiryService{
+		dbManager: dbManager,
+	}
+}
+
+// SetExpiry 批量设置下级代理的到期时间
+// 延长时从当前到期时间（已到期的从现在）开始计算；下级代理的到期时间不能晚于当前代理自身的到期时间
+// 因到期被自动禁用的代理在续期后恢复启用
+// software: 软件位名称
+// parentUser: 当前代理账号
+// usernames: 下级代理账号列表
+// extendDays: 延长天数，大于0时按延长处理
+// expiration: 指定的到期时间戳，extendDays为0时使用
+// neverExpire: 设置为永不过期，与extendDays、expiration互斥
+// 返回: 每个代理的处理结果和可能的错误
+func (s *ExpiryService) SetExpiry(software, parentUser string, usernames []string, extendDays int, expiration int64, neverExpire bool) ([]types.ExpiryResult, error) {
+	switch {
+	case neverExpire && (extendDays > 0 || expiration != 0):
+		return nil, fmt.Errorf("永不过期不能与延长天数或到期时间同时设置")
+	case !neverExpire && extendDays <= 0 && expiration <= 0:
+		return nil, fmt.Errorf("请输入延长天数或到期时间")
+	case extendDays > 0 && expiration != 0:
+		return nil, fmt.Errorf("延长天数和到期时间只能设置一项")
+	}
+
+	db, err := s.dbManager.GetSoftwareDB(software)
+	if err != nil {
+		return nil, err
+	}
+	webDB, err := s.dbManager.GetWebDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var parent models.Agent
+	err = db.Where("User = ?", parentUser).First(&parent).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("代理不存在")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询代理失败: %v", err)
+	}
+
+	now := time.Now().Unix()
+	results := make([]types.ExpiryResult, 0, len(usernames))
+	for _, username := range usernames {
+		result := types.ExpiryResult{Username: username}
+
+		target, err := findOwnedSubAgent(db, parentUser, username)
+		if err != nil {
+			result.Message = err.Error()
+			results = append(results, result)
+			continue
+		}
+
+		newExpiration := expiration
+		if extendDays > 0 {
+			if target.Duration_ == 0 {
+				result.Message = "该代理永不过期，无需延长"
+				results = append(results, result)
+				continue
+			}
+			base := target.Duration_
+			if base < now {
+				base = now
+			}
+			newExpiration = base + int64(extendDays)*86400
+		}
+
+		if parent.Duration_ > 0 && (newExpiration == 0 || newExpiration > parent.Duration_) {
+			result.Message = "到期时间不能晚于您自己的到期时间"
+			results = append(results, result)
+			continue
+		}
+		if newExpiration != 0 && newExpiration <= now {
+			result.Message = "到期时间必须晚于当前时间"
+			results = append(results, result)
+			continue
+		}
+
+		var mark models.ExpiredAgent
+		marked := webDB.Where("Software = ? AND Username = ?", software, username).Limit(1).Find(&mark)
+		if marked.Error != nil {
+			result.Message = fmt.Sprintf("查询到期标记失败: %v", marked.Error)
+			results = append(results, result)
+			continue
+		}
+
+		updates := map[string]interface{}{"Duration_": newExpiration}
+		if marked.RowsAffected > 0 && mark.Disabled && target.Stat != 0 {
+			updates["Stat"] = 0
+			result.Reenabled = true
+		}
+		if err := db.Model(&models.Agent{}).Where("User = ?", username).Updates(updates).Error; err != nil {
+			result.Message = fmt.Sprintf("更新到期时间失败: %v", err)
+			result.Reenabled = false
+			results = append(results, result)
+			continue
+		}
+
+		if marked.RowsAffected > 0 {
+			if err := webDB.Delete(&mark).Error; err != nil {
+				log.Printf("删除到期标记失败 [%s/%s]: %v", software, username, err)
+			}
+		}
+
+		result.Success = true
+		result.Expiration = newExpiration
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// Sweep 检查所有软件位中已到期的代理并做标记
+// 配置了到期自动禁用时同时禁用这些代理
+// 返回: 本次标记的代理数量和可能的错误
+func (s *ExpiryService) Sweep() (int, error) {
+	dbs, err := s.dbManager.GetAllSoftwareDB()
+	if err != nil {
+		return 0, err
+	}
+	webDB, err := s.dbManager.GetWebDB()
+	if err != nil {
+		return 0, err
+	}
+
+	disable := config.GetExpiryConfig().DisableExpired
+	now := time.Now().Unix()
+	total := 0
+	for software, db := range dbs {
+		var agents []models.Agent
+		err := db.Where("Stat = 0 AND deltm = 0 AND Duration_ > 0 AND Duration_ <= ?", now).Find(&agents).Error
+		if err != nil {
+			log.Printf("查询到期代理失败 [%s]: %v", software, err)
+			continue
+		}
+
+		for i := range agents {
+			agent := &agents[i]
+
+			var existing models.ExpiredAgent
+			marked := webDB.Where("Software = ? AND Username = ?", software, agent.User).Limit(1).Find(&existing)
+			if marked.Error != nil {
+				log.Printf("查询到期标记失败 [%s/%s]: %v", software, agent.User, marked.Error)
+				continue
+			}
+			// 标记的是本次到期时跳过；到期时间在标记之后被改过（如在软件端直接续期）时，旧标记已失效，替换为本次到期
+			if marked.RowsAffected > 0 && existing.ExpiredAt == agent.Duration_ {
+				continue
+			}
+
+			if disable {
+				// 只禁用仍处于到期状态的代理，避免覆盖检查期间的续期
+				result := db.Model(&models.Agent{}).
+					Where("User = ? AND Stat = 0 AND Duration_ = ?", agent.User, agent.Duration_).
+					Update("Stat", 1)
+				if result.Error != nil {
+					log.Printf("禁用到期代理失败 [%s/%s]: %v", software, agent.User, result.Error)
+					continue
+				}
+				if result.RowsAffected == 0 {
+					continue
+				}
+			}
+
+			mark := &models.ExpiredAgent{
+				ID:        existing.ID,
+				Software:  software,
+				Username:  agent.User,
+				ExpiredAt: agent.Duration_,
+				Disabled:  disable,
+				MarkedAt:  now,
+			}
+			if err := webDB.Save(mark).Error; err != nil {
+				log.Printf("保存到期标记失败 [%s/%s]: %v", software, agent.User, err)
+				continue
+			}
+			total++
+		}
+	}
+
+	return total, nil
+}
+
+// StartSweeper 启动后台协程定期检查已到期的代理
+// interval: 检查间隔
+func (s *ExpiryService) StartSweeper(interval time.Duration) {
+	s.quit = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				count, err := s.Sweep()
+				if err != nil {
+					log.Printf("检查到期代理失败: %v", err)
+				} else if count > 0 {
+					log.Printf("已标记到期代理: %d 个", count)
+				}
+			case <-s.quit:
+				return
+			}
+		}
+	}()
+}
+
+// StopSweeper 停止后台检查协程
+func (s *ExpiryService) StopSweeper() {
+	if s.quit != nil {
+		close(s.quit)
+		s.quit = nil
+	}
+}
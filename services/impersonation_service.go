@@ -0,0 +1,117 @@
+package services
+
+import (
+	"SProtectAgentWeb/database"
+	"SProtectAgentWeb/models"
+	"fmt"
+	"time"
+)
+
+// ImpersonationService 模拟查看服务
+// 上级代理可以只读方式查看下级链中任意代理看到的页面，便于排查下级代理反馈的问题，全过程记录审计日志
+type ImpersonationService struct {
+	dbManager *database.DatabaseManager
+}
+
+// NewImpersonationService 创建模拟查看服务实例
+func NewImpersonationService(dbManager *database.DatabaseManager) *ImpersonationService {
+	return &ImpersonationService{
+		dbManager: dbManager,
+	}
+}
+
+// Start 开始模拟查看
+// 只加载被查看代理在该软件位中的信息，并记录审计日志
+// software: 软件位名称
+// actorUser: 上级代理账号
+// targetUser: 被查看的下级代理账号
+// ip: 客户端IP
+// 返回: 被查看代理的只读会话和可能的错误
+func (s *ImpersonationService) Start(software, actorUser, targetUser, ip string) (*models.UserSession, error) {
+	softwareDB, err := s.dbManager.GetSoftwareDB(software)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := findOwnedSubAgent(softwareDB, actorUser, targetUser); err != nil {
+		return nil, err
+	}
+
+	userSession, err := loadSessionAgents(s.dbManager, targetUser, []string{software}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(userSession.SoftwareList) == 0 {
+		return nil, fmt.Errorf("该代理已被禁用或已过期")
+	}
+	userSession.IPAddress = ip
+	userSession.LoginTime = time.Now().Unix()
+	userSession.ImpersonatedBy = actorUser
+	userSession.ImpersonationSoftware = software
+
+	if err := s.Record(actorUser, targetUser, software, models.ImpersonationActionStart, "", ip); err != nil {
+		return nil, err
+	}
+
+	return userSession, nil
+}
+
+// Record 记录一条模拟查看审计日志
+// action: 审计动作（models.ImpersonationAction*）
+// path: 访问的接口，开始和结束时为空
+func (s *ImpersonationService) Record(actorUser, targetUser, software, action, path, ip string) error {
+	db, err := s.dbManager.GetWebDB()
+	if err != nil {
+		return err
+	}
+
+	record := &models.ImpersonationAudit{
+		Actor:     actorUser,
+		Target:    targetUser,
+		Software:  software,
+		Action:    action,
+		Path:      path,
+		IPAddress: ip,
+		CreatedAt: time.Now().Unix(),
+	}
+	if err := db.Create(record).Error; err != nil {
+		return fmt.Errorf("保存模拟查看记录失败: %v", err)
+	}
+	return nil
+}
+
+// ListAudit 查询与代理相关的模拟查看记录
+// 包括代理查看别人和被上级查看的记录
+// username: 代理账号
+// page: 页码
+// limit: 每页数量
+// 返回: 记录列表、总数和可能的错误
+func (s *ImpersonationService) ListAudit(username string, page, limit int) ([]models.ImpersonationAudit, int64, error) {
+	db, err := s.dbManager.GetWebDB()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	query := db.Model(&models.ImpersonationAudit{}).Where("Actor = ? OR Target = ?", username, username)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("查询模拟查看记录失败: %v", err)
+	}
+
+	var records []models.ImpersonationAudit
+	err = query.Order("CreatedAt DESC").Order("ID DESC").
+		Offset((page - 1) * limit).Limit(limit).
+		Find(&records).Error
+	if err != nil {
+		return nil, 0, fmt.Errorf("查询模拟查看记录失败: %v", err)
+	}
+
+	return records, total, nil
+}
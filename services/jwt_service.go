@@ -0,0 +1,207 @@
+package services
+
+import (
+	"SProtectAgentWeb/config"
+	"SProtectAgentWeb/database"
+	"SProtectAgentWeb/models"
+	"SProtectAgentWeb/types"
+	"SProtectAgentWeb/util"
+	"fmt"
+	"time"
+)
+
+// JWTService JWT认证服务
+// 与Cookie会话并存，供移动端和桌面工具在不使用Cookie的情况下调用接口。
+// 令牌不在服务端保存：每次请求都会重新读取代理信息，代理被禁用、删除、过期或修改密码后令牌立即失效
+type JWTService struct {
+	dbManager *database.DatabaseManager
+}
+
+// NewJWTService 创建JWT认证服务实例
+func NewJWTService(dbManager *database.DatabaseManager) *JWTService {
+	return &JWTService{
+		dbManager: dbManager,
+	}
+}
+
+// IssueTokens 为已通过密码（及二次验证）校验的用户会话签发访问令牌和刷新令牌
+// userSession: 登录得到的用户会话
+// 返回: 登录响应和可能的错误
+func (s *JWTService) IssueTokens(userSession *models.UserSession) (*types.LoginResponse, error) {
+	if config.IsPlaceholderJWTSecret() {
+		return nil, fmt.Errorf("服务端未配置JWT密钥，JWT登录不可用")
+	}
+
+	secret := config.GetJWTSecret()
+	fingerprint := util.JWTFingerprint(userSession.Password, secret)
+
+	accessToken, accessExpire, err := s.sign(userSession.Username, util.JWTTypeAccess, userSession.SoftwareList, fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, refreshExpire, err := s.sign(userSession.Username, util.JWTTypeRefresh, userSession.SoftwareList, fingerprint)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &types.LoginResponse{
+		Token:             accessToken,
+		ExpireTime:        accessExpire,
+		RefreshToken:      refreshToken,
+		RefreshExpireTime: refreshExpire,
+	}
+	for _, software := range userSession.SoftwareList {
+		agent := userSession.SoftwareAgentInfo[software]
+		if agent == nil {
+			continue
+		}
+		if response.Agent == nil {
+			response.Agent = agent
+			response.PrimarySoftware = software
+		}
+		response.AccessibleSofts = append(response.AccessibleSofts, buildSoftwareAgentInfo(software, userSession.Username, agent))
+	}
+
+	return response, nil
+}
+
+// Refresh 使用刷新令牌换取新的访问令牌和刷新令牌
+// 软件位按代理当前状态重新计算，已不可用的软件位不再写入新令牌
+// refreshToken: 刷新令牌
+// 返回: 新令牌和可能的错误
+func (s *JWTService) Refresh(refreshToken string) (*models.RefreshTokenResponse, error) {
+	if config.IsPlaceholderJWTSecret() {
+		return nil, fmt.Errorf("服务端未配置JWT密钥，JWT登录不可用")
+	}
+
+	claims, err := util.ParseJWT(refreshToken, config.GetJWTSecret())
+	if err != nil {
+		return nil, err
+	}
+	if claims.Type != util.JWTTypeRefresh {
+		return nil, fmt.Errorf("令牌类型错误")
+	}
+
+	userSession, err := s.loadClaims(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, accessExpire, err := s.sign(claims.Subject, util.JWTTypeAccess, userSession.SoftwareList, claims.Fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	newRefreshToken, refreshExpire, err := s.sign(claims.Subject, util.JWTTypeRefresh, userSession.SoftwareList, claims.Fingerprint)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	return &models.RefreshTokenResponse{
+		Token:            accessToken,
+		ExpiresIn:        accessExpire - now,
+		RefreshToken:     newRefreshToken,
+		RefreshExpiresIn: refreshExpire - now,
+	}, nil
+}
+
+// Authenticate 校验访问令牌并构造对应的用户会话
+// token: 访问令牌
+// ip: 客户端IP
+// 返回: 用户会话和可能的错误
+func (s *JWTService) Authenticate(token, ip string) (*models.UserSession, error) {
+	if config.IsPlaceholderJWTSecret() {
+		return nil, fmt.Errorf("服务端未配置JWT密钥，JWT登录不可用")
+	}
+
+	claims, err := util.ParseJWT(token, config.GetJWTSecret())
+	if err != nil {
+		return nil, err
+	}
+	if claims.Type != util.JWTTypeAccess {
+		return nil, fmt.Errorf("令牌类型错误")
+	}
+
+	userSession, err := s.loadClaims(claims)
+	if err != nil {
+		return nil, err
+	}
+	userSession.IPAddress = ip
+	userSession.LoginTime = claims.IssuedAt
+
+	return userSession, nil
+}
+
+// loadClaims 根据令牌载荷重新读取代理信息
+// 密码指纹与代理当前密码不一致的软件位视为已失效
+func (s *JWTService) loadClaims(claims *util.JWTClaims) (*models.UserSession, error) {
+	if err := checkPasswordChangeRequirement(s.dbManager, claims.Subject, claims.Softwares); err != nil {
+		return nil, err
+	}
+
+	secret := config.GetJWTSecret()
+	userSession, err := loadSessionAgents(s.dbManager, claims.Subject, claims.Softwares, func(agent *models.Agent) bool {
+		return util.JWTFingerprint(agent.Password, secret) == claims.Fingerprint
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(userSession.SoftwareList) == 0 {
+		return nil, fmt.Errorf("令牌已失效，请重新登录")
+	}
+
+	return userSession, nil
+}
+
+// sign 签发指定类型的令牌
+// 返回: 令牌、过期时间戳和可能的错误
+func (s *JWTService) sign(username, tokenType string, softwares []string, fingerprint string) (string, int64, error) {
+	now := time.Now().Unix()
+	lifetime := int64(config.GetJWTExpireTime())
+	if tokenType == util.JWTTypeRefresh {
+		lifetime = int64(config.GetJWTRefreshExpireTime())
+	}
+
+	claims := &util.JWTClaims{
+		Subject:     username,
+		Type:        tokenType,
+		Softwares:   softwares,
+		Fingerprint: fingerprint,
+		IssuedAt:    now,
+		ExpiresAt:   now + lifetime,
+	}
+	token, err := util.SignJWT(claims, config.GetJWTSecret())
+	if err != nil {
+		return "", 0, err
+	}
+
+	return token, claims.ExpiresAt, nil
+}
+
+// buildSoftwareAgentInfo 构造登录响应中的软件位代理信息
+func buildSoftwareAgentInfo(software, username string, agent *models.Agent) types.SoftwareAgentInfo {
+	authority, _ := agent.GetAuthorityUint64()
+	permissions := make(map[string]bool, len(util.PermissionNames))
+	for bit, name := range util.PermissionNames {
+		permissions[name] = util.HasPermission(authority, bit)
+	}
+
+	expiration := "永久"
+	if agent.Duration_ != 0 {
+		expiration = time.Unix(agent.Duration_, 0).Format("2006-01-02 15:04:05")
+	}
+
+	return types.SoftwareAgentInfo{
+		SoftwareName: software,
+		AgentInfo: &types.AgentInfo{
+			Username:    username,
+			Balance:     agent.AccountBalance,
+			TimeStock:   int64(agent.AccountTime),
+			Permissions: permissions,
+			CardTypes:   agent.CardTypeAuthNameArray,
+			Status:      "启用",
+			Expiration:  expiration,
+		},
+		Permissions: permissions,
+	}
+}
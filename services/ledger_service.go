@@ -0,0 +1,265 @@
+package services
+
+import (
+	"SProtectAgentWeb/database"
+	"SProtectAgentWeb/models"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ledgerExportLimit 导出流水的最大条数
+const ledgerExportLimit = 10000
+
+// LedgerService 余额流水服务
+// 在Web端附属数据库中记录代理余额和库存时长的每一次变动
+type LedgerService struct {
+	dbManager *database.DatabaseManager
+	mu        sync.Mutex
+	locks     map[string]*sync.Mutex // 每个软件位一把锁，串行化经过流水服务的余额变动
+}
+
+// NewLedgerService 创建余额流水服务实例
+func NewLedgerService(dbManager *database.DatabaseManager) *LedgerService {
+	return &LedgerService{
+		dbManager: dbManager,
+		locks:     make(map[string]*sync.Mutex),
+	}
+}
+
+// LedgerChange 单个代理的一次余额变动
+type LedgerChange struct {
+	Username      string  // 余额变动的代理
+	Type          string  // 流水类型
+	BalanceBefore float64 // 变动前余额
+	BalanceAfter  float64 // 变动后余额
+	TimeBefore    int     // 变动前库存时长（秒）
+	TimeAfter     int     // 变动后库存时长（秒）
+}
+
+// ledgerBalance 代理余额快照
+type ledgerBalance struct {
+	balance float64
+	time    int
+}
+
+// LedgerQuery 流水查询条件
+type LedgerQuery struct {
+	Software  string // 软件位名称
+	Username  string // 代理账号
+	Type      string // 流水类型，为空表示全部
+	StartTime int64  // 开始时间戳（含），0表示不限
+	EndTime   int64  // 结束时间戳（不含），0表示不限
+	Page      int    // 页码
+	Limit     int    // 每页数量
+	Export    bool   // 是否导出，导出时不分页，最多返回ledgerExportLimit条
+}
+
+// Record 记录一次操作产生的余额流水，同一次调用的流水使用相同的操作编号
+// software: 软件位名称
+// actor: 执行操作的代理
+// remark: 说明
+// changes: 各代理的余额变动
+// 返回: 操作编号和可能的错误
+func (s *LedgerService) Record(software, actor, remark string, changes ...LedgerChange) (string, error) {
+	reference := newLedgerReference()
+
+	db, err := s.dbManager.GetWebDB()
+	if err != nil {
+		return "", fmt.Errorf("记录余额流水失败: %v", err)
+	}
+
+	now := time.Now().Unix()
+	records := make([]models.BalanceLedger, 0, len(changes))
+	for _, change := range changes {
+		balanceChange := math.Round((change.BalanceAfter-change.BalanceBefore)*100) / 100
+		timeChange := change.TimeAfter - change.TimeBefore
+		if balanceChange == 0 && timeChange == 0 {
+			continue
+		}
+		records = append(records, models.BalanceLedger{
+			Software:      software,
+			Username:      change.Username,
+			Actor:         actor,
+			Type:          change.Type,
+			BalanceBefore: change.BalanceBefore,
+			BalanceAfter:  change.BalanceAfter,
+			BalanceChange: balanceChange,
+			TimeBefore:    change.TimeBefore,
+			TimeAfter:     change.TimeAfter,
+			TimeChange:    timeChange,
+			Reference:     reference,
+			Remark:        remark,
+			CreatedAt:     now,
+		})
+	}
+	if len(records) == 0 {
+		return reference, nil
+	}
+
+	if err := db.Create(&records).Error; err != nil {
+		return "", fmt.Errorf("记录余额流水失败: %v", err)
+	}
+	return reference, nil
+}
+
+// Apply 在软件位数据库的事务中执行余额变动，事务提交成功后写入流水
+// operation在事务内修改余额，返回该事务实际读取和写入的变动前后余额
+// 流水位于Web端附属数据库，无法与软件位数据库共用一个事务，因此只在余额变动提交后写入：
+// 事务回滚时不会留下流水；提交后流水写入失败时余额变动已经生效，不做补偿，记录日志并返回"操作已完成"的错误
+// 整个过程持有软件位的流水锁，经过流水服务的余额变动按提交顺序写入流水
+// software: 软件位名称
+// actor: 执行操作的代理
+// remark: 说明
+// operation: 实际操作
+// 返回: 操作编号和可能的错误
+func (s *LedgerService) Apply(software, actor, remark string, operation func(tx *gorm.DB) ([]LedgerChange, error)) (string, error) {
+	db, err := s.dbManager.GetSoftwareDB(software)
+	if err != nil {
+		return "", err
+	}
+
+	unlock := s.lock(software)
+	defer unlock()
+
+	var changes []LedgerChange
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		changes, err = operation(tx)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	reference, err := s.Record(software, actor, remark, changes...)
+	if err != nil {
+		log.Printf("余额变动已提交但流水写入失败 [%s/%s] %s: %v", software, actor, remark, err)
+		return "", fmt.Errorf("操作已完成，但%v", err)
+	}
+	return reference, nil
+}
+
+// ListLedger 分页查询余额流水，按时间倒序
+// query: 查询条件
+// 返回: 流水记录、总数和可能的错误
+func (s *LedgerService) ListLedger(query *LedgerQuery) ([]models.BalanceLedger, int64, error) {
+	db, err := s.dbManager.GetWebDB()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	page := query.Page
+	if page < 1 {
+		page = 1
+	}
+	limit := query.Limit
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	tx := db.Model(&models.BalanceLedger{}).Where("Software = ? AND Username = ?", query.Software, query.Username)
+	if query.Type != "" {
+		tx = tx.Where("Type = ?", query.Type)
+	}
+	if query.StartTime > 0 {
+		tx = tx.Where("CreatedAt >= ?", query.StartTime)
+	}
+	if query.EndTime > 0 {
+		tx = tx.Where("CreatedAt < ?", query.EndTime)
+	}
+
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("查询余额流水失败: %v", err)
+	}
+
+	var records []models.BalanceLedger
+	tx = tx.Order("CreatedAt DESC").Order("ID DESC")
+	if query.Export {
+		tx = tx.Limit(ledgerExportLimit)
+	} else {
+		tx = tx.Offset((page - 1) * limit).Limit(limit)
+	}
+	if err := tx.Find(&records).Error; err != nil {
+		return nil, 0, fmt.Errorf("查询余额流水失败: %v", err)
+	}
+
+	return records, total, nil
+}
+
+// CheckAccess 校验代理是否可以查看指定账号的余额流水
+// 可以查看自己和下级链中代理的流水
+// software: 软件位名称
+// username: 当前代理账号
+// target: 要查看的代理账号
+// 返回: 无权查看时返回错误
+func (s *LedgerService) CheckAccess(software, username, target string) error {
+	if target == username {
+		return nil
+	}
+
+	db, err := s.dbManager.GetSoftwareDB(software)
+	if err != nil {
+		return err
+	}
+
+	_, err = findOwnedSubAgent(db, username, target)
+	return err
+}
+
+// readBalances 读取代理的余额和库存时长，在事务中调用时读取的是事务内的值
+func readBalances(db *gorm.DB, usernames []string) (map[string]ledgerBalance, error) {
+	var agents []models.Agent
+	if err := db.Select("User", "AccountBalance", "AccountTime").Where("User IN ?", usernames).Find(&agents).Error; err != nil {
+		return nil, fmt.Errorf("查询代理余额失败: %v", err)
+	}
+
+	balances := make(map[string]ledgerBalance, len(agents))
+	for i := range agents {
+		balances[agents[i].User] = ledgerBalance{balance: agents[i].AccountBalance, time: agents[i].AccountTime}
+	}
+	return balances, nil
+}
+
+// newLedgerChange 按变动前后的余额生成流水变动
+func newLedgerChange(username, entryType string, before, after ledgerBalance) LedgerChange {
+	return LedgerChange{
+		Username:      username,
+		Type:          entryType,
+		BalanceBefore: before.balance,
+		BalanceAfter:  after.balance,
+		TimeBefore:    before.time,
+		TimeAfter:     after.time,
+	}
+}
+
+// lock 获取软件位的流水锁
+// 返回: 释放锁的函数
+func (s *LedgerService) lock(software string) func() {
+	s.mu.Lock()
+	lock, ok := s.locks[software]
+	if !ok {
+		lock = &sync.Mutex{}
+		s.locks[software] = lock
+	}
+	s.mu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// newLedgerReference 生成操作编号
+func newLedgerReference() string {
+	buf := make([]byte, 6)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("L%d", time.Now().UnixNano())
+	}
+	return fmt.Sprintf("L%s%s", time.Now().Format("20060102150405"), hex.EncodeToString(buf))
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"SProtectAgentWeb/config"
+	"SProtectAgentWeb/database"
+	"SProtectAgentWeb/models"
+	"SProtectAgentWeb/util"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// LoginHistoryService 登录记录服务
+// 记录每一次登录尝试，并对来自新IP或连续失败后的成功登录做可疑标记
+type LoginHistoryService struct {
+	dbManager *database.DatabaseManager
+	mutex     sync.Mutex
+	lastPrune time.Time
+}
+
+// NewLoginHistoryService 创建登录记录服务实例
+func NewLoginHistoryService(dbManager *database.DatabaseManager) *LoginHistoryService {
+	return &LoginHistoryService{
+		dbManager: dbManager,
+	}
+}
+
+// RecordAttempt 记录一次登录尝试
+// 成功的登录会与历史记录比对：首次从该IP登录成功，或成功前连续失败次数达到阈值时标记为可疑
+// record: 登录记录（Username、Success、Reason、Method、IPAddress、UserAgent、Softwares由调用方填写）
+// 返回: 可能的错误
+func (s *LoginHistoryService) RecordAttempt(record *models.LoginHistory) error {
+	db, err := s.dbManager.GetWebDB()
+	if err != nil {
+		return err
+	}
+
+	record.CreatedAt = time.Now().Unix()
+
+	if record.Success {
+		// 最近一次成功登录的时间，之后的失败视为本次成功前的连续失败
+		var lastSuccess models.LoginHistory
+		result := db.Where("Username = ? AND Success = ?", record.Username, true).
+			Order("CreatedAt DESC").Limit(1).Find(&lastSuccess)
+		if result.Error != nil {
+			return fmt.Errorf("查询登录记录失败: %v", result.Error)
+		}
+
+		var failures int64
+		err = db.Model(&models.LoginHistory{}).
+			Where("Username = ? AND Success = ? AND CreatedAt >= ?", record.Username, false, lastSuccess.CreatedAt).
+			Count(&failures).Error
+		if err != nil {
+			return fmt.Errorf("查询登录记录失败: %v", err)
+		}
+		record.AfterFailures = int(failures)
+
+		// 第一次登录没有可比较的历史，不标记新IP
+		if result.RowsAffected > 0 {
+			var sameIP int64
+			err = db.Model(&models.LoginHistory{}).
+				Where("Username = ? AND Success = ? AND IPAddress = ?", record.Username, true, record.IPAddress).
+				Count(&sameIP).Error
+			if err != nil {
+				return fmt.Errorf("查询登录记录失败: %v", err)
+			}
+			record.NewIP = sameIP == 0
+		}
+
+		record.Suspicious = record.NewIP || record.AfterFailures >= config.GetLoginGuardConfig().SuspiciousFailures
+	}
+
+	if err := db.Create(record).Error; err != nil {
+		return fmt.Errorf("保存登录记录失败: %v", err)
+	}
+	record.SoftwareList = util.ParseBracketList(record.Softwares)
+
+	s.prune()
+	return nil
+}
+
+// ListHistory 分页查询登录记录，按时间倒序
+// usernames: 需要查询的代理账号
+// onlySuspicious: 是否只返回可疑登录
+// page, limit: 分页参数
+// 返回: 登录记录、总数和可能的错误
+func (s *LoginHistoryService) ListHistory(usernames []string, onlySuspicious bool, page, limit int) ([]models.LoginHistory, int64, error) {
+	if len(usernames) == 0 {
+		return []models.LoginHistory{}, 0, nil
+	}
+
+	db, err := s.dbManager.GetWebDB()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	query := db.Model(&models.LoginHistory{}).Where("Username IN ?", usernames)
+	if onlySuspicious {
+		query = query.Where("Suspicious = ?", true)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("查询登录记录失败: %v", err)
+	}
+
+	var records []models.LoginHistory
+	err = query.Order("CreatedAt DESC").Order("ID DESC").
+		Offset((page - 1) * limit).Limit(limit).
+		Find(&records).Error
+	if err != nil {
+		return nil, 0, fmt.Errorf("查询登录记录失败: %v", err)
+	}
+	for i := range records {
+		records[i].SoftwareList = util.ParseBracketList(records[i].Softwares)
+	}
+
+	return records, total, nil
+}
+
+// SubtreeUsernames 获取上级代理可以查看登录记录的下级代理账号
+// software: 软件位名称（用于校验上下级关系）
+// parentUser: 上级代理账号
+// targetUser: 指定的下级代理账号，为空表示全部下级代理
+// 返回: 代理账号列表和可能的错误
+func (s *LoginHistoryService) SubtreeUsernames(software, parentUser, targetUser string) ([]string, error) {
+	db, err := s.dbManager.GetSoftwareDB(software)
+	if err != nil {
+		return nil, err
+	}
+
+	if targetUser != "" {
+		if _, err := findOwnedSubAgent(db, parentUser, targetUser); err != nil {
+			return nil, err
+		}
+		return []string{targetUser}, nil
+	}
+
+	agents, err := findSubtreeAgents(db, parentUser)
+	if err != nil {
+		return nil, err
+	}
+
+	usernames := make([]string, 0, len(agents))
+	for i := range agents {
+		usernames = append(usernames, agents[i].User)
+	}
+
+	return usernames, nil
+}
+
+// prune 清理超过保留天数的登录记录，每小时最多执行一次
+func (s *LoginHistoryService) prune() {
+	s.mutex.Lock()
+	if time.Since(s.lastPrune) < time.Hour {
+		s.mutex.Unlock()
+		return
+	}
+	s.lastPrune = time.Now()
+	s.mutex.Unlock()
+
+	db, err := s.dbManager.GetWebDB()
+	if err != nil {
+		return
+	}
+
+	before := time.Now().AddDate(0, 0, -config.GetLoginGuardConfig().HistoryDays).Unix()
+	if err := db.Where("CreatedAt < ?", before).Delete(&models.LoginHistory{}).Error; err != nil {
+		log.Printf("清理登录记录失败: %v", err)
+	}
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"SProtectAgentWeb/config"
+	"sync"
+	"time"
+)
+
+// LoginLimiter 登录失败限制器
+// 分别按账号和客户端IP统计连续失败次数：
+// 每次失败后需要等待的时间按退避基数逐次翻倍，达到失败次数上限后锁定一段时间
+// 计数保存在内存中，程序重启后清零
+type LoginLimiter struct {
+	guard     config.LoginGuardConfig
+	mutex     sync.Mutex
+	accounts  map[string]*loginFailure
+	ips       map[string]*loginFailure
+	lastPrune time.Time
+}
+
+// loginFailure 单个账号或IP的失败记录
+type loginFailure struct {
+	count        int       // 连续失败次数
+	lastFailure  time.Time // 最后一次失败时间
+	blockedUntil time.Time // 在此之前拒绝登录
+}
+
+// NewLoginLimiter 创建登录失败限制器，阈值读取自配置文件的[登录保护]
+func NewLoginLimiter() *LoginLimiter {
+	return &LoginLimiter{
+		guard:    config.GetLoginGuardConfig(),
+		accounts: make(map[string]*loginFailure),
+		ips:      make(map[string]*loginFailure),
+	}
+}
+
+// Check 检查账号和IP当前是否允许登录
+// 返回: 需要继续等待的时间，0表示允许登录
+func (l *LoginLimiter) Check(username, ip string) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	wait := time.Duration(0)
+	if record, exists := l.accounts[username]; exists && record.blockedUntil.After(now) {
+		wait = record.blockedUntil.Sub(now)
+	}
+	if record, exists := l.ips[ip]; exists && record.blockedUntil.After(now) {
+		if w := record.blockedUntil.Sub(now); w > wait {
+			wait = w
+		}
+	}
+
+	return wait
+}
+
+// Failures 获取账号和IP中较大的连续失败次数
+func (l *LoginLimiter) Failures(username, ip string) int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	count := 0
+	if record := l.active(l.accounts, username, now); record != nil {
+		count = record.count
+	}
+	if record := l.active(l.ips, ip, now); record != nil && record.count > count {
+		count = record.count
+	}
+
+	return count
+}
+
+// RecordFailure 记录一次登录失败
+// 返回: 下一次允许登录前需要等待的时间
+func (l *LoginLimiter) RecordFailure(username, ip string) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.prune(now)
+
+	accountWait := l.fail(l.accounts, username, l.guard.AccountMaxFailures, now)
+	ipWait := l.fail(l.ips, ip, l.guard.IPMaxFailures, now)
+	if ipWait > accountWait {
+		return ipWait
+	}
+	return accountWait
+}
+
+// RecordSuccess 登录成功后清除账号的失败记录
+// IP的失败记录保留到自然过期，防止用一个可登录的账号掩护对其他账号的尝试
+func (l *LoginLimiter) RecordSuccess(username string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	delete(l.accounts, username)
+}
+
+// fail 在指定记录表中累加一次失败并计算等待时间
+func (l *LoginLimiter) fail(records map[string]*loginFailure, key string, maxFailures int, now time.Time) time.Duration {
+	record := l.active(records, key, now)
+	if record == nil {
+		record = &loginFailure{}
+		records[key] = record
+	}
+
+	record.count++
+	record.lastFailure = now
+
+	lockout := time.Duration(l.guard.LockoutSeconds) * time.Second
+	var wait time.Duration
+	if record.count >= maxFailures {
+		// 达到上限，锁定
+		wait = lockout
+	} else {
+		// 指数退避：基数 × 2^(失败次数-1)，不超过锁定时长
+		wait = time.Duration(l.guard.BackoffSeconds) * time.Second
+		for i := 1; i < record.count && wait < lockout; i++ {
+			wait *= 2
+		}
+		if wait > lockout {
+			wait = lockout
+		}
+	}
+
+	record.blockedUntil = now.Add(wait)
+	return wait
+}
+
+// active 获取未过期的失败记录
+// 最后一次失败超过锁定时长后记录作废，失败次数重新计算
+func (l *LoginLimiter) active(records map[string]*loginFailure, key string, now time.Time) *loginFailure {
+	record, exists := records[key]
+	if !exists {
+		return nil
+	}
+
+	expire := time.Duration(l.guard.LockoutSeconds) * time.Second
+	if now.Sub(record.lastFailure) > expire && !record.blockedUntil.After(now) {
+		delete(records, key)
+		return nil
+	}
+
+	return record
+}
+
+// prune 清理过期的失败记录，每分钟最多执行一次
+func (l *LoginLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	l.lastPrune = now
+
+	for key := range l.accounts {
+		l.active(l.accounts, key, now)
+	}
+	for key := range l.ips {
+		l.active(l.ips, key, now)
+	}
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"SProtectAgentWeb/config"
+	"testing"
+	"time"
+)
+
+// newTestLoginLimiter 创建使用固定阈值的登录失败限制器
+func newTestLoginLimiter() *LoginLimiter {
+	return &LoginLimiter{
+		guard: config.LoginGuardConfig{
+			AccountMaxFailures: 5,
+			IPMaxFailures:      8,
+			LockoutSeconds:     600,
+			BackoffSeconds:     2,
+		},
+		accounts: make(map[string]*loginFailure),
+		ips:      make(map[string]*loginFailure),
+	}
+}
+
+func TestLoginLimiterBackoff(t *testing.T) {
+	// 账号上限5次：前4次按2秒翻倍退避，第5次起锁定600秒
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{3, 8 * time.Second},
+		{4, 16 * time.Second},
+		{5, 600 * time.Second},
+		{6, 600 * time.Second},
+	}
+
+	limiter := newTestLoginLimiter()
+	now := time.Unix(1700000000, 0)
+	for _, tt := range tests {
+		got := limiter.fail(limiter.accounts, "agent", limiter.guard.AccountMaxFailures, now)
+		if got != tt.want {
+			t.Errorf("第%d次失败等待 %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLoginLimiterBackoffCappedByLockout(t *testing.T) {
+	limiter := newTestLoginLimiter()
+	limiter.guard.AccountMaxFailures = 100
+	limiter.guard.LockoutSeconds = 30
+
+	now := time.Unix(1700000000, 0)
+	var got time.Duration
+	for i := 0; i < 10; i++ {
+		got = limiter.fail(limiter.accounts, "agent", limiter.guard.AccountMaxFailures, now)
+	}
+	if got != 30*time.Second {
+		t.Errorf("退避等待 %v, want 不超过锁定时长 30s", got)
+	}
+}
+
+func TestLoginLimiterExpiry(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	lockout := 600 * time.Second
+
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		want    bool
+	}{
+		{"锁定期内", lockout - time.Second, true},
+		{"刚好到锁定时长", lockout, true},
+		{"超过锁定时长", lockout + time.Second, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := newTestLoginLimiter()
+			limiter.fail(limiter.accounts, "agent", limiter.guard.AccountMaxFailures, now)
+
+			record := limiter.active(limiter.accounts, "agent", now.Add(tt.elapsed))
+			if got := record != nil; got != tt.want {
+				t.Errorf("记录仍有效 = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoginLimiterCheck(t *testing.T) {
+	limiter := newTestLoginLimiter()
+
+	if wait := limiter.Check("agent", "1.2.3.4"); wait != 0 {
+		t.Fatalf("没有失败记录时 Check() = %v, want 0", wait)
+	}
+
+	limiter.RecordFailure("agent", "1.2.3.4")
+	if wait := limiter.Check("agent", "5.6.7.8"); wait <= 0 {
+		t.Errorf("账号失败后换IP Check() = %v, want > 0", wait)
+	}
+	if wait := limiter.Check("other", "1.2.3.4"); wait <= 0 {
+		t.Errorf("IP失败后换账号 Check() = %v, want > 0", wait)
+	}
+	if got := limiter.Failures("agent", "5.6.7.8"); got != 1 {
+		t.Errorf("Failures() = %d, want 1", got)
+	}
+
+	// 登录成功只清除账号记录，IP记录保留
+	limiter.RecordSuccess("agent")
+	if got := limiter.Failures("agent", "5.6.7.8"); got != 0 {
+		t.Errorf("登录成功后账号 Failures() = %d, want 0", got)
+	}
+	if wait := limiter.Check("agent", "1.2.3.4"); wait <= 0 {
+		t.Errorf("登录成功后同一IP Check() = %v, want > 0", wait)
+	}
+}
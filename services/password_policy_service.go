@@ -0,0 +1,371 @@
+package services
+
+import (
+	"SProtectAgentWeb/config"
+	"SProtectAgentWeb/database"
+	"SProtectAgentWeb/models"
+	"SProtectAgentWeb/types"
+	"SProtectAgentWeb/util"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// PasswordPolicyService 密码策略服务
+// 负责密码强度校验、历史密码记录，以及上级代理设置的"下次登录必须修改密码"要求
+type PasswordPolicyService struct {
+	dbManager *database.DatabaseManager
+}
+
+// NewPasswordPolicyService 创建密码策略服务实例
+func NewPasswordPolicyService(dbManager *database.DatabaseManager) *PasswordPolicyService {
+	return &PasswordPolicyService{
+		dbManager: dbManager,
+	}
+}
+
+// Validate 检查新密码是否符合密码策略
+// 包括长度、字符类别、不能与账号相同、不能与最近使用过的密码相同
+// username: 代理账号
+// password: 新密码
+// 返回: 不符合策略时返回原因
+func (s *PasswordPolicyService) Validate(username, password string) error {
+	policy := config.GetPasswordPolicyConfig()
+	if err := util.CheckPasswordStrength(username, password, policy.MinLength, policy.MinClasses); err != nil {
+		return err
+	}
+
+	db, err := s.dbManager.GetWebDB()
+	if err != nil {
+		return err
+	}
+
+	var history []models.PasswordHistory
+	err = db.Where("Username = ?", username).
+		Order("CreatedAt DESC").Order("ID DESC").
+		Limit(policy.HistoryCount).
+		Find(&history).Error
+	if err != nil {
+		return fmt.Errorf("查询历史密码失败: %v", err)
+	}
+
+	for i := range history {
+		if matchPasswordHistory(&history[i], password) {
+			return fmt.Errorf("不能使用最近%d次使用过的密码", policy.HistoryCount)
+		}
+	}
+
+	return nil
+}
+
+// Remember 记录代理设置的新密码，超过保留数量的旧记录会被删除
+// username: 代理账号
+// password: 新密码
+// 返回: 可能的错误
+func (s *PasswordPolicyService) Remember(username, password string) error {
+	db, err := s.dbManager.GetWebDB()
+	if err != nil {
+		return err
+	}
+
+	hash, err := hashPasswordHistory(password)
+	if err != nil {
+		return err
+	}
+
+	record := &models.PasswordHistory{
+		Username:  username,
+		Hash:      hash,
+		CreatedAt: time.Now().Unix(),
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(record).Error; err != nil {
+			return fmt.Errorf("保存历史密码失败: %v", err)
+		}
+
+		// 只保留最近的记录
+		var keep []uint
+		err := tx.Model(&models.PasswordHistory{}).
+			Where("Username = ?", username).
+			Order("CreatedAt DESC").Order("ID DESC").
+			Limit(config.GetPasswordPolicyConfig().HistoryCount).
+			Pluck("ID", &keep).Error
+		if err != nil {
+			return fmt.Errorf("查询历史密码失败: %v", err)
+		}
+		return tx.Where("Username = ? AND ID NOT IN ?", username, keep).Delete(&models.PasswordHistory{}).Error
+	})
+}
+
+// ErrPasswordInconsistent 修改密码失败后未能把已修改的软件位全部恢复为旧密码，各软件位的密码不一致
+var ErrPasswordInconsistent = errors.New("部分软件位未能恢复旧密码，各软件位的密码不一致")
+
+// ChangePassword 在多个软件位中同时修改代理密码
+// 先校验所有软件位的旧密码，全部通过后再逐个修改；任一软件位修改失败时，已修改的软件位恢复为旧密码
+// 各软件位是独立的数据库，修改并不是原子操作：恢复旧密码同样可能失败，此时返回ErrPasswordInconsistent，
+// 结果中Success为true的软件位仍是新密码，并在所有软件位记录修改密码要求，下次登录后必须重新修改密码使各软件位一致
+// username: 代理账号
+// softwares: 需要修改密码的软件位
+// oldPassword: 旧密码
+// newPassword: 新密码
+// 返回: 各软件位的修改结果和可能的错误（任一软件位失败时返回错误）
+func (s *PasswordPolicyService) ChangePassword(username string, softwares []string, oldPassword, newPassword string) ([]types.PasswordChangeResult, error) {
+	results := make([]types.PasswordChangeResult, len(softwares))
+	dbs := make([]*gorm.DB, len(softwares))
+
+	// 第一步：校验所有软件位的旧密码，任一软件位校验失败则不做任何修改
+	failed := false
+	for i, software := range softwares {
+		results[i].Software = software
+
+		db, err := s.dbManager.GetSoftwareDB(software)
+		if err != nil {
+			results[i].Message = err.Error()
+			failed = true
+			continue
+		}
+		dbs[i] = db
+
+		var agent models.Agent
+		err = db.Where("User = ?", username).First(&agent).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			results[i].Message = "代理不存在"
+			failed = true
+		case err != nil:
+			results[i].Message = fmt.Sprintf("查询代理失败: %v", err)
+			failed = true
+		case subtle.ConstantTimeCompare([]byte(agent.Password), []byte(oldPassword)) != 1:
+			results[i].Message = "旧密码错误"
+			failed = true
+		}
+	}
+	if failed {
+		for i := range results {
+			if results[i].Message == "" {
+				results[i].Message = "其他软件位校验失败，未修改"
+			}
+		}
+		return results, fmt.Errorf("旧密码校验失败，所有软件位均未修改")
+	}
+
+	// 第二步：逐个软件位修改密码
+	for i, software := range softwares {
+		result := dbs[i].Model(&models.Agent{}).
+			Where("User = ? AND Password = ?", username, oldPassword).
+			Update("Password", newPassword)
+		err := result.Error
+		if err == nil && result.RowsAffected == 0 {
+			err = fmt.Errorf("密码已被修改")
+		}
+		if err == nil {
+			results[i].Success = true
+			continue
+		}
+
+		results[i].Message = fmt.Sprintf("修改失败: %v", err)
+		inconsistent := false
+		for j := 0; j < i; j++ {
+			rollback := dbs[j].Model(&models.Agent{}).
+				Where("User = ? AND Password = ?", username, newPassword).
+				Update("Password", oldPassword)
+			if rollback.Error != nil {
+				log.Printf("恢复旧密码失败 [%s/%s]: %v", softwares[j], username, rollback.Error)
+				results[j].Message = fmt.Sprintf("恢复旧密码失败，仍为新密码: %v", rollback.Error)
+				inconsistent = true
+			} else {
+				results[j].Success = false
+				results[j].Message = "已恢复为旧密码"
+			}
+		}
+		for j := i + 1; j < len(results); j++ {
+			results[j].Message = "未修改"
+		}
+		if inconsistent {
+			if err := s.requireResync(username, softwares); err != nil {
+				log.Printf("记录密码不一致失败 [%s]: %v", username, err)
+			}
+			return results, fmt.Errorf("软件位[%s]修改密码失败: %w", software, ErrPasswordInconsistent)
+		}
+		return results, fmt.Errorf("软件位[%s]修改密码失败，其他软件位已恢复为旧密码", software)
+	}
+
+	return results, nil
+}
+
+// IsChangeRequired 代理是否被要求在登录后先修改密码
+// 任一软件位中存在修改密码要求即视为需要修改
+// username: 代理账号
+// softwares: 本次登录可访问的软件位
+func (s *PasswordPolicyService) IsChangeRequired(username string, softwares []string) (bool, error) {
+	return passwordChangeRequired(s.dbManager, username, softwares)
+}
+
+// SetChangeRequirement 上级代理设置子代理下次登录时必须修改密码
+// software: 软件位名称，要求只在该软件位生效
+// parentUser: 上级代理账号
+// targetUser: 子代理账号
+// required: 是否要求修改密码
+// 返回: 可能的错误
+func (s *PasswordPolicyService) SetChangeRequirement(software, parentUser, targetUser string, required bool) error {
+	softwareDB, err := s.dbManager.GetSoftwareDB(software)
+	if err != nil {
+		return err
+	}
+	if _, err := findOwnedSubAgent(softwareDB, parentUser, targetUser); err != nil {
+		return err
+	}
+
+	db, err := s.dbManager.GetWebDB()
+	if err != nil {
+		return err
+	}
+
+	if !required {
+		if err := db.Where("Software = ? AND Username = ?", software, targetUser).Delete(&models.PasswordChangeRequirement{}).Error; err != nil {
+			return fmt.Errorf("取消修改密码要求失败: %v", err)
+		}
+		return nil
+	}
+
+	requirement := &models.PasswordChangeRequirement{
+		Software:   software,
+		Username:   targetUser,
+		RequiredBy: parentUser,
+		CreatedAt:  time.Now().Unix(),
+	}
+	if err := db.Save(requirement).Error; err != nil {
+		return fmt.Errorf("设置修改密码要求失败: %v", err)
+	}
+
+	return nil
+}
+
+// ClearChangeRequirement 代理修改密码后清除修改密码要求
+// username: 代理账号
+// softwares: 已修改密码的软件位
+func (s *PasswordPolicyService) ClearChangeRequirement(username string, softwares []string) error {
+	if len(softwares) == 0 {
+		return nil
+	}
+
+	db, err := s.dbManager.GetWebDB()
+	if err != nil {
+		return err
+	}
+
+	if err := db.Where("Software IN ? AND Username = ?", softwares, username).Delete(&models.PasswordChangeRequirement{}).Error; err != nil {
+		return fmt.Errorf("清除修改密码要求失败: %v", err)
+	}
+	return nil
+}
+
+// requireResync 修改密码后各软件位密码不一致时，在所有软件位记录代理自身设置的修改密码要求
+// 要求持久保存在Web端附属数据库中，代理重新登录后只能访问修改密码接口，修改成功后清除
+func (s *PasswordPolicyService) requireResync(username string, softwares []string) error {
+	db, err := s.dbManager.GetWebDB()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	requirements := make([]models.PasswordChangeRequirement, 0, len(softwares))
+	for _, software := range softwares {
+		requirements = append(requirements, models.PasswordChangeRequirement{
+			Software:   software,
+			Username:   username,
+			RequiredBy: username,
+			CreatedAt:  now,
+		})
+	}
+	if err := db.Save(&requirements).Error; err != nil {
+		return fmt.Errorf("设置修改密码要求失败: %v", err)
+	}
+	return nil
+}
+
+// checkPasswordChangeRequirement 令牌认证时检查修改密码要求
+// 被要求修改密码的代理在修改前不能使用API令牌或JWT
+func checkPasswordChangeRequirement(dbManager *database.DatabaseManager, username string, softwares []string) error {
+	required, err := passwordChangeRequired(dbManager, username, softwares)
+	if err != nil {
+		return err
+	}
+	if required {
+		return fmt.Errorf("上级代理要求修改密码，请先在网页端修改密码")
+	}
+	return nil
+}
+
+// passwordChangeRequired 查询代理在指定软件位中是否存在未完成的修改密码要求
+func passwordChangeRequired(dbManager *database.DatabaseManager, username string, softwares []string) (bool, error) {
+	if len(softwares) == 0 {
+		return false, nil
+	}
+
+	db, err := dbManager.GetWebDB()
+	if err != nil {
+		return false, err
+	}
+
+	var requirement models.PasswordChangeRequirement
+	err = db.Where("Software IN ? AND Username = ?", softwares, username).First(&requirement).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("查询修改密码要求失败: %v", err)
+	}
+
+	return true, nil
+}
+
+// hashPasswordHistory 计算历史密码的bcrypt摘要
+func hashPasswordHistory(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword(bcryptInput(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("计算密码摘要失败: %v", err)
+	}
+	return string(hash), nil
+}
+
+// matchPasswordHistory 判断密码是否与一条历史记录相同
+func matchPasswordHistory(record *models.PasswordHistory, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(record.Hash), bcryptInput(password)) == nil
+}
+
+// bcryptInput 先计算SHA-256再编码，避免bcrypt只使用密码前72字节
+func bcryptInput(password string) []byte {
+	sum := sha256.Sum256([]byte(password))
+	return []byte(base64.StdEncoding.EncodeToString(sum[:]))
+}
+
+// Generate 为代理生成一个符合密码策略的随机密码
+// username: 代理账号（用于历史密码校验）
+// 返回: 随机密码和可能的错误
+func (s *PasswordPolicyService) Generate(username string) (string, error) {
+	length := config.GetPasswordPolicyConfig().MinLength
+	if length < 12 {
+		length = 12
+	}
+
+	var lastErr error
+	for i := 0; i < 5; i++ {
+		password, err := util.GeneratePassword(length)
+		if err != nil {
+			return "", err
+		}
+		if lastErr = s.Validate(username, password); lastErr == nil {
+			return password, nil
+		}
+	}
+	return "", fmt.Errorf("生成随机密码失败: %v", lastErr)
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"SProtectAgentWeb/database"
+	"SProtectAgentWeb/models"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// SessionService 会话管理服务
+// 负责查询、吊销和刷新保存在Web端附属数据库中的登录会话
+type SessionService struct {
+	dbManager *database.DatabaseManager
+}
+
+// NewSessionService 创建会话管理服务实例
+func NewSessionService(dbManager *database.DatabaseManager) *SessionService {
+	return &SessionService{
+		dbManager: dbManager,
+	}
+}
+
+// SessionHandle 根据会话ID生成对外展示的会话标识
+// 真实会话ID不返回给前端，前端只能通过该标识吊销会话
+func SessionHandle(sessionID string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(sum[:8])
+}
+
+// ListSessions 获取代理所有未过期的会话，按最后访问时间倒序
+// username: 代理账号
+// 返回: 会话列表和可能的错误
+func (s *SessionService) ListSessions(username string) ([]models.WebSession, error) {
+	db, err := s.dbManager.GetWebDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []models.WebSession
+	err = db.Select("ID", "Username", "IPAddress", "UserAgent", "LoginTime", "LastSeen", "CreatedAt", "UpdatedAt", "ExpiresAt").
+		Where("Username = ? AND ExpiresAt >= ?", username, time.Now().Unix()).
+		Order("LastSeen DESC").
+		Find(&sessions).Error
+	if err != nil {
+		return nil, fmt.Errorf("查询会话列表失败: %v", err)
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession 吊销代理自己的某个会话
+// username: 代理账号
+// handle: 会话标识（SessionHandle的返回值）
+// 返回: 被吊销的会话ID和可能的错误
+func (s *SessionService) RevokeSession(username, handle string) (string, error) {
+	sessions, err := s.ListSessions(username)
+	if err != nil {
+		return "", err
+	}
+
+	for _, session := range sessions {
+		if SessionHandle(session.ID) != handle {
+			continue
+		}
+
+		db, err := s.dbManager.GetWebDB()
+		if err != nil {
+			return "", err
+		}
+		if err := db.Where("ID = ?", session.ID).Delete(&models.WebSession{}).Error; err != nil {
+			return "", fmt.Errorf("吊销会话失败: %v", err)
+		}
+		return session.ID, nil
+	}
+
+	return "", fmt.Errorf("会话不存在或已过期")
+}
+
+// RevokeAllSessions 吊销代理的所有会话
+// username: 代理账号
+// exceptID: 需要保留的会话ID（通常为当前会话），为空表示全部吊销
+// 返回: 被吊销的会话数量和可能的错误
+func (s *SessionService) RevokeAllSessions(username, exceptID string) (int64, error) {
+	db, err := s.dbManager.GetWebDB()
+	if err != nil {
+		return 0, err
+	}
+
+	query := db.Where("Username = ?", username)
+	if exceptID != "" {
+		query = query.Where("ID <> ?", exceptID)
+	}
+
+	result := query.Delete(&models.WebSession{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("吊销会话失败: %v", result.Error)
+	}
+
+	return result.RowsAffected, nil
+}
+
+// RevokeSubAgentSessions 上级代理强制下线子代理
+// 会话按代理账号记录，子代理在所有软件位的登录都会被吊销
+// software: 软件位名称（用于校验上下级关系）
+// parentUser: 上级代理账号
+// targetUser: 子代理账号
+// 返回: 被吊销的会话数量和可能的错误
+func (s *SessionService) RevokeSubAgentSessions(software, parentUser, targetUser string) (int64, error) {
+	db, err := s.dbManager.GetSoftwareDB(software)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := findOwnedSubAgent(db, parentUser, targetUser); err != nil {
+		return 0, err
+	}
+
+	return s.RevokeAllSessions(targetUser, "")
+}
+
+// ReloadUserSession 重新从所有软件位读取代理信息，用于刷新已登录会话
+// 会话中不保存明文密码，由passwordMatches判断各软件位中代理的密码是否仍与登录时一致
+// username: 代理账号
+// passwordMatches: 密码校验函数
+// 返回: 新的用户会话和可能的错误
+func (s *SessionService) ReloadUserSession(username string, passwordMatches func(password string) bool) (*models.UserSession, error) {
+	dbs, err := s.dbManager.GetAllSoftwareDB()
+	if err != nil {
+		return nil, err
+	}
+
+	softwares := make([]string, 0, len(dbs))
+	for software := range dbs {
+		softwares = append(softwares, software)
+	}
+	sort.Strings(softwares)
+
+	userSession, err := loadSessionAgents(s.dbManager, username, softwares, func(agent *models.Agent) bool {
+		return passwordMatches(agent.Password)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(userSession.SoftwareList) == 0 {
+		return nil, fmt.Errorf("密码已修改或账号已失效，请重新登录")
+	}
+
+	return userSession, nil
+}
@@ -0,0 +1,807 @@
+package services
+
+import (
+	"SProtectAgentWeb/database"
+	"SProtectAgentWeb/models"
+	"SProtectAgentWeb/types"
+	"SProtectAgentWeb/util"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SubAgentService 下级代理管理服务
+// 上级代理对下级链中代理的管理操作，所有操作都会校验上下级关系
+type SubAgentService struct {
+	dbManager *database.DatabaseManager
+	ledger    *LedgerService
+}
+
+// NewSubAgentService 创建下级代理管理服务实例
+func NewSubAgentService(dbManager *database.DatabaseManager, ledger *LedgerService) *SubAgentService {
+	return &SubAgentService{
+		dbManager: dbManager,
+		ledger:    ledger,
+	}
+}
+
+// UpdatePermission 设置下级代理的权限
+// 只能授予或收回上级代理自身拥有的权限位，其余权限位保持原值
+// software: 软件位名称
+// parentUser: 上级代理账号
+// targetUser: 下级代理账号
+// authority: 期望的权限位组合
+// 返回: 新的权限字符串（十六进制）、权限名称列表和可能的错误
+func (s *SubAgentService) UpdatePermission(software, parentUser, targetUser string, authority uint64) (string, []string, error) {
+	if unknown := authority &^ util.AllPermissionBits(); unknown != 0 {
+		return "", nil, fmt.Errorf("未知的权限位: 0x%x", unknown)
+	}
+
+	db, err := s.dbManager.GetSoftwareDB(software)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var newAuthority string
+	var newValue uint64
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// 会话中的权限可能已被上级修改，以数据库中的最新值为准
+		var parent models.Agent
+		err := tx.Where("User = ?", parentUser).First(&parent).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("代理不存在")
+		}
+		if err != nil {
+			return fmt.Errorf("查询代理失败: %v", err)
+		}
+		parentAuthority, err := parent.GetAuthorityUint64()
+		if err != nil {
+			return fmt.Errorf("解析权限失败: %v", err)
+		}
+
+		target, err := findOwnedSubAgent(tx, parentUser, targetUser)
+		if err != nil {
+			return err
+		}
+		targetAuthority, err := target.GetAuthorityUint64()
+		if err != nil {
+			return fmt.Errorf("解析权限失败: %v", err)
+		}
+
+		newAuthority = target.Authority
+		for _, permBit := range bitsOf(util.AllPermissionBits()) {
+			enable := authority&permBit != 0
+			if enable == (targetAuthority&permBit != 0) {
+				continue
+			}
+			if !util.HasPermission(parentAuthority, permBit) {
+				return fmt.Errorf("无权变更权限: %s", util.PermissionNames[permBit])
+			}
+			newAuthority, err = util.SetPermission(newAuthority, permBit, enable)
+			if err != nil {
+				return fmt.Errorf("设置权限失败: %v", err)
+			}
+		}
+
+		newValue, err = util.ParseAuthority(newAuthority)
+		if err != nil {
+			return fmt.Errorf("解析权限失败: %v", err)
+		}
+		if newValue == targetAuthority {
+			return nil
+		}
+
+		result := tx.Model(&models.Agent{}).
+			Where("User = ? AND Authority = ?", targetUser, target.Authority).
+			Update("Authority", newAuthority)
+		if result.Error != nil {
+			return fmt.Errorf("更新权限失败: %v", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("代理权限已被修改，请刷新后重试")
+		}
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	return newAuthority, util.PermissionNameList(newValue), nil
+}
+
+// bitsOf 拆分权限组合为单个权限位，按从低到高排列
+func bitsOf(authority uint64) []uint64 {
+	var bits []uint64
+	for bit := uint64(1); bit != 0 && bit <= authority; bit <<= 1 {
+		if authority&bit != 0 {
+			bits = append(bits, bit)
+		}
+	}
+	return bits
+}
+
+// MoveSubAgent 将下级代理连同其全部下级代理移动到新的上级代理下，并记录审计日志
+// 在同一事务中重写整个分支的FNode，并按新的代理链重新计算总利率
+// 新上级代理必须处于启用状态，且拥有被移动分支中所有代理的制卡权限
+// software: 软件位名称
+// parentUser: 当前代理账号
+// targetUser: 被移动的下级代理账号
+// newParentUser: 新的上级代理账号，必须是当前代理自身或其下级代理
+// ip: 客户端IP
+// 返回: 被移动的代理数量（含被移动代理自身）和可能的错误
+func (s *SubAgentService) MoveSubAgent(software, parentUser, targetUser, newParentUser, ip string) (int, error) {
+	db, err := s.dbManager.GetSoftwareDB(software)
+	if err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	oldParentUser := ""
+	err = db.Transaction(func(tx *gorm.DB) error {
+		target, err := findOwnedSubAgent(tx, parentUser, targetUser)
+		if err != nil {
+			return err
+		}
+		oldParentUser = target.GetParentAgent()
+
+		var newParent *models.Agent
+		if newParentUser == parentUser {
+			var agent models.Agent
+			err = tx.Where("User = ?", parentUser).First(&agent).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("代理不存在")
+			}
+			if err != nil {
+				return fmt.Errorf("查询代理失败: %v", err)
+			}
+			newParent = &agent
+		} else {
+			newParent, err = findOwnedSubAgent(tx, parentUser, newParentUser)
+			if err != nil {
+				return fmt.Errorf("新上级代理无效: %v", err)
+			}
+		}
+
+		// 不能移动到自身或自身的下级代理下，否则代理链会形成环
+		if newParent.User == target.User || newParent.IsChildOf(target.User) {
+			return fmt.Errorf("不能将代理移动到其自身或其下级代理下")
+		}
+		if target.IsDirectChildOf(newParent.User) {
+			return fmt.Errorf("该代理已经是%s的直接下级", newParent.User)
+		}
+		if !newParent.IsValid() {
+			return fmt.Errorf("新上级代理已被禁用、删除或已过期")
+		}
+
+		descendants, err := findSubtreeAgents(tx, target.User)
+		if err != nil {
+			return err
+		}
+		branch := append([]models.Agent{*target}, descendants...)
+
+		// 下级代理的制卡权限不能超出上级，新上级需要拥有分支中出现的所有卡类型
+		var missing []string
+		seen := make(map[string]bool)
+		for i := range branch {
+			for _, cardType := range util.ParseBracketList(branch[i].CardTypeAuthName) {
+				if seen[cardType] {
+					continue
+				}
+				seen[cardType] = true
+				if !newParent.HasCreateCardType(cardType) {
+					missing = append(missing, cardType)
+				}
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("新上级代理没有以下卡类型的制卡权限: %s", strings.Join(missing, "、"))
+		}
+
+		// 先处理上级再处理下级，计算总利率时上级的新值已经确定
+		sort.SliceStable(branch, func(i, j int) bool {
+			return len(util.ParseAgentFNode(branch[i].FNode)) < len(util.ParseAgentFNode(branch[j].FNode))
+		})
+
+		parentChain := util.ParseAgentFNode(newParent.FNode)
+		if len(parentChain) == 0 {
+			parentChain = []string{newParent.User}
+		}
+		targetChain := append(parentChain, target.User)
+		totals := map[string]float64{newParent.User: newParent.TatalParities}
+		for i := range branch {
+			agent := &branch[i]
+			chain := util.ParseAgentFNode(agent.FNode)
+
+			// 以被移动代理为界，之前的部分替换为新的代理链
+			index := -1
+			for j, name := range chain {
+				if name == target.User {
+					index = j
+					break
+				}
+			}
+			if index < 0 {
+				return fmt.Errorf("代理 %s 的代理链异常", agent.User)
+			}
+			newChain := append(append([]string{}, targetChain...), chain[index+1:]...)
+
+			// 代理链中间的代理缺失时，按最近的已处理上级计算
+			parentTotal := newParent.TatalParities
+			for j := len(newChain) - 2; j >= 0; j-- {
+				if value, ok := totals[newChain[j]]; ok {
+					parentTotal = value
+					break
+				}
+			}
+			total := models.CalcTatalParities(parentTotal, agent.Parities)
+			totals[agent.User] = total
+
+			result := tx.Model(&models.Agent{}).
+				Where("User = ? AND FNode = ?", agent.User, agent.FNode).
+				Updates(map[string]interface{}{
+					"FNode":         util.BuildBracketList(newChain),
+					"TatalParities": total,
+				})
+			if result.Error != nil {
+				return fmt.Errorf("更新代理链失败: %v", result.Error)
+			}
+			if result.RowsAffected == 0 {
+				return fmt.Errorf("代理 %s 已被修改，请刷新后重试", agent.User)
+			}
+		}
+
+		moved = len(branch)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	detail := fmt.Sprintf("从%s移动到%s下，共移动%d个代理", oldParentUser, newParentUser, moved)
+	s.audit(software, parentUser, targetUser, models.AgentAuditMove, detail, ip)
+	return moved, nil
+}
+
+// UpdateParities 修改下级代理的返利利率
+// 在同一事务中重新计算该代理及其全部下级代理的总利率，并给出该代理各卡类型制卡价格的变化
+// software: 软件位名称
+// parentUser: 当前代理账号
+// targetUser: 下级代理账号
+// parities: 新的返利利率，不能小于100
+// preview: 是否只预览不保存
+// ip: 客户端IP
+// 返回: 修改结果和可能的错误
+func (s *SubAgentService) UpdateParities(software, parentUser, targetUser string, parities float64, preview bool, ip string) (*types.ParitiesResult, error) {
+	if parities < 100 || math.IsInf(parities, 0) || math.IsNaN(parities) {
+		return nil, fmt.Errorf("返利利率不能小于100")
+	}
+
+	db, err := s.dbManager.GetSoftwareDB(software)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &types.ParitiesResult{Username: targetUser, Preview: preview, Parities: parities}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		target, err := findOwnedSubAgent(tx, parentUser, targetUser)
+		if err != nil {
+			return err
+		}
+		result.OldParities = target.Parities
+
+		// 总利率按直接上级的总利率计算，直接上级缺失时按代理链上最近的上级计算
+		chain := target.GetAgentChain()
+		var ancestors []models.Agent
+		if err := tx.Where("User IN ?", chain).Find(&ancestors).Error; err != nil {
+			return fmt.Errorf("查询上级代理失败: %v", err)
+		}
+		totals := make(map[string]float64, len(ancestors))
+		for i := range ancestors {
+			totals[ancestors[i].User] = ancestors[i].TatalParities
+		}
+
+		descendants, err := findSubtreeAgents(tx, target.User)
+		if err != nil {
+			return err
+		}
+
+		// 先处理上级再处理下级，计算总利率时上级的新值已经确定
+		branch := append([]models.Agent{*target}, descendants...)
+		sort.SliceStable(branch, func(i, j int) bool {
+			return len(util.ParseAgentFNode(branch[i].FNode)) < len(util.ParseAgentFNode(branch[j].FNode))
+		})
+
+		result.Agents = make([]types.ParitiesChange, 0, len(branch))
+		for i := range branch {
+			agent := &branch[i]
+			if agent.User == target.User {
+				agent.Parities = parities
+			}
+
+			agentChain := agent.GetAgentChain()
+			parentTotal := 100.0
+			for j := len(agentChain) - 1; j >= 0; j-- {
+				if value, ok := totals[agentChain[j]]; ok {
+					parentTotal = value
+					break
+				}
+			}
+			total := models.CalcTatalParities(parentTotal, agent.Parities)
+			totals[agent.User] = total
+
+			if agent.User == target.User {
+				result.Prices, err = parityPriceImpact(tx, agent, target.TatalParities, total)
+				if err != nil {
+					return err
+				}
+			}
+			if total == agent.TatalParities && agent.User != target.User {
+				continue
+			}
+			result.Agents = append(result.Agents, types.ParitiesChange{
+				Username:         agent.User,
+				OldTatalParities: agent.TatalParities,
+				TatalParities:    total,
+			})
+			if preview {
+				continue
+			}
+
+			updates := map[string]interface{}{"TatalParities": total}
+			if agent.User == target.User {
+				updates["Parities"] = parities
+			}
+			update := tx.Model(&models.Agent{}).
+				Where("User = ? AND TatalParities = ?", agent.User, agent.TatalParities).
+				Updates(updates)
+			if update.Error != nil {
+				return fmt.Errorf("更新返利利率失败: %v", update.Error)
+			}
+			if update.RowsAffected == 0 {
+				return fmt.Errorf("代理 %s 已被修改，请刷新后重试", agent.User)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !preview {
+		detail := fmt.Sprintf("返利利率%.2f改为%.2f，影响%d个代理的总利率", result.OldParities, result.Parities, len(result.Agents))
+		s.audit(software, parentUser, targetUser, models.AgentAuditParities, detail, ip)
+	}
+	return result, nil
+}
+
+// parityPriceImpact 计算代理可制作的卡类型在总利率变化前后的制卡价格
+func parityPriceImpact(db *gorm.DB, agent *models.Agent, oldTotal, newTotal float64) ([]types.CardTypePriceImpact, error) {
+	names := util.ParseBracketList(agent.CardTypeAuthName)
+	if len(names) == 0 {
+		return []types.CardTypePriceImpact{}, nil
+	}
+
+	var cardTypes []models.CardType
+	if err := db.Where("Name IN ?", names).Order("Name").Find(&cardTypes).Error; err != nil {
+		return nil, fmt.Errorf("查询卡类型失败: %v", err)
+	}
+
+	prices := make([]types.CardTypePriceImpact, 0, len(cardTypes))
+	for i := range cardTypes {
+		prices = append(prices, types.CardTypePriceImpact{
+			Name:      cardTypes[i].Name,
+			BasePrice: cardTypes[i].Price,
+			OldPrice:  cardTypes[i].CalculatePrice(oldTotal),
+			NewPrice:  cardTypes[i].CalculatePrice(newTotal),
+		})
+	}
+	return prices, nil
+}
+
+// agentSortColumns 代理列表允许的排序字段与数据库列的对应关系
+var agentSortColumns = map[string]string{
+	"username":       "User",
+	"balance":        "AccountBalance",
+	"time_stock":     "AccountTime",
+	"parities":       "Parities",
+	"total_parities": "TatalParities",
+	"expiration":     "Duration_",
+	"status":         "Stat",
+}
+
+// ListSubAgents 分页查询下级代理
+// 筛选、排序和分页均在数据库中完成；代理链中的账号都带方括号，使用instr精确匹配，避免LIKE通配符误匹配
+// software: 软件位名称
+// parentUser: 当前代理账号
+// req: 查询条件
+// allLevels: 是否可以查看所有层级的下级代理，为false时只返回直接下级
+// 返回: 当前页的代理、分页信息和可能的错误
+func (s *SubAgentService) ListSubAgents(software, parentUser string, req *types.GetAgentListRequest, allLevels bool) ([]models.Agent, *types.Pagination, error) {
+	db, err := s.dbManager.GetSoftwareDB(software)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var parent models.Agent
+	err = db.Where("User = ?", parentUser).First(&parent).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, fmt.Errorf("代理不存在")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("查询代理失败: %v", err)
+	}
+	parentDepth := len(util.ParseAgentFNode(parent.FNode))
+	if parentDepth == 0 {
+		parentDepth = 1
+	}
+
+	page := req.Page
+	if page < 1 {
+		page = 1
+	}
+	pageSize := req.PageSize
+	if pageSize < 1 || pageSize > 200 {
+		pageSize = 20
+	}
+
+	level := req.Level
+	if !allLevels {
+		level = 1
+	}
+
+	// 下级代理的代理链中，当前代理之后至少还有一级
+	query := db.Model(&models.Agent{}).Where("instr(FNode, ?) > 0", "["+parentUser+"],")
+	if level > 0 {
+		query = query.Where("length(FNode) - length(replace(FNode, '[', '')) = ?", parentDepth+level)
+	}
+	if req.ParentAgent != "" {
+		query = query.Where("instr(FNode, ? || User || ']') > 0", "["+req.ParentAgent+"],[")
+	}
+	if req.Username != "" {
+		if req.SearchType == 1 {
+			query = query.Where("instr(User, ?) > 0", req.Username)
+		} else {
+			query = query.Where("User = ?", req.Username)
+		}
+	}
+
+	now := time.Now().Unix()
+	switch req.Status {
+	case "":
+	case "enabled":
+		query = query.Where("Stat = 0 AND deltm = 0 AND (Duration_ IS NULL OR Duration_ = 0 OR Duration_ > ?)", now)
+	case "disabled":
+		query = query.Where("Stat <> 0 AND deltm = 0")
+	case "expired":
+		query = query.Where("deltm = 0 AND Duration_ > 0 AND Duration_ <= ?", now)
+	case "deleted":
+		query = query.Where("deltm <> 0")
+	default:
+		return nil, nil, fmt.Errorf("未知的状态筛选: %s", req.Status)
+	}
+
+	if req.ExpiringDays > 0 {
+		query = query.Where("deltm = 0 AND Duration_ > ? AND Duration_ <= ?", now, now+int64(req.ExpiringDays)*86400)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, nil, fmt.Errorf("查询下级代理失败: %v", err)
+	}
+
+	column, ok := agentSortColumns[req.SortField]
+	if !ok {
+		column = "User"
+	}
+	direction := "ASC"
+	if strings.EqualFold(req.SortOrder, "desc") {
+		direction = "DESC"
+	}
+	order := column + " " + direction
+	if column != "User" {
+		order += ", User ASC"
+	}
+
+	var agents []models.Agent
+	err = query.Order(order).Offset((page - 1) * pageSize).Limit(pageSize).Find(&agents).Error
+	if err != nil {
+		return nil, nil, fmt.Errorf("查询下级代理失败: %v", err)
+	}
+	for i := range agents {
+		agents[i].CardTypeAuthNameArray = util.ParseBracketList(agents[i].CardTypeAuthName)
+	}
+
+	pagination := &types.Pagination{
+		Total:      total,
+		Page:       page,
+		PageSize:   pageSize,
+		TotalPages: int((total + int64(pageSize) - 1) / int64(pageSize)),
+	}
+
+	return agents, pagination, nil
+}
+
+// ResetPassword 重置下级代理在软件位中的密码，并记录审计日志
+// 密码强度由调用方按密码策略校验，审计日志不记录密码本身
+// software: 软件位名称
+// parentUser: 当前代理账号
+// targetUser: 下级代理账号
+// password: 新密码
+// generated: 新密码是否由系统生成
+// ip: 客户端IP
+// 返回: 可能的错误
+func (s *SubAgentService) ResetPassword(software, parentUser, targetUser, password string, generated bool, ip string) error {
+	db, err := s.dbManager.GetSoftwareDB(software)
+	if err != nil {
+		return err
+	}
+
+	target, err := findOwnedSubAgent(db, parentUser, targetUser)
+	if err != nil {
+		return err
+	}
+
+	if err := db.Model(&models.Agent{}).Where("User = ?", target.User).Update("Password", password).Error; err != nil {
+		return fmt.Errorf("重置密码失败: %v", err)
+	}
+
+	detail := "上级代理设置新密码"
+	if generated {
+		detail = "系统生成随机密码"
+	}
+	s.audit(software, parentUser, targetUser, models.AgentAuditResetPassword, detail, ip)
+	return nil
+}
+
+// audit 记录下级代理管理审计日志，写入失败只记录日志，不影响已完成的操作
+func (s *SubAgentService) audit(software, actor, target, action, detail, ip string) {
+	db, err := s.dbManager.GetWebDB()
+	if err != nil {
+		log.Printf("记录代理审计日志失败: %v", err)
+		return
+	}
+
+	record := &models.AgentAudit{
+		Software:  software,
+		Actor:     actor,
+		Target:    target,
+		Action:    action,
+		Detail:    detail,
+		IPAddress: ip,
+		CreatedAt: time.Now().Unix(),
+	}
+	if err := db.Create(record).Error; err != nil {
+		log.Printf("记录代理审计日志失败: %v", err)
+	}
+}
+
+// DeleteSubAgent 删除下级代理，将其剩余的余额和库存时长退回当前代理，并记录审计日志
+// 只设置删除标记，代理记录和已制作的卡密保留；仍有未删除的下级代理时不能删除
+// software: 软件位名称
+// parentUser: 当前代理账号
+// targetUser: 被删除的下级代理账号
+// ip: 客户端IP
+// 返回: 余额流水中的操作编号和可能的错误
+func (s *SubAgentService) DeleteSubAgent(software, parentUser, targetUser, ip string) (string, error) {
+	var refund ledgerBalance
+	reference, err := s.ledger.Apply(software, parentUser, "删除下级代理 "+targetUser, func(tx *gorm.DB) ([]LedgerChange, error) {
+		target, err := findOwnedSubAgent(tx, parentUser, targetUser)
+		if err != nil {
+			return nil, err
+		}
+		if target.Deltm != 0 {
+			return nil, fmt.Errorf("代理已被删除")
+		}
+
+		descendants, err := findSubtreeAgents(tx, target.User)
+		if err != nil {
+			return nil, err
+		}
+		for i := range descendants {
+			if descendants[i].Deltm == 0 {
+				return nil, fmt.Errorf("该代理还有下级代理，请先删除或移动其下级代理")
+			}
+		}
+
+		usernames := []string{parentUser, target.User}
+		before, err := readBalances(tx, usernames)
+		if err != nil {
+			return nil, err
+		}
+		refund = before[target.User]
+
+		// 条件更新保证删除期间余额未被其他操作修改
+		update := tx.Model(&models.Agent{}).
+			Where("User = ? AND deltm = 0 AND AccountBalance = ? AND AccountTime = ?", target.User, refund.balance, refund.time).
+			Updates(map[string]interface{}{
+				"deltm":          time.Now().Unix(),
+				"AccountBalance": 0,
+				"AccountTime":    0,
+			})
+		if update.Error != nil {
+			return nil, fmt.Errorf("删除代理失败: %v", update.Error)
+		}
+		if update.RowsAffected == 0 {
+			return nil, fmt.Errorf("代理 %s 已被修改，请刷新后重试", target.User)
+		}
+
+		update = tx.Model(&models.Agent{}).
+			Where("User = ?", parentUser).
+			Updates(map[string]interface{}{
+				"AccountBalance": gorm.Expr("AccountBalance + ?", refund.balance),
+				"AccountTime":    gorm.Expr("AccountTime + ?", refund.time),
+			})
+		if update.Error != nil {
+			return nil, fmt.Errorf("退回余额失败: %v", update.Error)
+		}
+		if update.RowsAffected == 0 {
+			return nil, fmt.Errorf("代理不存在")
+		}
+
+		after, err := readBalances(tx, usernames)
+		if err != nil {
+			return nil, err
+		}
+		return []LedgerChange{
+			newLedgerChange(target.User, models.LedgerTypeWithdraw, before[target.User], after[target.User]),
+			newLedgerChange(parentUser, models.LedgerTypeRefund, before[parentUser], after[parentUser]),
+		}, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	detail := fmt.Sprintf("删除下级代理，退回余额%.2f，退回时长%d秒", refund.balance, refund.time)
+	s.audit(software, parentUser, targetUser, models.AgentAuditDelete, detail, ip)
+	return reference, nil
+}
+
+// Withdraw 从下级代理回收余额和库存时长，退回当前代理
+// 余额和库存时长从下级代理等额退回当前代理（与充值相反）；下级代理扣除后不能小于0
+// software: 软件位名称
+// parentUser: 当前代理账号
+// targetUser: 下级代理账号
+// amount: 从下级代理扣除的余额
+// timeSeconds: 从下级代理扣除的库存时长（秒）
+// reason: 回收原因
+// ip: 客户端IP
+// 返回: 回收结果和可能的错误
+func (s *SubAgentService) Withdraw(software, parentUser, targetUser string, amount float64, timeSeconds int, reason, ip string) (*types.WithdrawResult, error) {
+	amount = math.Round(amount*100) / 100
+	if amount < 0 || timeSeconds < 0 || (amount == 0 && timeSeconds == 0) {
+		return nil, fmt.Errorf("请输入回收金额或时长")
+	}
+
+	result := &types.WithdrawResult{Amount: amount, Refund: amount, TimeStock: timeSeconds}
+	reference, err := s.ledger.Apply(software, parentUser, "回收原因: "+reason, func(tx *gorm.DB) ([]LedgerChange, error) {
+		target, err := findOwnedSubAgent(tx, parentUser, targetUser)
+		if err != nil {
+			return nil, err
+		}
+
+		usernames := []string{parentUser, target.User}
+		before, err := readBalances(tx, usernames)
+		if err != nil {
+			return nil, err
+		}
+
+		// 条件更新保证并发回收时不会扣成负数
+		update := tx.Model(&models.Agent{}).
+			Where("User = ? AND AccountBalance >= ? AND AccountTime >= ?", target.User, amount, timeSeconds).
+			Updates(map[string]interface{}{
+				"AccountBalance": gorm.Expr("AccountBalance - ?", amount),
+				"AccountTime":    gorm.Expr("AccountTime - ?", timeSeconds),
+			})
+		if update.Error != nil {
+			return nil, fmt.Errorf("扣除下级代理余额失败: %v", update.Error)
+		}
+		if update.RowsAffected == 0 {
+			return nil, fmt.Errorf("下级代理余额或库存时长不足")
+		}
+
+		update = tx.Model(&models.Agent{}).
+			Where("User = ?", parentUser).
+			Updates(map[string]interface{}{
+				"AccountBalance": gorm.Expr("AccountBalance + ?", result.Refund),
+				"AccountTime":    gorm.Expr("AccountTime + ?", timeSeconds),
+			})
+		if update.Error != nil {
+			return nil, fmt.Errorf("退回余额失败: %v", update.Error)
+		}
+		if update.RowsAffected == 0 {
+			return nil, fmt.Errorf("代理不存在")
+		}
+
+		after, err := readBalances(tx, usernames)
+		if err != nil {
+			return nil, err
+		}
+		result.Balance = after[parentUser].balance
+		result.AccountTime = after[parentUser].time
+		result.TargetBalance = after[target.User].balance
+		result.TargetTimeStock = after[target.User].time
+
+		return []LedgerChange{
+			newLedgerChange(target.User, models.LedgerTypeWithdraw, before[target.User], after[target.User]),
+			newLedgerChange(parentUser, models.LedgerTypeRefund, before[parentUser], after[parentUser]),
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Reference = reference
+
+	detail := fmt.Sprintf("回收余额%.2f，回收时长%d秒，原因：%s", result.Amount, result.TimeStock, reason)
+	s.audit(software, parentUser, targetUser, models.AgentAuditWithdraw, detail, ip)
+	return result, nil
+}
+
+// Recharge 给下级代理充值余额和库存时长
+// 余额和库存时长从当前代理等额转给下级代理，当前代理扣除后不能小于0
+// software: 软件位名称
+// parentUser: 当前代理账号
+// targetUser: 下级代理账号
+// amount: 当前代理支付的余额
+// timeSeconds: 转给下级代理的库存时长（秒）
+// 返回: 余额流水中的操作编号和可能的错误
+func (s *SubAgentService) Recharge(software, parentUser, targetUser string, amount float64, timeSeconds int) (string, error) {
+	amount = math.Round(amount*100) / 100
+	if amount < 0 || timeSeconds < 0 || (amount == 0 && timeSeconds == 0) {
+		return "", fmt.Errorf("请输入充值金额或时长")
+	}
+
+	return s.ledger.Apply(software, parentUser, "充值下级代理 "+targetUser, func(tx *gorm.DB) ([]LedgerChange, error) {
+		target, err := findOwnedSubAgent(tx, parentUser, targetUser)
+		if err != nil {
+			return nil, err
+		}
+
+		usernames := []string{parentUser, target.User}
+		before, err := readBalances(tx, usernames)
+		if err != nil {
+			return nil, err
+		}
+
+		// 条件更新保证并发充值时不会扣成负数
+		update := tx.Model(&models.Agent{}).
+			Where("User = ? AND AccountBalance >= ? AND AccountTime >= ?", parentUser, amount, timeSeconds).
+			Updates(map[string]interface{}{
+				"AccountBalance": gorm.Expr("AccountBalance - ?", amount),
+				"AccountTime":    gorm.Expr("AccountTime - ?", timeSeconds),
+			})
+		if update.Error != nil {
+			return nil, fmt.Errorf("扣除余额失败: %v", update.Error)
+		}
+		if update.RowsAffected == 0 {
+			return nil, fmt.Errorf("余额或库存时长不足")
+		}
+
+		update = tx.Model(&models.Agent{}).
+			Where("User = ?", target.User).
+			Updates(map[string]interface{}{
+				"AccountBalance": gorm.Expr("AccountBalance + ?", amount),
+				"AccountTime":    gorm.Expr("AccountTime + ?", timeSeconds),
+			})
+		if update.Error != nil {
+			return nil, fmt.Errorf("充值失败: %v", update.Error)
+		}
+		if update.RowsAffected == 0 {
+			return nil, fmt.Errorf("代理不存在")
+		}
+
+		after, err := readBalances(tx, usernames)
+		if err != nil {
+			return nil, err
+		}
+		return []LedgerChange{
+			newLedgerChange(parentUser, models.LedgerTypeRecharge, before[parentUser], after[parentUser]),
+			newLedgerChange(target.User, models.LedgerTypeRecharge, before[target.User], after[target.User]),
+		}, nil
+	})
+}
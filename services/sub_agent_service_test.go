@@ -0,0 +1,190 @@
+package services
+
+import (
+	"SProtectAgentWeb/database"
+	"SProtectAgentWeb/models"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// testAgentTree 测试用的代理树，括号内为返利利率和制卡权限
+//
+//	root(100 天卡,月卡)
+//	├── a(110 天卡,月卡)
+//	│   └── b(120 天卡)
+//	│       └── c(100 天卡)
+//	├── d(100 天卡)
+//	├── e(100 无制卡权限)
+//	├── f(100 天卡，已禁用)
+//	└── g(100 天卡，已过期)
+var testAgentTree = []models.Agent{
+	{User: "root", FNode: "[root]", Parities: 100, TatalParities: 100, CardTypeAuthName: "[天卡],[月卡]"},
+	{User: "a", FNode: "[root],[a]", Parities: 110, TatalParities: 110, CardTypeAuthName: "[天卡],[月卡]"},
+	{User: "b", FNode: "[root],[a],[b]", Parities: 120, TatalParities: 132, CardTypeAuthName: "[天卡]"},
+	{User: "c", FNode: "[root],[a],[b],[c]", Parities: 100, TatalParities: 132, CardTypeAuthName: "[天卡]"},
+	{User: "d", FNode: "[root],[d]", Parities: 100, TatalParities: 100, CardTypeAuthName: "[天卡]"},
+	{User: "e", FNode: "[root],[e]", Parities: 100, TatalParities: 100},
+	{User: "f", FNode: "[root],[f]", Parities: 100, TatalParities: 100, CardTypeAuthName: "[天卡]", Stat: 1},
+	{User: "g", FNode: "[root],[g]", Parities: 100, TatalParities: 100, CardTypeAuthName: "[天卡]", Duration_: 1},
+}
+
+// newTestSubAgentService 在临时目录中创建默认软件位数据库并写入测试代理树
+func newTestSubAgentService(t *testing.T) (*SubAgentService, *gorm.DB) {
+	t.Helper()
+	dir := t.TempDir()
+
+	seed, err := gorm.Open(sqlite.Open(filepath.Join(dir, "idc.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("创建测试数据库失败: %v", err)
+	}
+	agents := append([]models.Agent{}, testAgentTree...)
+	if err := seed.AutoMigrate(&models.Agent{}, &models.CardType{}); err != nil {
+		t.Fatalf("创建测试表失败: %v", err)
+	}
+	if err := seed.Create(&agents).Error; err != nil {
+		t.Fatalf("写入测试代理失败: %v", err)
+	}
+	if sqlDB, err := seed.DB(); err == nil {
+		sqlDB.Close()
+	}
+
+	dbManager := database.NewDatabaseManager(dir)
+	t.Cleanup(func() { dbManager.CloseAll() })
+
+	db, err := dbManager.GetSoftwareDB("默认软件")
+	if err != nil {
+		t.Fatalf("连接测试数据库失败: %v", err)
+	}
+	return NewSubAgentService(dbManager, NewLedgerService(dbManager)), db
+}
+
+// loadTestAgents 读取全部代理，按账号索引
+func loadTestAgents(t *testing.T, db *gorm.DB) map[string]models.Agent {
+	t.Helper()
+	var agents []models.Agent
+	if err := db.Find(&agents).Error; err != nil {
+		t.Fatalf("查询代理失败: %v", err)
+	}
+	result := make(map[string]models.Agent, len(agents))
+	for _, agent := range agents {
+		result[agent.User] = agent
+	}
+	return result
+}
+
+func TestMoveSubAgent(t *testing.T) {
+	tests := []struct {
+		name      string
+		target    string
+		newParent string
+		wantErr   string
+	}{
+		{name: "整个分支移动到其他上级下", target: "b", newParent: "d"},
+		{name: "移动到自身下", target: "b", newParent: "b", wantErr: "不能将代理移动到其自身或其下级代理下"},
+		{name: "移动到自己的直接下级下", target: "a", newParent: "b", wantErr: "不能将代理移动到其自身或其下级代理下"},
+		{name: "移动到自己的深层下级下", target: "a", newParent: "c", wantErr: "不能将代理移动到其自身或其下级代理下"},
+		{name: "新上级已禁用", target: "b", newParent: "f", wantErr: "新上级代理已被禁用、删除或已过期"},
+		{name: "新上级已过期", target: "b", newParent: "g", wantErr: "新上级代理已被禁用、删除或已过期"},
+		{name: "新上级缺少分支中的制卡权限", target: "b", newParent: "e", wantErr: "新上级代理没有以下卡类型的制卡权限: 天卡"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, db := newTestSubAgentService(t)
+
+			moved, err := service.MoveSubAgent("默认软件", "root", tt.target, tt.newParent, "127.0.0.1")
+			agents := loadTestAgents(t, db)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("MoveSubAgent() error = %v, want %q", err, tt.wantErr)
+				}
+				// 失败时代理树保持不变
+				for _, original := range testAgentTree {
+					if got := agents[original.User]; got.FNode != original.FNode {
+						t.Errorf("%s FNode = %s, want %s", original.User, got.FNode, original.FNode)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("MoveSubAgent() error = %v", err)
+			}
+
+			// 整个分支的代理链改写到新上级下，总利率按新的代理链重新计算
+			if moved != 2 {
+				t.Errorf("moved = %d, want 2", moved)
+			}
+			want := map[string]models.Agent{
+				"b": {FNode: "[root],[d],[b]", TatalParities: 120},
+				"c": {FNode: "[root],[d],[b],[c]", TatalParities: 120},
+			}
+			for user, w := range want {
+				if got := agents[user]; got.FNode != w.FNode || got.TatalParities != w.TatalParities {
+					t.Errorf("%s = %s/%v, want %s/%v", user, got.FNode, got.TatalParities, w.FNode, w.TatalParities)
+				}
+			}
+
+			// 移动操作记录审计日志
+			webDB, err := service.dbManager.GetWebDB()
+			if err != nil {
+				t.Fatalf("连接Web端数据库失败: %v", err)
+			}
+			var audits []models.AgentAudit
+			if err := webDB.Where("Action = ?", models.AgentAuditMove).Find(&audits).Error; err != nil {
+				t.Fatalf("查询审计日志失败: %v", err)
+			}
+			if len(audits) != 1 || audits[0].Actor != "root" || audits[0].Target != tt.target {
+				t.Errorf("audits = %+v, want one move record of %s by root", audits, tt.target)
+			}
+		})
+	}
+}
+
+func TestUpdateParities(t *testing.T) {
+	tests := []struct {
+		name       string
+		parities   float64
+		wantErr    string
+		wantTotals map[string]float64
+	}{
+		{
+			name: "修改利率后级联重算整个分支的总利率", parities: 150,
+			wantTotals: map[string]float64{"a": 150, "b": 180, "c": 180, "d": 100},
+		},
+		{
+			name: "下限100", parities: 100,
+			wantTotals: map[string]float64{"a": 100, "b": 120, "c": 120},
+		},
+		{name: "低于下限", parities: 99.99, wantErr: "返利利率不能小于100"},
+		{name: "NaN", parities: math.NaN(), wantErr: "返利利率不能小于100"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, db := newTestSubAgentService(t)
+
+			_, err := service.UpdateParities("默认软件", "root", "a", tt.parities, false, "127.0.0.1")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("UpdateParities() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("UpdateParities() error = %v", err)
+			}
+
+			agents := loadTestAgents(t, db)
+			for user, total := range tt.wantTotals {
+				if got := agents[user].TatalParities; got != total {
+					t.Errorf("%s TatalParities = %v, want %v", user, got, total)
+				}
+			}
+		})
+	}
+}
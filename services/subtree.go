@@ -0,0 +1,53 @@
+package services
+
+import (
+	"SProtectAgentWeb/models"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// findOwnedSubAgent 查询子代理并校验其位于上级代理的下级链中
+// db: 软件位数据库连接
+// parentUser: 上级代理账号
+// targetUser: 子代理账号
+// 返回: 子代理信息和可能的错误（代理不存在或不是下级时返回错误）
+func findOwnedSubAgent(db *gorm.DB, parentUser, targetUser string) (*models.Agent, error) {
+	var target models.Agent
+	err := db.Where("User = ?", targetUser).First(&target).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("代理不存在")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询代理失败: %v", err)
+	}
+
+	if target.User == parentUser || !target.IsChildOf(parentUser) {
+		return nil, fmt.Errorf("该代理不是您的下级代理")
+	}
+
+	return &target, nil
+}
+
+// findSubtreeAgents 查询上级代理下级链中的全部代理（不含上级代理自身）
+// db: 软件位数据库连接
+// parentUser: 上级代理账号
+// 返回: 下级代理列表和可能的错误
+func findSubtreeAgents(db *gorm.DB, parentUser string) ([]models.Agent, error) {
+	var agents []models.Agent
+	err := db.Where("FNode LIKE ? AND User <> ?", "%["+parentUser+"]%", parentUser).Find(&agents).Error
+	if err != nil {
+		return nil, fmt.Errorf("查询下级代理失败: %v", err)
+	}
+
+	// LIKE只做粗筛，按代理链精确校验
+	subtree := make([]models.Agent, 0, len(agents))
+	for i := range agents {
+		if agents[i].IsChildOf(parentUser) {
+			subtree = append(subtree, agents[i])
+		}
+	}
+
+	return subtree, nil
+}
@@ -0,0 +1,409 @@
+package services
+
+import (
+	"SProtectAgentWeb/config"
+	"SProtectAgentWeb/database"
+	"SProtectAgentWeb/models"
+	"SProtectAgentWeb/util"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// recoveryCodeCount 每次生成的恢复码数量
+const recoveryCodeCount = 10
+
+// TwoFactorService 二次验证服务
+// TOTP配置保存在Web端附属数据库中，不修改服务端的Agents表
+type TwoFactorService struct {
+	dbManager *database.DatabaseManager
+}
+
+// NewTwoFactorService 创建二次验证服务实例
+func NewTwoFactorService(dbManager *database.DatabaseManager) *TwoFactorService {
+	return &TwoFactorService{
+		dbManager: dbManager,
+	}
+}
+
+// TwoFactorStatus 代理二次验证状态
+type TwoFactorStatus struct {
+	Enabled            bool   `json:"enabled"`             // 是否已启用
+	Required           bool   `json:"required"`            // 是否被上级代理强制要求
+	RequiredBy         string `json:"required_by"`         // 强制要求的上级代理
+	RecoveryCodesCount int    `json:"recovery_codes_left"` // 剩余恢复码数量
+}
+
+// GetStatus 获取代理的二次验证状态
+func (s *TwoFactorService) GetStatus(username string) (*TwoFactorStatus, error) {
+	record, err := s.getRecord(username)
+	if err != nil {
+		return nil, err
+	}
+	requirement, err := s.getRequirement(username)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &TwoFactorStatus{}
+	if record != nil && record.Enabled {
+		status.Enabled = true
+		status.RecoveryCodesCount = len(splitRecoveryCodes(record.RecoveryCodes))
+	}
+	if requirement != nil {
+		status.Required = true
+		status.RequiredBy = requirement.RequiredBy
+	}
+
+	return status, nil
+}
+
+// IsEnabled 代理是否已启用二次验证
+func (s *TwoFactorService) IsEnabled(username string) (bool, error) {
+	record, err := s.getRecord(username)
+	if err != nil {
+		return false, err
+	}
+	return record != nil && record.Enabled, nil
+}
+
+// IsRequired 代理是否被上级代理要求必须启用二次验证
+func (s *TwoFactorService) IsRequired(username string) (bool, error) {
+	requirement, err := s.getRequirement(username)
+	if err != nil {
+		return false, err
+	}
+	return requirement != nil, nil
+}
+
+// BeginEnrollment 开始绑定验证器，生成新的密钥
+// 已启用的代理需要先关闭二次验证才能重新绑定
+// 返回: 密钥、二维码绑定地址和可能的错误
+func (s *TwoFactorService) BeginEnrollment(username string) (string, string, error) {
+	db, err := s.dbManager.GetWebDB()
+	if err != nil {
+		return "", "", err
+	}
+
+	record, err := s.getRecord(username)
+	if err != nil {
+		return "", "", err
+	}
+	if record != nil && record.Enabled {
+		return "", "", fmt.Errorf("已启用二次验证，请先关闭后再重新绑定")
+	}
+
+	secret, err := util.GenerateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	pending := &models.AgentTwoFactor{
+		Username:  username,
+		Secret:    secret,
+		Enabled:   false,
+		CreatedAt: time.Now().Unix(),
+	}
+	if err := db.Save(pending).Error; err != nil {
+		return "", "", fmt.Errorf("保存二次验证配置失败: %v", err)
+	}
+
+	return secret, util.TOTPProvisioningURI(config.GetAppName(), username, secret), nil
+}
+
+// ConfirmEnrollment 输入验证器中的验证码完成绑定
+// 返回: 恢复码（仅此一次明文返回）和可能的错误
+func (s *TwoFactorService) ConfirmEnrollment(username, code string) ([]string, error) {
+	db, err := s.dbManager.GetWebDB()
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := s.getRecord(username)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, fmt.Errorf("请先获取绑定二维码")
+	}
+	if record.Enabled {
+		return nil, fmt.Errorf("已启用二次验证")
+	}
+
+	step := util.ValidateTOTP(record.Secret, code, time.Now())
+	if step < 0 {
+		return nil, fmt.Errorf("验证码错误")
+	}
+
+	codes, hashed, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Model(&models.AgentTwoFactor{}).Where("Username = ?", username).Updates(map[string]interface{}{
+		"Enabled":       true,
+		"RecoveryCodes": hashed,
+		"LastUsedStep":  step,
+		"EnabledAt":     time.Now().Unix(),
+	}).Error
+	if err != nil {
+		return nil, fmt.Errorf("启用二次验证失败: %v", err)
+	}
+
+	return codes, nil
+}
+
+// Verify 校验登录时输入的验证码或恢复码
+// 恢复码使用后立即作废；同一个验证码不能重复使用
+// 返回: 是否通过和可能的错误
+func (s *TwoFactorService) Verify(username, code string) (bool, error) {
+	db, err := s.dbManager.GetWebDB()
+	if err != nil {
+		return false, err
+	}
+
+	record, err := s.getRecord(username)
+	if err != nil {
+		return false, err
+	}
+	if record == nil || !record.Enabled {
+		return false, fmt.Errorf("未启用二次验证")
+	}
+
+	// 先按TOTP验证码校验
+	if step := util.ValidateTOTP(record.Secret, code, time.Now()); step >= 0 {
+		if step <= record.LastUsedStep {
+			return false, nil
+		}
+		err := db.Model(&models.AgentTwoFactor{}).Where("Username = ?", username).
+			Update("LastUsedStep", step).Error
+		if err != nil {
+			return false, fmt.Errorf("更新二次验证状态失败: %v", err)
+		}
+		return true, nil
+	}
+
+	// 再按恢复码校验
+	codes := splitRecoveryCodes(record.RecoveryCodes)
+	hashed := hashRecoveryCode(code)
+	for i, stored := range codes {
+		if stored != hashed {
+			continue
+		}
+		remaining := append(codes[:i:i], codes[i+1:]...)
+		err := db.Model(&models.AgentTwoFactor{}).Where("Username = ?", username).
+			Update("RecoveryCodes", strings.Join(remaining, ",")).Error
+		if err != nil {
+			return false, fmt.Errorf("更新恢复码失败: %v", err)
+		}
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// Disable 关闭二次验证，需要输入当前验证码或恢复码
+// 被上级代理强制要求的代理不能关闭
+func (s *TwoFactorService) Disable(username, code string) error {
+	required, err := s.IsRequired(username)
+	if err != nil {
+		return err
+	}
+	if required {
+		return fmt.Errorf("上级代理要求必须启用二次验证，无法关闭")
+	}
+
+	ok, err := s.Verify(username, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("验证码错误")
+	}
+
+	db, err := s.dbManager.GetWebDB()
+	if err != nil {
+		return err
+	}
+	if err := db.Where("Username = ?", username).Delete(&models.AgentTwoFactor{}).Error; err != nil {
+		return fmt.Errorf("关闭二次验证失败: %v", err)
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes 重新生成恢复码，旧恢复码全部作废
+func (s *TwoFactorService) RegenerateRecoveryCodes(username, code string) ([]string, error) {
+	ok, err := s.Verify(username, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("验证码错误")
+	}
+
+	db, err := s.dbManager.GetWebDB()
+	if err != nil {
+		return nil, err
+	}
+
+	codes, hashed, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	err = db.Model(&models.AgentTwoFactor{}).Where("Username = ?", username).
+		Update("RecoveryCodes", hashed).Error
+	if err != nil {
+		return nil, fmt.Errorf("保存恢复码失败: %v", err)
+	}
+
+	return codes, nil
+}
+
+// SetSubAgentRequirement 上级代理设置子代理是否必须启用二次验证
+// software: 软件位名称（用于校验上下级关系）
+// parentUser: 上级代理账号
+// targetUser: 子代理账号
+// required: 是否强制要求
+func (s *TwoFactorService) SetSubAgentRequirement(software, parentUser, targetUser string, required bool) error {
+	softwareDB, err := s.dbManager.GetSoftwareDB(software)
+	if err != nil {
+		return err
+	}
+
+	target, err := findOwnedSubAgent(softwareDB, parentUser, targetUser)
+	if err != nil {
+		return err
+	}
+
+	existing, err := s.getRequirement(targetUser)
+	if err != nil {
+		return err
+	}
+
+	db, err := s.dbManager.GetWebDB()
+	if err != nil {
+		return err
+	}
+
+	if !required {
+		if existing == nil {
+			return nil
+		}
+		// 更上级代理设置的要求，下级代理无权取消
+		chain := util.ParseAgentFNode(target.FNode)
+		if indexOfAgent(chain, existing.RequiredBy) < indexOfAgent(chain, parentUser) {
+			return fmt.Errorf("该要求由上级代理 [%s] 设置，无权取消", existing.RequiredBy)
+		}
+		if err := db.Where("Username = ?", targetUser).Delete(&models.TwoFactorRequirement{}).Error; err != nil {
+			return fmt.Errorf("取消二次验证要求失败: %v", err)
+		}
+		return nil
+	}
+
+	// 已有要求时保留原设置人，避免下级代理覆盖后自行取消
+	if existing != nil {
+		return nil
+	}
+
+	requirement := &models.TwoFactorRequirement{
+		Username:   targetUser,
+		RequiredBy: parentUser,
+		Software:   software,
+		CreatedAt:  time.Now().Unix(),
+	}
+	if err := db.Create(requirement).Error; err != nil {
+		return fmt.Errorf("设置二次验证要求失败: %v", err)
+	}
+	return nil
+}
+
+// ===== 私有辅助方法 =====
+
+// getRecord 获取代理的二次验证配置，不存在时返回nil
+func (s *TwoFactorService) getRecord(username string) (*models.AgentTwoFactor, error) {
+	db, err := s.dbManager.GetWebDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var record models.AgentTwoFactor
+	err = db.Where("Username = ?", username).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询二次验证配置失败: %v", err)
+	}
+	return &record, nil
+}
+
+// getRequirement 获取代理的强制二次验证要求，不存在时返回nil
+func (s *TwoFactorService) getRequirement(username string) (*models.TwoFactorRequirement, error) {
+	db, err := s.dbManager.GetWebDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var requirement models.TwoFactorRequirement
+	err = db.Where("Username = ?", username).First(&requirement).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询二次验证要求失败: %v", err)
+	}
+	return &requirement, nil
+}
+
+// generateRecoveryCodes 生成一组恢复码
+// 返回: 明文恢复码、逗号分隔的摘要和可能的错误
+func generateRecoveryCodes() ([]string, string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 7)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, "", fmt.Errorf("生成恢复码失败: %v", err)
+		}
+		raw := strings.ToLower(encoding.EncodeToString(buf))[:10]
+		code := raw[:5] + "-" + raw[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	return codes, strings.Join(hashes, ","), nil
+}
+
+// hashRecoveryCode 计算恢复码摘要，忽略大小写、空格和连字符
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// indexOfAgent 获取代理在代理链中的位置，不存在时返回-1
+func indexOfAgent(chain []string, username string) int {
+	for i, agent := range chain {
+		if agent == username {
+			return i
+		}
+	}
+	return -1
+}
+
+// splitRecoveryCodes 拆分保存的恢复码摘要
+func splitRecoveryCodes(value string) []string {
+	if value == "" {
+		return []string{}
+	}
+	return strings.Split(value, ",")
+}
@@ -41,7 +41,9 @@ layui.define(function(exports){
       contentType: 'application/json',
       data: JSON.stringify({
         username: username,
-        password: password
+        password: password,
+        captcha_id: admin.captchaId || '',
+        captcha_code: data.vercode || $('#LAY-user-login-vercode').val() || ''
       }),
       success: function(res){
         layer.close(loadIndex);
@@ -52,7 +54,26 @@ layui.define(function(exports){
             icon: 1,
             time: 1000
           }, function(){
-            location.href = './index.html'; // 后台主页
+            admin.events.enterIndex(res);
+          });
+        } else if(res.code === 2005){
+          // 已启用二次验证，输入验证码
+          admin.events.verifyTwoFactor();
+        } else if(res.code === 2006){
+          // 上级代理要求启用二次验证，先绑定验证器
+          admin.events.setupTwoFactor();
+        } else if(res.code === 2011 || res.code === 2012){
+          // 需要图形验证码，或验证码错误
+          if(res.code === 2012){
+            layer.msg(res.message || '图形验证码错误', {offset: '15px', icon: 2, time: 2000});
+          }
+          admin.events.refreshCaptcha();
+        } else if(res.code === 2008){
+          // 登录失败次数过多，暂时锁定
+          layer.msg(res.message || '登录失败次数过多，请稍后再试', {
+            offset: '15px',
+            icon: 2,
+            time: 3000
           });
         } else {
           layer.msg(res.message || '登录失败', {
@@ -60,6 +81,9 @@ layui.define(function(exports){
             icon: 2,
             time: 2000
           });
+          if(res.data && res.data.captcha_required){
+            admin.events.refreshCaptcha();
+          }
         }
       },
       error: function(xhr){
@@ -73,6 +97,110 @@ layui.define(function(exports){
     });
   };
 
+  // 显示并刷新图形验证码，验证码只能使用一次
+  admin.events.refreshCaptcha = function(){
+    $.ajax({
+      url: '/api/auth/captcha',
+      type: 'POST',
+      contentType: 'application/json',
+      data: '{}',
+      success: function(res){
+        if(res.code !== 0) return;
+        admin.captchaId = res.data.captcha_id;
+        $('#LAY-user-get-vercode').attr('src', res.data.image);
+        $('#LAY-user-login-vercode').val('');
+        $('#LAY-user-login-captcha').show();
+      }
+    });
+  };
+
+  // 登录成功后进入主页，可疑登录先提示
+  admin.events.enterIndex = function(res){
+    var data = res.data || {};
+    var alert = data.alert;
+    if(data.must_change_password){
+      // 上级代理要求修改密码，修改前只能访问修改密码接口
+      alert = (alert ? alert + '<br>' : '') + '上级代理要求修改密码，请先修改密码';
+    }
+    var enter = function(){
+      location.href = './index.html'; // 后台主页
+    };
+    if(alert){
+      layer.alert(alert, {
+        title: '登录提醒',
+        icon: 0
+      }, enter);
+      return;
+    }
+    enter();
+  };
+
+  // 登录第二步：输入二次验证码或恢复码
+  admin.events.verifyTwoFactor = function(){
+    layer.prompt({
+      title: '请输入验证器中的6位验证码或恢复码',
+      formType: 0
+    }, function(code, index){
+      $.ajax({
+        url: '/api/auth/verifyTwoFactor',
+        type: 'POST',
+        contentType: 'application/json',
+        data: JSON.stringify({code: code}),
+        success: function(res){
+          if(res.code === 200){
+            layer.close(index);
+            admin.events.enterIndex(res);
+          } else if(res.code === 2007){
+            layer.msg(res.message || '验证码错误', {icon: 2});
+          } else {
+            layer.close(index);
+            layer.msg(res.message || '验证失败，请重新登录', {icon: 2});
+          }
+        }
+      });
+    });
+  };
+
+  // 登录第二步：绑定验证器
+  admin.events.setupTwoFactor = function(){
+    $.ajax({
+      url: '/api/auth/beginTwoFactor',
+      type: 'POST',
+      contentType: 'application/json',
+      data: '{}',
+      success: function(res){
+        if(res.code !== 0){
+          layer.msg(res.message || '获取绑定信息失败', {icon: 2});
+          return;
+        }
+        layer.prompt({
+          title: '上级代理要求启用二次验证<br>请在验证器中添加密钥：' + res.data.secret + '<br>然后输入6位验证码',
+          formType: 0
+        }, function(code, index){
+          $.ajax({
+            url: '/api/auth/confirmTwoFactor',
+            type: 'POST',
+            contentType: 'application/json',
+            data: JSON.stringify({code: code}),
+            success: function(res){
+              if(res.code !== 0){
+                layer.msg(res.message || '验证码错误', {icon: 2});
+                return;
+              }
+              layer.close(index);
+              layer.alert('请妥善保存以下恢复码，每个只能使用一次：<br>' + res.data.recovery_codes.join('<br>'), {
+                title: '二次验证已启用'
+              }, function(alertIndex){
+                layer.close(alertIndex);
+                admin.events.enterIndex(res);
+              });
+            }
+          });
+        });
+      }
+    });
+  };
+
   //退出
   admin.events.logout = function(){
     layui.layer.confirm('确定要退出吗？', {
@@ -103,6 +231,66 @@ layui.define(function(exports){
     });
   };
 
+  // 只读查看下级代理时显示提示条，可随时退出
+  admin.events.showImpersonation = function(info){
+    if($('#LAY-impersonation-bar').length) return;
+    $('<div id="LAY-impersonation-bar" style="position:fixed;top:0;left:0;right:0;z-index:99999;padding:6px;text-align:center;background:#FF5722;color:#fff;">'
+      + '正在以只读方式查看代理 ' + $('<span>').text(info.target).html() + '（' + $('<span>').text(info.software).html() + '）'
+      + ' <a href="javascript:;" style="color:#fff;text-decoration:underline;">退出查看</a></div>')
+      .appendTo('body')
+      .find('a').on('click', function(){
+        $.ajax({
+          url: '/api/auth/stopImpersonation',
+          type: 'POST',
+          contentType: 'application/json',
+          data: '{}',
+          success: function(){
+            location.href = './index.html';
+          }
+        });
+      });
+  };
+
+  // 读取登录时下发的CSRF令牌
+  var csrfToken = function(){
+    var match = document.cookie.match(/(?:^|;\s*)csrf_token=([^;]*)/);
+    return match ? decodeURIComponent(match[1]) : '';
+  };
+
+  // 所有AJAX请求提交CSRF令牌（使用全局事件，不会被其他模块的ajaxSetup覆盖）
+  $(document).ajaxSend(function(event, xhr) {
+    var token = csrfToken();
+    if (token) {
+      xhr.setRequestHeader('X-CSRF-Token', token);
+    }
+  });
+
+  // 处理只读查看、CSRF令牌失效和要求修改密码的响应
+  $(document).ajaxComplete(function(event, xhr) {
+    var res = xhr.responseJSON;
+    if (!res) return;
+    if (res.impersonation) {
+      // 只读查看下级代理期间显示提示条
+      admin.events.showImpersonation(res.impersonation);
+    }
+    if (xhr.status === 403 && res.code === 2013) {
+      // CSRF令牌无效（会话在升级前建立或已被替换），需要重新登录
+      layer.msg(res.message || '页面已过期，请重新登录', {
+        offset: '15px',
+        icon: 2,
+        time: 2000
+      }, function() {
+        location.href = './login.html';
+      });
+    } else if (xhr.status === 403 && res.code === 2009) {
+      // 上级代理要求修改密码
+      layer.alert(res.message || '请先修改密码', {
+        title: '提示',
+        icon: 0
+      });
+    }
+  });
+
   // 添加全局 AJAX 拦截器处理未授权响应
   $.ajaxSetup({
     complete: function(xhr, status) {
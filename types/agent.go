@@ -22,13 +22,16 @@ type AgentStats struct {
 type GetAgentListRequest struct {
 	Software    string `json:"software" binding:"required"` // 软件位名称
 	Username    string `json:"username"`                    // 代理用户名筛选
-	Status      string `json:"status"`                      // 状态筛选
-	Level       int    `json:"level"`                       // 代理等级筛选
-	ParentAgent string `json:"parent_agent"`                // 上级代理筛选
+	SearchType  int    `json:"search_type"`                 // 用户名匹配方式：0-精准搜索，1-模糊搜索
+	Status      string `json:"status"`                      // 状态筛选：enabled、disabled、expired、deleted
+	Level       int    `json:"level"`                       // 代理等级筛选，1表示直接下级，0表示不限
+	ParentAgent string `json:"parent_agent"`                // 上级代理筛选（直接上级）
 	Page        int    `json:"page"`                        // 页码
 	PageSize    int    `json:"page_size"`                   // 每页数量
-	SortField   string `json:"sort_field"`                  // 排序字段
-	SortOrder   string `json:"sort_order"`                  // 排序顺序
+	SortField   string `json:"sort_field"`                  // 排序字段：username、balance、time_stock、parities、total_parities、expiration、status
+	SortOrder   string `json:"sort_order"`                  // 排序顺序：asc、desc
+
+	ExpiringDays int `json:"-"` // 只返回该天数内到期（尚未到期）的代理，0表示不限
 }
 
 // GetAgentListResponse 获取代理列表响应
@@ -41,3 +44,112 @@ type GetAgentListResponse struct {
 type GetAccessibleSoftwaresResponse struct {
 	Softwares []SoftwareAgentInfo `json:"softwares"` // 可访问的软件位列表
 }
+
+// AgentTreeNode 代理树节点
+// Subtotal 汇总节点自身及其全部下级代理，未展开的分支同样计入
+type AgentTreeNode struct {
+	Username    string           `json:"username"`     // 代理账号
+	Parent      string           `json:"parent"`       // 直接上级代理
+	Depth       int              `json:"depth"`        // 相对于树根的层级，树根为0
+	Balance     float64          `json:"balance"`      // 账户余额
+	TimeStock   int              `json:"time_stock"`   // 库存时长
+	Status      string           `json:"status"`       // 代理状态
+	Expiration  int64            `json:"expiration"`   // 到期时间戳，0表示永不过期
+	Remark      string           `json:"remark"`       // 备注
+	TotalCards  int64            `json:"total_cards"`  // 自身制作的卡密数
+	ActiveCards int64            `json:"active_cards"` // 自身制作的激活中卡密数
+	ChildCount  int              `json:"child_count"`  // 直接下级代理数
+	HasChildren bool             `json:"has_children"` // 是否有下级代理
+	Loaded      bool             `json:"loaded"`       // 下级代理是否已加载，未加载的分支以该节点为根再次请求
+	Subtotal    *AgentTreeTotal  `json:"subtotal"`     // 节点及其全部下级代理的汇总
+	Children    []*AgentTreeNode `json:"children"`     // 直接下级代理
+}
+
+// AgentTreeTotal 代理树汇总数据
+type AgentTreeTotal struct {
+	Agents      int     `json:"agents"`       // 代理数（含节点自身）
+	Balance     float64 `json:"balance"`      // 余额合计
+	TimeStock   int     `json:"time_stock"`   // 库存时长合计
+	TotalCards  int64   `json:"total_cards"`  // 卡密数合计
+	ActiveCards int64   `json:"active_cards"` // 激活中卡密数合计
+}
+
+// WithdrawResult 从下级代理回收余额和时长的结果
+type WithdrawResult struct {
+	Amount          float64 `json:"amount"`            // 从下级代理扣除的余额
+	Refund          float64 `json:"refund"`            // 退回当前代理的余额，与扣除的余额相同
+	TimeStock       int     `json:"time_stock"`        // 回收的库存时长（秒）
+	TargetBalance   float64 `json:"target_balance"`    // 下级代理回收后的余额
+	TargetTimeStock int     `json:"target_time_stock"` // 下级代理回收后的库存时长
+	Balance         float64 `json:"balance"`           // 当前代理回收后的余额
+	AccountTime     int     `json:"account_time"`      // 当前代理回收后的库存时长
+	Reference       string  `json:"reference"`         // 余额流水中的操作编号
+}
+
+// ExpiryResult 设置下级代理到期时间的结果
+type ExpiryResult struct {
+	Username   string `json:"username"`   // 代理账号
+	Success    bool   `json:"success"`    // 是否成功
+	Message    string `json:"message"`    // 失败原因
+	Expiration int64  `json:"expiration"` // 新的到期时间戳，0表示永不过期
+	Reenabled  bool   `json:"reenabled"`  // 是否恢复了因到期被自动禁用的代理
+}
+
+// SubAgentSpec 创建子代理的参数，单个创建和批量创建共用
+type SubAgentSpec struct {
+	Username       string   `json:"username"`        // 代理账号
+	Password       string   `json:"-"`               // 代理密码，不返回给前端
+	Balance        float64  `json:"balance"`         // 当前代理支付的余额
+	AccountBalance float64  `json:"account_balance"` // 子代理到账的余额（按利率换算，校验时计算）
+	TimeStock      int      `json:"time_stock"`      // 库存时长（秒）
+	Expiration     int64    `json:"expiration"`      // 到期时间戳
+	Parities       float64  `json:"parities"`        // 返利利率
+	Remarks        string   `json:"remarks"`         // 备注
+	CardTypes      []string `json:"card_types"`      // 制卡权限
+}
+
+// BulkAgentRow 批量创建子代理时表格中的一行
+type BulkAgentRow struct {
+	Line int `json:"line"` // 表格中的行号（从1开始，含表头）
+	SubAgentSpec
+	Errors []string `json:"errors,omitempty"` // 校验错误，为空表示该行有效
+}
+
+// BulkAgentResult 批量创建子代理的结果
+type BulkAgentResult struct {
+	DryRun      bool           `json:"dry_run"`      // 是否仅校验
+	Total       int            `json:"total"`        // 数据行数
+	Valid       int            `json:"valid"`        // 校验通过的行数
+	Created     int            `json:"created"`      // 实际创建的代理数量
+	TotalCost   float64        `json:"total_cost"`   // 有效行需要当前代理支付的余额合计
+	TotalTime   int            `json:"total_time"`   // 有效行需要当前代理支付的库存时长合计（秒）
+	Balance     float64        `json:"balance"`      // 当前代理的余额（创建后为扣除后的余额）
+	AccountTime int            `json:"account_time"` // 当前代理的库存时长（创建后为扣除后的时长）
+	Sufficient  bool           `json:"sufficient"`   // 当前代理的余额和库存时长是否足够
+	Rows        []BulkAgentRow `json:"rows"`         // 每一行的校验结果
+}
+
+// ParitiesChange 修改返利利率后一个代理总利率的变化
+type ParitiesChange struct {
+	Username         string  `json:"username"`           // 代理账号
+	OldTatalParities float64 `json:"old_total_parities"` // 修改前的总利率
+	TatalParities    float64 `json:"total_parities"`     // 修改后的总利率
+}
+
+// CardTypePriceImpact 修改返利利率后下级代理制卡价格的变化
+type CardTypePriceImpact struct {
+	Name      string  `json:"name"`       // 卡类型名称
+	BasePrice float64 `json:"base_price"` // 卡类型原价
+	OldPrice  float64 `json:"old_price"`  // 修改前下级代理的制卡价格
+	NewPrice  float64 `json:"new_price"`  // 修改后下级代理的制卡价格
+}
+
+// ParitiesResult 修改下级代理返利利率的结果
+type ParitiesResult struct {
+	Username    string                `json:"username"`     // 下级代理账号
+	Preview     bool                  `json:"preview"`      // 是否仅预览
+	OldParities float64               `json:"old_parities"` // 修改前的返利利率
+	Parities    float64               `json:"parities"`     // 修改后的返利利率
+	Agents      []ParitiesChange      `json:"agents"`       // 总利率发生变化的代理（含下级代理自身）
+	Prices      []CardTypePriceImpact `json:"prices"`       // 下级代理可制作的卡类型价格变化
+}
@@ -6,11 +6,14 @@ import "SProtectAgentWeb/models"
 
 // LoginResponse 登录响应
 type LoginResponse struct {
-	Token           string              `json:"token"`            // JWT Token
-	ExpireTime      int64               `json:"expire_time"`      // Token过期时间
-	Agent           *models.Agent       `json:"agent"`            // 代理信息
-	PrimarySoftware string              `json:"primary_software"` // 主要软件位
-	AccessibleSofts []SoftwareAgentInfo `json:"accessible_softs"` // 可访问的软件位
+	Token             string              `json:"token"`               // JWT Token
+	ExpireTime        int64               `json:"expire_time"`         // Token过期时间
+	RefreshToken      string              `json:"refresh_token"`       // 刷新Token
+	RefreshExpireTime int64               `json:"refresh_expire_time"` // 刷新Token过期时间
+	Agent             *models.Agent       `json:"agent"`               // 代理信息
+	PrimarySoftware   string              `json:"primary_software"`    // 主要软件位
+	AccessibleSofts   []SoftwareAgentInfo `json:"accessible_softs"`    // 可访问的软件位
+	Alert             string              `json:"alert,omitempty"`     // 可疑登录提示
 }
 
 // RefreshTokenResponse 刷新Token响应
@@ -20,11 +23,17 @@ type RefreshTokenResponse struct {
 }
 
 // ChangePasswordRequest 修改密码请求
+// 账号从当前会话获取，密码在会话中的所有软件位同时修改
 type ChangePasswordRequest struct {
-	Username    string `json:"username" binding:"required"`     // 用户名
 	OldPassword string `json:"old_password" binding:"required"` // 旧密码
 	NewPassword string `json:"new_password" binding:"required"` // 新密码
-	Software    string `json:"software" binding:"required"`     // 软件位名称
+}
+
+// PasswordChangeResult 单个软件位的修改密码结果
+type PasswordChangeResult struct {
+	Software string `json:"software"`          // 软件位名称
+	Success  bool   `json:"success"`           // 是否修改成功
+	Message  string `json:"message,omitempty"` // 失败原因
 }
 
 // SoftwareAgentInfo 软件位代理信息
@@ -76,3 +76,12 @@ type GenerateResult struct {
 	SampleCards    []string               `json:"sample_cards"`    // 示例卡密
 	GenerationID   string                 `json:"generation_id"`   // 生成ID
 }
+
+// GenerateCardsResult 生成卡密并扣费的结果
+type GenerateCardsResult struct {
+	Cards     []string `json:"cards"`      // 生成的卡号
+	Count     int      `json:"count"`      // 生成数量
+	UnitPrice float64  `json:"unit_price"` // 按代理总利率折算后的单价
+	TotalCost float64  `json:"total_cost"` // 扣除的余额
+	Reference string   `json:"reference"`  // 余额流水中的操作编号
+}
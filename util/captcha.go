@@ -0,0 +1,176 @@
+package util
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math/big"
+)
+
+// 图形验证码图片尺寸
+const (
+	CaptchaWidth  = 120
+	CaptchaHeight = 40
+)
+
+// captchaScale 字形放大倍数，5x7点阵放大后为15x21像素
+const captchaScale = 3
+
+// captchaGlyphs 数字0-9的5x7点阵字形
+var captchaGlyphs = [10][7]string{
+	{".###.", "#...#", "#..##", "#.#.#", "##..#", "#...#", ".###."},
+	{"..#..", ".##..", "..#..", "..#..", "..#..", "..#..", ".###."},
+	{".###.", "#...#", "....#", "...#.", "..#..", ".#...", "#####"},
+	{"#####", "...#.", "..#..", "...#.", "....#", "#...#", ".###."},
+	{"...#.", "..##.", ".#.#.", "#..#.", "#####", "...#.", "...#."},
+	{"#####", "#....", "####.", "....#", "....#", "#...#", ".###."},
+	{"..##.", ".#...", "#....", "####.", "#...#", "#...#", ".###."},
+	{"#####", "....#", "...#.", "..#..", ".#...", ".#...", ".#..."},
+	{".###.", "#...#", "#...#", ".###.", "#...#", "#...#", ".###."},
+	{".###.", "#...#", "#...#", ".####", "....#", "...#.", ".##.."},
+}
+
+// RandomDigits 生成指定长度的随机数字串
+func RandomDigits(length int) (string, error) {
+	digits := make([]byte, length)
+	for i := range digits {
+		n, err := randomInt(10)
+		if err != nil {
+			return "", err
+		}
+		digits[i] = byte('0' + n)
+	}
+	return string(digits), nil
+}
+
+// RenderCaptcha 将数字验证码绘制为PNG图片
+// 每个字符随机偏移并使用不同颜色，叠加干扰线和噪点
+// code: 验证码（只能包含数字）
+// 返回: PNG图片数据和可能的错误
+func RenderCaptcha(code string) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, CaptchaWidth, CaptchaHeight))
+
+	background := color.RGBA{R: 240, G: 240, B: 235, A: 255}
+	for y := 0; y < CaptchaHeight; y++ {
+		for x := 0; x < CaptchaWidth; x++ {
+			img.Set(x, y, background)
+		}
+	}
+
+	// 噪点
+	for i := 0; i < CaptchaWidth*CaptchaHeight/12; i++ {
+		x, _ := randomInt(CaptchaWidth)
+		y, _ := randomInt(CaptchaHeight)
+		img.Set(x, y, randomColor(120, 220))
+	}
+
+	// 字符
+	glyphWidth := 5 * captchaScale
+	glyphHeight := 7 * captchaScale
+	step := (CaptchaWidth - 10) / len(code)
+	for i, ch := range code {
+		if ch < '0' || ch > '9' {
+			return nil, fmt.Errorf("验证码只能包含数字")
+		}
+		dx, err := randomInt(step - glyphWidth + 1)
+		if err != nil {
+			return nil, err
+		}
+		dy, err := randomInt(CaptchaHeight - glyphHeight + 1)
+		if err != nil {
+			return nil, err
+		}
+		drawGlyph(img, captchaGlyphs[ch-'0'], 5+i*step+dx, dy, randomColor(20, 110))
+	}
+
+	// 干扰线
+	for i := 0; i < 4; i++ {
+		x0, _ := randomInt(CaptchaWidth / 2)
+		y0, _ := randomInt(CaptchaHeight)
+		x1, _ := randomInt(CaptchaWidth / 2)
+		y1, _ := randomInt(CaptchaHeight)
+		drawLine(img, x0, y0, x1+CaptchaWidth/2, y1, randomColor(60, 160))
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("生成验证码图片失败: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// drawGlyph 在指定位置按放大倍数绘制点阵字形，每行随机水平错位形成倾斜效果
+func drawGlyph(img *image.RGBA, glyph [7]string, left, top int, c color.Color) {
+	slant, _ := randomInt(3)
+	for row, line := range glyph {
+		shift := (6 - row) * (slant - 1) / 2
+		for col, pixel := range line {
+			if pixel != '#' {
+				continue
+			}
+			for py := 0; py < captchaScale; py++ {
+				for px := 0; px < captchaScale; px++ {
+					img.Set(left+shift+col*captchaScale+px, top+row*captchaScale+py, c)
+				}
+			}
+		}
+	}
+}
+
+// drawLine 绘制直线（Bresenham算法）
+func drawLine(img *image.RGBA, x0, y0, x1, y1 int, c color.Color) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	e := dx + dy
+	for {
+		img.Set(x0, y0, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+// randomColor 生成各分量在[min, max)范围内的随机颜色
+func randomColor(min, max int) color.RGBA {
+	r, _ := randomInt(max - min)
+	g, _ := randomInt(max - min)
+	b, _ := randomInt(max - min)
+	return color.RGBA{R: uint8(min + r), G: uint8(min + g), B: uint8(min + b), A: 255}
+}
+
+// randomInt 生成[0, n)范围内的随机整数
+func randomInt(n int) (int, error) {
+	if n <= 1 {
+		return 0, nil
+	}
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, fmt.Errorf("生成随机数失败: %v", err)
+	}
+	return int(v.Int64()), nil
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
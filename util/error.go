@@ -15,6 +15,15 @@ const (
 	CodeTokenExpired       = 2002 // Token过期
 	CodeTokenInvalid       = 2003 // Token无效
 	CodePermissionDenied   = 2004 // 权限拒绝
+	CodeTwoFactorRequired  = 2005 // 需要二次验证
+	CodeTwoFactorSetup     = 2006 // 需要先绑定二次验证
+	CodeTwoFactorInvalid   = 2007 // 二次验证码错误
+	CodeLoginLocked        = 2008 // 登录失败次数过多，暂时锁定
+	CodePasswordExpired    = 2009 // 需要先修改密码
+	CodeImpersonationDeny  = 2010 // 模拟查看为只读模式
+	CodeCaptchaRequired    = 2011 // 需要图形验证码
+	CodeCaptchaInvalid     = 2012 // 图形验证码错误
+	CodeCSRFInvalid        = 2013 // CSRF令牌无效
 
 	// 资源相关错误码 (3xxx)
 	CodeSoftwareNotFound    = 3001 // 软件位不存在
@@ -23,8 +32,9 @@ const (
 	CodeInsufficientBalance = 3005 // 余额不足
 
 	// 系统相关错误码 (9xxx)
-	CodeDatabaseError = 9001 // 数据库错误
-	CodeInternalError = 9999 // 内部错误
+	CodeDatabaseError        = 9001 // 数据库错误
+	CodePasswordInconsistent = 9002 // 修改密码失败且未能全部恢复，各软件位密码不一致
+	CodeInternalError        = 9999 // 内部错误
 )
 
 // GetErrorMessage 根据错误码获取错误消息
@@ -44,6 +54,24 @@ func GetErrorMessage(code int) string {
 		return "无效的登录凭证"
 	case CodePermissionDenied:
 		return "权限不足"
+	case CodeTwoFactorRequired:
+		return "请输入二次验证码"
+	case CodeTwoFactorSetup:
+		return "请先绑定二次验证"
+	case CodeTwoFactorInvalid:
+		return "二次验证码错误"
+	case CodeLoginLocked:
+		return "登录失败次数过多，请稍后再试"
+	case CodePasswordExpired:
+		return "上级代理要求修改密码，请先修改密码"
+	case CodeImpersonationDeny:
+		return "模拟查看为只读模式，不能执行该操作"
+	case CodeCaptchaRequired:
+		return "请输入图形验证码"
+	case CodeCaptchaInvalid:
+		return "图形验证码错误或已过期"
+	case CodeCSRFInvalid:
+		return "页面已过期，请刷新页面或重新登录"
 	case CodeSoftwareNotFound:
 		return "软件位不存在"
 	case CodeCardNotFound:
@@ -54,6 +82,8 @@ func GetErrorMessage(code int) string {
 		return "余额不足"
 	case CodeDatabaseError:
 		return "数据库错误"
+	case CodePasswordInconsistent:
+		return "部分软件位未能恢复旧密码，各软件位的密码不一致，请按修改结果分别处理"
 	case CodeInternalError:
 		return "内部错误"
 	default:
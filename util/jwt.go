@@ -0,0 +1,96 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// JWT令牌类型
+const (
+	JWTTypeAccess  = "access"  // 访问令牌，用于调用接口
+	JWTTypeRefresh = "refresh" // 刷新令牌，只能用于换取新的访问令牌
+)
+
+// jwtHeader 固定的JWT头部，只支持HS256
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// JWTClaims JWT载荷
+type JWTClaims struct {
+	Subject     string   `json:"sub"`           // 代理账号
+	Type        string   `json:"typ"`           // 令牌类型：access/refresh
+	Softwares   []string `json:"sw"`            // 登录时可访问的软件位
+	Fingerprint string   `json:"pfp,omitempty"` // 密码指纹，修改密码后令牌失效
+	IssuedAt    int64    `json:"iat"`           // 签发时间戳
+	ExpiresAt   int64    `json:"exp"`           // 过期时间戳
+}
+
+// SignJWT 使用HS256签名生成JWT
+// claims: 载荷
+// secret: 签名密钥
+// 返回: JWT字符串和可能的错误
+func SignJWT(claims *JWTClaims, secret string) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("生成令牌失败: %v", err)
+	}
+
+	unsigned := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + jwtSignature(unsigned, secret), nil
+}
+
+// ParseJWT 校验JWT签名和有效期并解析载荷
+// token: JWT字符串
+// secret: 签名密钥
+// 返回: 载荷和可能的错误
+func ParseJWT(token, secret string) (*JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("令牌格式错误")
+	}
+
+	// 只接受本服务签发的HS256头部，拒绝alg=none等其他算法
+	if parts[0] != jwtHeader {
+		return nil, fmt.Errorf("不支持的令牌算法")
+	}
+
+	expected := jwtSignature(parts[0]+"."+parts[1], secret)
+	if !hmac.Equal([]byte(parts[2]), []byte(expected)) {
+		return nil, fmt.Errorf("令牌签名无效")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("令牌格式错误")
+	}
+
+	var claims JWTClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("令牌格式错误")
+	}
+
+	if claims.ExpiresAt <= time.Now().Unix() {
+		return nil, fmt.Errorf("令牌已过期")
+	}
+
+	return &claims, nil
+}
+
+// JWTFingerprint 计算密码指纹
+// 指纹写入令牌载荷，校验时与代理当前密码比对，密码修改后已签发的令牌随之失效
+func JWTFingerprint(password, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("password:" + password))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:12])
+}
+
+// jwtSignature 计算HS256签名
+func jwtSignature(unsigned, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
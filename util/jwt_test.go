@@ -0,0 +1,89 @@
+package util
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseJWT(t *testing.T) {
+	const secret = "test-secret"
+	now := time.Now().Unix()
+
+	sign := func(claims *JWTClaims) string {
+		token, err := SignJWT(claims, secret)
+		if err != nil {
+			t.Fatalf("SignJWT: %v", err)
+		}
+		return token
+	}
+	valid := sign(&JWTClaims{Subject: "agent", Type: JWTTypeAccess, IssuedAt: now, ExpiresAt: now + 60})
+	parts := strings.Split(valid, ".")
+
+	// 换用其他算法的头部，签名仍用同一密钥计算
+	withHeader := func(header string) string {
+		encoded := base64.RawURLEncoding.EncodeToString([]byte(header))
+		unsigned := encoded + "." + parts[1]
+		return unsigned + "." + jwtSignature(unsigned, secret)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		secret  string
+		wantErr string
+	}{
+		{"有效令牌", valid, secret, ""},
+		{"密钥错误", valid, "other-secret", "令牌签名无效"},
+		{"篡改签名", parts[0] + "." + parts[1] + "." + strings.Repeat("A", len(parts[2])), secret, "令牌签名无效"},
+		{"篡改载荷", parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin","exp":9999999999}`)) + "." + parts[2], secret, "令牌签名无效"},
+		{"alg为none", base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + parts[1] + ".", secret, "不支持的令牌算法"},
+		{"alg换为HS512", withHeader(`{"alg":"HS512","typ":"JWT"}`), secret, "不支持的令牌算法"},
+		{"头部字段顺序不同", withHeader(`{"typ":"JWT","alg":"HS256"}`), secret, "不支持的令牌算法"},
+		{"段数错误", parts[0] + "." + parts[1], secret, "令牌格式错误"},
+		{"已过期", sign(&JWTClaims{Subject: "agent", IssuedAt: now - 120, ExpiresAt: now - 1}), secret, "令牌已过期"},
+		{"恰好到期", sign(&JWTClaims{Subject: "agent", IssuedAt: now - 60, ExpiresAt: now}), secret, "令牌已过期"},
+		{"签发时间在未来", sign(&JWTClaims{Subject: "agent", IssuedAt: now + 30, ExpiresAt: now + 90}), secret, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := ParseJWT(tt.token, tt.secret)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ParseJWT() error = %v", err)
+				}
+				if claims.Subject != "agent" {
+					t.Errorf("Subject = %q, want %q", claims.Subject, "agent")
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("ParseJWT() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWTFingerprint(t *testing.T) {
+	base := JWTFingerprint("password", "secret")
+	tests := []struct {
+		name     string
+		password string
+		secret   string
+		same     bool
+	}{
+		{"相同密码和密钥", "password", "secret", true},
+		{"密码不同", "Password", "secret", false},
+		{"密钥不同", "password", "secret2", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := JWTFingerprint(tt.password, tt.secret) == base; got != tt.same {
+				t.Errorf("fingerprint equal = %v, want %v", got, tt.same)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// CheckPasswordStrength 检查密码是否满足强度要求
+// username: 代理账号，密码不能与账号相同（不区分大小写）
+// password: 待检查的密码
+// minLength: 最小长度
+// minClasses: 至少包含的字符类别数（小写字母、大写字母、数字、符号）
+// 返回: 不满足要求时返回原因
+func CheckPasswordStrength(username, password string, minLength, minClasses int) error {
+	length := len([]rune(password))
+	if length < minLength {
+		return fmt.Errorf("密码长度不能少于%d个字符", minLength)
+	}
+	if length > 64 {
+		return fmt.Errorf("密码长度不能超过64个字符")
+	}
+
+	if strings.EqualFold(password, username) {
+		return fmt.Errorf("密码不能与账号相同")
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsSpace(r) || unicode.IsControl(r):
+			return fmt.Errorf("密码不能包含空白或控制字符")
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	classes := 0
+	for _, has := range []bool{lower, upper, digit, symbol} {
+		if has {
+			classes++
+		}
+	}
+	if classes < minClasses {
+		return fmt.Errorf("密码需要包含小写字母、大写字母、数字、符号中的至少%d类", minClasses)
+	}
+
+	return nil
+}
+
+// 生成密码使用的字符集，去掉了容易混淆的字符
+const (
+	passwordLower  = "abcdefghijkmnpqrstuvwxyz"
+	passwordUpper  = "ABCDEFGHJKLMNPQRSTUVWXYZ"
+	passwordDigit  = "23456789"
+	passwordSymbol = "!@#$%^&*-_=+?"
+)
+
+// GeneratePassword 生成随机密码，包含小写字母、大写字母、数字和符号各至少一个
+// length: 密码长度，小于4时按4处理
+// 返回: 随机密码和可能的错误
+func GeneratePassword(length int) (string, error) {
+	if length < 4 {
+		length = 4
+	}
+
+	sets := []string{passwordLower, passwordUpper, passwordDigit, passwordSymbol}
+	all := strings.Join(sets, "")
+
+	password := make([]byte, length)
+	for i := range password {
+		charset := all
+		if i < len(sets) {
+			charset = sets[i]
+		}
+		n, err := randomInt(len(charset))
+		if err != nil {
+			return "", err
+		}
+		password[i] = charset[n]
+	}
+
+	// 打乱顺序，避免前几位的字符类别固定
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := randomInt(i + 1)
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j] = password[j], password[i]
+	}
+
+	return string(password), nil
+}
@@ -2,6 +2,7 @@ package util
 
 import (
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -294,3 +295,32 @@ func GenerateChildFNode(parentFNode string, childUsername string) string {
 
 	return result.String()
 }
+
+// PermissionNameList 按权限位从低到高返回已启用的权限名称
+// authority: 权限值（uint64）
+// 返回: 权限名称列表，顺序固定，便于前端展示
+func PermissionNameList(authority uint64) []string {
+	bits := make([]uint64, 0, len(PermissionNames))
+	for permBit := range PermissionNames {
+		bits = append(bits, permBit)
+	}
+	sort.Slice(bits, func(i, j int) bool { return bits[i] < bits[j] })
+
+	permissions := []string{}
+	for _, permBit := range bits {
+		if (authority & permBit) != 0 {
+			permissions = append(permissions, PermissionNames[permBit])
+		}
+	}
+	return permissions
+}
+
+// AllPermissionBits 获取全部已定义权限位的组合
+// 返回: 所有权限位按位或的结果
+func AllPermissionBits() uint64 {
+	var all uint64
+	for permBit := range PermissionNames {
+		all |= permBit
+	}
+	return all
+}
@@ -6,11 +6,34 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// ImpersonationContextKey 模拟查看标记在请求上下文中的键，由会话中间件设置
+const ImpersonationContextKey = "impersonation"
+
 // APIResponse 标准API响应结构
 type APIResponse struct {
-	Code    int         `json:"code"`    // 业务状态码
-	Message string      `json:"message"` // 响应消息
-	Data    interface{} `json:"data"`    // 响应数据
+	Code          int                `json:"code"`                    // 业务状态码
+	Message       string             `json:"message"`                 // 响应消息
+	Data          interface{}        `json:"data"`                    // 响应数据
+	Impersonation *ImpersonationInfo `json:"impersonation,omitempty"` // 模拟查看标记，非模拟查看时不返回
+}
+
+// ImpersonationInfo 模拟查看标记
+// 上级代理以只读方式查看下级代理期间，所有接口响应都会携带
+type ImpersonationInfo struct {
+	Actor    string `json:"actor"`     // 发起模拟查看的上级代理
+	Target   string `json:"target"`    // 被查看的代理
+	Software string `json:"software"`  // 软件位名称
+	ReadOnly bool   `json:"read_only"` // 只读模式
+}
+
+// impersonationFlag 获取当前请求的模拟查看标记，非模拟查看时返回nil
+func impersonationFlag(c *gin.Context) *ImpersonationInfo {
+	value, exists := c.Get(ImpersonationContextKey)
+	if !exists {
+		return nil
+	}
+	info, _ := value.(*ImpersonationInfo)
+	return info
 }
 
 // Success 成功响应
@@ -18,9 +41,10 @@ type APIResponse struct {
 // data: 响应数据
 func Success(c *gin.Context, data interface{}) {
 	c.JSON(http.StatusOK, APIResponse{
-		Code:    CodeSuccess,
-		Message: "操作成功",
-		Data:    data,
+		Code:          CodeSuccess,
+		Message:       "操作成功",
+		Data:          data,
+		Impersonation: impersonationFlag(c),
 	})
 }
 
@@ -30,9 +54,10 @@ func Success(c *gin.Context, data interface{}) {
 // data: 响应数据
 func SuccessWithMessage(c *gin.Context, message string, data interface{}) {
 	c.JSON(http.StatusOK, APIResponse{
-		Code:    CodeSuccess,
-		Message: message,
-		Data:    data,
+		Code:          CodeSuccess,
+		Message:       message,
+		Data:          data,
+		Impersonation: impersonationFlag(c),
 	})
 }
 
@@ -42,9 +67,10 @@ func SuccessWithMessage(c *gin.Context, message string, data interface{}) {
 func Error(c *gin.Context, code int) {
 	message := GetErrorMessage(code)
 	c.JSON(http.StatusOK, APIResponse{
-		Code:    code,
-		Message: message,
-		Data:    nil,
+		Code:          code,
+		Message:       message,
+		Data:          nil,
+		Impersonation: impersonationFlag(c),
 	})
 }
 
@@ -54,9 +80,10 @@ func Error(c *gin.Context, code int) {
 // message: 自定义错误消息
 func ErrorWithMessage(c *gin.Context, code int, message string) {
 	c.JSON(http.StatusOK, APIResponse{
-		Code:    code,
-		Message: message,
-		Data:    nil,
+		Code:          code,
+		Message:       message,
+		Data:          nil,
+		Impersonation: impersonationFlag(c),
 	})
 }
 
@@ -65,9 +92,10 @@ func ErrorWithMessage(c *gin.Context, code int, message string) {
 // message: 错误消息
 func BadRequest(c *gin.Context, message string) {
 	c.JSON(http.StatusOK, APIResponse{
-		Code:    CodeInvalidRequest,
-		Message: message,
-		Data:    nil,
+		Code:          CodeInvalidRequest,
+		Message:       message,
+		Data:          nil,
+		Impersonation: impersonationFlag(c),
 	})
 }
 
@@ -76,9 +104,10 @@ func BadRequest(c *gin.Context, message string) {
 // message: 错误消息
 func Unauthorized(c *gin.Context, message string) {
 	c.JSON(http.StatusOK, APIResponse{
-		Code:    CodeTokenInvalid,
-		Message: message,
-		Data:    nil,
+		Code:          CodeTokenInvalid,
+		Message:       message,
+		Data:          nil,
+		Impersonation: impersonationFlag(c),
 	})
 }
 
@@ -87,9 +116,10 @@ func Unauthorized(c *gin.Context, message string) {
 // message: 错误消息
 func Forbidden(c *gin.Context, message string) {
 	c.JSON(http.StatusOK, APIResponse{
-		Code:    CodePermissionDenied,
-		Message: message,
-		Data:    nil,
+		Code:          CodePermissionDenied,
+		Message:       message,
+		Data:          nil,
+		Impersonation: impersonationFlag(c),
 	})
 }
 
@@ -98,9 +128,10 @@ func Forbidden(c *gin.Context, message string) {
 // message: 错误消息
 func NotFound(c *gin.Context, message string) {
 	c.JSON(http.StatusOK, APIResponse{
-		Code:    CodeCardNotFound,
-		Message: message,
-		Data:    nil,
+		Code:          CodeCardNotFound,
+		Message:       message,
+		Data:          nil,
+		Impersonation: impersonationFlag(c),
 	})
 }
 
@@ -109,9 +140,10 @@ func NotFound(c *gin.Context, message string) {
 // message: 错误消息
 func InternalError(c *gin.Context, message string) {
 	c.JSON(http.StatusOK, APIResponse{
-		Code:    CodeInternalError,
-		Message: message,
-		Data:    nil,
+		Code:          CodeInternalError,
+		Message:       message,
+		Data:          nil,
+		Impersonation: impersonationFlag(c),
 	})
 }
 
@@ -139,9 +171,10 @@ func ValidateRequired(params map[string]interface{}) string {
 
 func Response(c *gin.Context, code int, message string, data interface{}) {
 	c.JSON(http.StatusOK, APIResponse{
-		Code:    code,
-		Message: message,
-		Data:    data,
+		Code:          code,
+		Message:       message,
+		Data:          data,
+		Impersonation: impersonationFlag(c),
 	})
 
 }
@@ -0,0 +1,253 @@
+package util
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// xlsxMaxPartSize XLSX压缩包中单个XML文件解压后的最大字节数，防止压缩炸弹耗尽内存
+const xlsxMaxPartSize = 16 << 20
+
+// xlsxMaxColumns XLSX工作表的最大列数（与Excel一致，XFD列）
+const xlsxMaxColumns = 16384
+
+// ReadTableFile 读取上传的CSV或XLSX表格文件
+// XLSX只读取第一个工作表，空行会被跳过
+// filename: 文件名，用于按扩展名判断文件格式
+// data: 文件内容
+// 返回: 按行排列的单元格文本和可能的错误
+func ReadTableFile(filename string, data []byte) ([][]string, error) {
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".csv":
+		return readCSVRows(data)
+	case ".xlsx":
+		return readXLSXRows(data)
+	default:
+		return nil, fmt.Errorf("不支持的文件格式，请上传CSV或XLSX文件")
+	}
+}
+
+// readCSVRows 读取CSV文件，兼容Excel导出时带的UTF-8 BOM
+func readCSVRows(data []byte) ([][]string, error) {
+	data = bytes.TrimPrefix(data, []byte("\xEF\xBB\xBF"))
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var rows [][]string
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("解析CSV文件失败: %v", err)
+		}
+		if !isBlankRow(record) {
+			rows = append(rows, record)
+		}
+	}
+	return rows, nil
+}
+
+// xlsxRelationships 工作簿关系文件
+type xlsxRelationships struct {
+	Items []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+// xlsxWorkbook 工作簿文件，只关心工作表列表
+type xlsxWorkbook struct {
+	Sheets []struct {
+		RelID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+// xlsxText 共享字符串或内联字符串，富文本由多个片段组成
+type xlsxText struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+// String 拼接文本内容
+func (t xlsxText) String() string {
+	if len(t.Runs) == 0 {
+		return t.T
+	}
+	var builder strings.Builder
+	for _, run := range t.Runs {
+		builder.WriteString(run.T)
+	}
+	return builder.String()
+}
+
+// xlsxSharedStrings 共享字符串表
+type xlsxSharedStrings struct {
+	Items []xlsxText `xml:"si"`
+}
+
+// xlsxSheet 工作表数据
+type xlsxSheet struct {
+	Rows []struct {
+		Cells []struct {
+			Ref    string   `xml:"r,attr"`
+			Type   string   `xml:"t,attr"`
+			Value  string   `xml:"v"`
+			Inline xlsxText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// readXLSXRows 读取XLSX文件第一个工作表的单元格文本
+// 数字和日期按原始存储值返回，日期为Excel序列号
+func readXLSXRows(data []byte) ([][]string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("解析XLSX文件失败: %v", err)
+	}
+
+	files := make(map[string]*zip.File, len(archive.File))
+	for _, file := range archive.File {
+		files[file.Name] = file
+	}
+
+	var shared xlsxSharedStrings
+	if file, ok := files["xl/sharedStrings.xml"]; ok {
+		if err := decodeZipXML(file, &shared); err != nil {
+			return nil, err
+		}
+	}
+
+	file, ok := files[firstSheetPath(files)]
+	if !ok {
+		return nil, fmt.Errorf("解析XLSX文件失败: 找不到工作表")
+	}
+	var sheet xlsxSheet
+	if err := decodeZipXML(file, &sheet); err != nil {
+		return nil, err
+	}
+
+	var rows [][]string
+	for _, row := range sheet.Rows {
+		var record []string
+		for i, cell := range row.Cells {
+			// 空单元格不会写入文件，按单元格引用定位列
+			column := xlsxColumnIndex(cell.Ref)
+			if column < 0 {
+				column = i
+			}
+			if column >= xlsxMaxColumns {
+				return nil, fmt.Errorf("解析XLSX文件失败: 单元格引用 %s 超出范围", cell.Ref)
+			}
+			for len(record) <= column {
+				record = append(record, "")
+			}
+
+			switch cell.Type {
+			case "s":
+				var index int
+				if _, err := fmt.Sscan(cell.Value, &index); err == nil && index >= 0 && index < len(shared.Items) {
+					record[column] = shared.Items[index].String()
+				}
+			case "inlineStr":
+				record[column] = cell.Inline.String()
+			default:
+				record[column] = cell.Value
+			}
+		}
+		if !isBlankRow(record) {
+			rows = append(rows, record)
+		}
+	}
+	return rows, nil
+}
+
+// firstSheetPath 按工作簿中的顺序找到第一个工作表的路径
+func firstSheetPath(files map[string]*zip.File) string {
+	const fallback = "xl/worksheets/sheet1.xml"
+
+	workbookFile, ok := files["xl/workbook.xml"]
+	relsFile, relsOK := files["xl/_rels/workbook.xml.rels"]
+	if !ok || !relsOK {
+		return fallback
+	}
+
+	var workbook xlsxWorkbook
+	var rels xlsxRelationships
+	if decodeZipXML(workbookFile, &workbook) != nil || decodeZipXML(relsFile, &rels) != nil || len(workbook.Sheets) == 0 {
+		return fallback
+	}
+
+	for _, rel := range rels.Items {
+		if rel.ID != workbook.Sheets[0].RelID {
+			continue
+		}
+		if strings.HasPrefix(rel.Target, "/") {
+			return strings.TrimPrefix(rel.Target, "/")
+		}
+		return path.Join("xl", rel.Target)
+	}
+	return fallback
+}
+
+// decodeZipXML 解析压缩包中的XML文件
+// 解压后的大小超过xlsxMaxPartSize时拒绝解析；压缩包头中的大小可以伪造，读取时同样限制字节数
+func decodeZipXML(file *zip.File, v interface{}) error {
+	if file.UncompressedSize64 > xlsxMaxPartSize {
+		return fmt.Errorf("解析XLSX文件失败: %s 内容过大", file.Name)
+	}
+
+	reader, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("解析XLSX文件失败: %v", err)
+	}
+	defer reader.Close()
+
+	limited := &io.LimitedReader{R: reader, N: xlsxMaxPartSize + 1}
+	err = xml.NewDecoder(limited).Decode(v)
+	if limited.N <= 0 {
+		return fmt.Errorf("解析XLSX文件失败: %s 内容过大", file.Name)
+	}
+	if err != nil {
+		return fmt.Errorf("解析XLSX文件失败: %v", err)
+	}
+	return nil
+}
+
+// xlsxColumnIndex 将单元格引用（如C12）转换为从0开始的列号，无法识别时返回-1
+func xlsxColumnIndex(ref string) int {
+	column := 0
+	letters := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' || letters == 3 {
+			break
+		}
+		column = column*26 + int(r-'A') + 1
+		letters++
+	}
+	if letters == 0 {
+		return -1
+	}
+	return column - 1
+}
+
+// isBlankRow 判断一行是否所有单元格都为空
+func isBlankRow(record []string) bool {
+	for _, value := range record {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
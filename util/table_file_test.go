@@ -0,0 +1,31 @@
+package util
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReadTableFileXLSXPartSize(t *testing.T) {
+	sheet := `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>` +
+		strings.Repeat(" ", xlsxMaxPartSize) + `</sheetData></worksheet>`
+
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	w, err := writer.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		t.Fatalf("创建压缩包文件失败: %v", err)
+	}
+	if _, err := w.Write([]byte(sheet)); err != nil {
+		t.Fatalf("写入压缩包文件失败: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("生成压缩包失败: %v", err)
+	}
+
+	_, err = ReadTableFile("agents.xlsx", buf.Bytes())
+	if err == nil || !strings.Contains(err.Error(), "内容过大") {
+		t.Fatalf("ReadTableFile() error = %v, want %q", err, "内容过大")
+	}
+}
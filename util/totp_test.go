@@ -0,0 +1,75 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret RFC 6238附录B中SHA1测试向量的密钥"12345678901234567890"
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// 期望值取RFC 6238测试向量的后6位
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		got, err := TOTPCode(rfc6238Secret, TOTPStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("TOTPCode(%d): %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("TOTPCode(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+
+	if _, err := TOTPCode("not base32!", 1); err == nil {
+		t.Error("TOTPCode() with invalid secret should fail")
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	step := TOTPStep(now)
+	code := func(offset int64) string {
+		c, err := TOTPCode(rfc6238Secret, step+offset)
+		if err != nil {
+			t.Fatalf("TOTPCode: %v", err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		want   int64
+	}{
+		{"当前时间步", rfc6238Secret, code(0), step},
+		{"前后空格", rfc6238Secret, " " + code(0) + " ", step},
+		{"小写密钥", "gezdgnbvgy3tqojqgezdgnbvgy3tqojqgezdgnbvgy3tqojq"[:32], code(0), step},
+		{"客户端慢一个时间步", rfc6238Secret, code(-1), step - 1},
+		{"客户端快一个时间步", rfc6238Secret, code(1), step + 1},
+		{"慢两个时间步", rfc6238Secret, code(-2), -1},
+		{"快两个时间步", rfc6238Secret, code(2), -1},
+		{"位数不足", rfc6238Secret, code(0)[:5], -1},
+		{"位数过多", rfc6238Secret, code(0) + "0", -1},
+		{"密钥格式错误", "not base32!", code(0), -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ValidateTOTP(tt.secret, tt.code, now); got != tt.want {
+				t.Errorf("ValidateTOTP() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}